@@ -0,0 +1,64 @@
+package controller
+
+import (
+	"sync"
+	"time"
+
+	"k8s.io/utils/clock"
+)
+
+// accessReviewTTL is how long the outcome of an access review is trusted.
+// Permissions granted or revoked in the meantime are only seen once it
+// expires.
+const accessReviewTTL = 5 * time.Minute
+
+// accessReview is the outcome of an access review, the spec it was asked
+// for, and when it expires.
+type accessReview struct {
+	spec    string
+	result  string
+	expires time.Time
+}
+
+// accessReviewCache remembers the outcome of the access reviews of each
+// Evan, so the API server is only asked again once what is reviewed changes
+// or the outcome expires.
+type accessReviewCache struct {
+	mu    sync.Mutex
+	clock clock.Clock
+	store map[string]map[string]accessReview
+}
+
+func newAccessReviewCache() *accessReviewCache {
+	return &accessReviewCache{clock: clock.RealClock{}, store: map[string]map[string]accessReview{}}
+}
+
+// lookup returns the outcome of the named review of the Evan with the given
+// key, if it was recorded for the same spec and has not expired.
+func (r *accessReviewCache) lookup(key, name, spec string) (string, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	review, ok := r.store[key][name]
+	if !ok || review.spec != spec || !r.clock.Now().Before(review.expires) {
+		return "", false
+	}
+	return review.result, true
+}
+
+// record remembers the outcome of the named review of the Evan for
+// accessReviewTTL.
+func (r *accessReviewCache) record(key, name, spec, result string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.store[key] == nil {
+		r.store[key] = map[string]accessReview{}
+	}
+	r.store[key][name] = accessReview{spec: spec, result: result, expires: r.clock.Now().Add(accessReviewTTL)}
+}
+
+// forget drops the reviews of a deleted Evan.
+func (r *accessReviewCache) forget(key string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.store, key)
+}
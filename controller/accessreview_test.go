@@ -0,0 +1,71 @@
+package controller
+
+import (
+	"context"
+	"testing"
+
+	samplev1alpha1 "github.com/evanraisul/k8s-sample-controller/pkg/apis/samplecontroller/v1alpha1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	clocktesting "k8s.io/utils/clock/testing"
+)
+
+func TestAccessReviewCache(t *testing.T) {
+	clock := clocktesting.NewFakeClock(metav1.Now().Time)
+	cache := newAccessReviewCache()
+	cache.clock = clock
+
+	cache.record("default/my-book", "role", "a", "denied")
+	if result, ok := cache.lookup("default/my-book", "role", "a"); !ok || result != "denied" {
+		t.Errorf("lookup() = %q, %v, want the recorded review", result, ok)
+	}
+	if _, ok := cache.lookup("default/my-book", "role", "b"); ok {
+		t.Error("lookup() of another spec found the recorded review")
+	}
+	clock.Step(accessReviewTTL)
+	if _, ok := cache.lookup("default/my-book", "role", "a"); ok {
+		t.Error("lookup() found an expired review")
+	}
+}
+
+func TestCanReferenceDependency(t *testing.T) {
+	Evan := &samplev1alpha1.Evan{
+		ObjectMeta: metav1.ObjectMeta{Name: "my-book", Namespace: "default", Generation: 1},
+	}
+	allowed, reviews := false, 0
+	kubeclientset := fake.NewSimpleClientset()
+	kubeclientset.PrependReactor("create", "subjectaccessreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		reviews++
+		review := action.(k8stesting.CreateAction).GetObject().(*authorizationv1.SubjectAccessReview)
+		review.Status.Allowed = allowed
+		return true, review, nil
+	})
+	clock := clocktesting.NewFakeClock(metav1.Now().Time)
+	c := &Controller{kubeclientset: kubeclientset, accessReviews: newAccessReviewCache()}
+	c.accessReviews.clock = clock
+
+	check := func(want bool, wantReviews int) {
+		t.Helper()
+		got, err := c.canReferenceDependency(context.Background(), Evan, "shared", "database")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want || reviews != wantReviews {
+			t.Errorf("canReferenceDependency() = %v after %d reviews, want %v after %d", got, reviews, want, wantReviews)
+		}
+	}
+	check(false, 1)
+	// The grant is not seen while the denial is cached.
+	allowed = true
+	check(false, 1)
+	clock.Step(accessReviewTTL)
+	check(true, 2)
+	// A revoked grant is seen once the review expires too.
+	allowed = false
+	check(true, 2)
+	clock.Step(accessReviewTTL)
+	check(false, 3)
+}
@@ -0,0 +1,73 @@
+package controller
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+
+	samplev1alpha1 "github.com/evanraisul/k8s-sample-controller/pkg/apis/samplecontroller/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// serviceURL returns a URL for the host and port when the port is known to
+// speak HTTP or HTTPS, either through its appProtocol or its well known
+// number.
+func serviceURL(host string, port corev1.ServicePort) string {
+	scheme := ""
+	switch {
+	case port.AppProtocol != nil && (*port.AppProtocol == "http" || *port.AppProtocol == "https"):
+		scheme = *port.AppProtocol
+	case port.Port == 443:
+		scheme = "https"
+	case port.Port == 80:
+		scheme = "http"
+	case port.Name == "http" || port.Name == "https":
+		scheme = port.Name
+	}
+	if scheme == "" || host == "" {
+		return ""
+	}
+	return fmt.Sprintf("%s://%s", scheme, net.JoinHostPort(host, strconv.Itoa(int(port.Port))))
+}
+
+// serviceAddresses returns the addresses a Service can be reached at: its
+// cluster IP, the node ports allocated to it and the ingress points of its
+// load balancer once they are assigned.
+func serviceAddresses(service *corev1.Service) []samplev1alpha1.EvanAddress {
+	var addresses []samplev1alpha1.EvanAddress
+	for _, port := range service.Spec.Ports {
+		if service.Spec.ClusterIP != "" && service.Spec.ClusterIP != corev1.ClusterIPNone {
+			addresses = append(addresses, samplev1alpha1.EvanAddress{
+				Type: samplev1alpha1.AddressTypeClusterIP,
+				Host: service.Spec.ClusterIP,
+				Port: port.Port,
+				URL:  serviceURL(service.Spec.ClusterIP, port),
+			})
+		}
+		if port.NodePort != 0 {
+			addresses = append(addresses, samplev1alpha1.EvanAddress{
+				Type: samplev1alpha1.AddressTypeNodePort,
+				Port: port.NodePort,
+			})
+		}
+		for _, ingress := range service.Status.LoadBalancer.Ingress {
+			if ingress.IP != "" {
+				addresses = append(addresses, samplev1alpha1.EvanAddress{
+					Type: samplev1alpha1.AddressTypeLoadBalancerIP,
+					Host: ingress.IP,
+					Port: port.Port,
+					URL:  serviceURL(ingress.IP, port),
+				})
+			}
+			if ingress.Hostname != "" {
+				addresses = append(addresses, samplev1alpha1.EvanAddress{
+					Type: samplev1alpha1.AddressTypeLoadBalancerHostname,
+					Host: ingress.Hostname,
+					Port: port.Port,
+					URL:  serviceURL(ingress.Hostname, port),
+				})
+			}
+		}
+	}
+	return addresses
+}
@@ -0,0 +1,216 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+
+	samplev1alpha1 "github.com/evanraisul/k8s-sample-controller/pkg/apis/samplecontroller/v1alpha1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// Adopted is used as part of the Event 'reason' when an Evan takes
+	// control of an existing object
+	Adopted = "Adopted"
+	// Released is used as part of the Event 'reason' when an Evan gives up
+	// control of an object whose labels no longer match
+	Released = "Released"
+
+	// MessageAdopted is the message used for an Event fired when an Evan
+	// takes control of an existing object
+	MessageAdopted = "Adopted %s %s"
+	// MessageReleased is the message used for an Event fired when an Evan
+	// gives up control of an object whose labels no longer match
+	MessageReleased = "Released %s %s because its labels no longer match %s"
+)
+
+// claimAction is what claimObject decided to do with an object.
+type claimAction int
+
+const (
+	// claimKeep leaves the object as it is.
+	claimKeep claimAction = iota
+	// claimAdopt makes the object a child of the Evan.
+	claimAdopt
+	// claimRelease removes the controller reference and the EvanNameLabel
+	// back-reference of the Evan.
+	claimRelease
+)
+
+// claimObject decides, in the manner of the ControllerRefManager of
+// Kubernetes, whether an Evan keeps, adopts or releases an object with the
+// name it computed. A child of the Evan, as told by isEvanChild, is released
+// when its labels no longer match; a WipeOut Evan reclaims a matching child
+// it does not control yet, such as one created while it was a Delete Evan.
+// Other objects are adopted as allowed by spec.adoptionPolicy when their
+// labels match, and otherwise fail the sync with ErrResourceExists.
+func (c *Controller) claimObject(Evan *samplev1alpha1.Evan, object metav1.Object, match map[string]string) (claimAction, error) {
+	matches := labels.SelectorFromSet(match).Matches(labels.Set(object.GetLabels()))
+	deleting := Evan.DeletionTimestamp != nil || object.GetDeletionTimestamp() != nil
+	if isEvanChild(Evan, object) {
+		// A deleting Evan keeps its children until they are garbage collected.
+		if Evan.DeletionTimestamp != nil {
+			return claimKeep, nil
+		}
+		if !matches {
+			return claimRelease, nil
+		}
+		if Evan.Spec.DeletionPolicy == "WipeOut" && !metav1.IsControlledBy(object, Evan) && !deleting {
+			return claimAdopt, nil
+		}
+		return claimKeep, nil
+	}
+
+	policy := Evan.Spec.AdoptionPolicy
+	owner := metav1.GetControllerOfNoCopy(object)
+	adoptable := policy == samplev1alpha1.AdoptionPolicyForce ||
+		(policy == samplev1alpha1.AdoptionPolicyIfUnowned && owner == nil)
+	if adoptable && matches && !deleting {
+		return claimAdopt, nil
+	}
+	msg := fmt.Sprintf(MessageResourceExists, object.GetName())
+	c.recorder.Event(Evan, corev1.EventTypeWarning, ErrResourceExists, msg)
+	return claimKeep, fmt.Errorf("%s", msg)
+}
+
+// adoptObject labels the object with the name of the Evan and, for a WipeOut
+// Evan, makes the Evan its controller.
+func adoptObject(Evan *samplev1alpha1.Evan, object metav1.Object) {
+	objectLabels := map[string]string{}
+	for key, value := range object.GetLabels() {
+		objectLabels[key] = value
+	}
+	objectLabels[EvanNameLabel] = Evan.Name
+	object.SetLabels(objectLabels)
+	object.SetOwnerReferences(adoptedOwnerReferences(Evan, object.GetOwnerReferences()))
+}
+
+// releaseObject removes the Evan from the owner references of the object, and
+// its EvanNameLabel, so neither the Evan nor the sweeper claims it again.
+func releaseObject(Evan *samplev1alpha1.Evan, object metav1.Object) {
+	objectLabels := map[string]string{}
+	for key, value := range object.GetLabels() {
+		if key != EvanNameLabel {
+			objectLabels[key] = value
+		}
+	}
+	object.SetLabels(objectLabels)
+	object.SetOwnerReferences(releasedOwnerReferences(Evan, object.GetOwnerReferences()))
+}
+
+// adoptedOwnerReferences returns the owner references of an object with the
+// Evan as its controller. A previous controller stays an owner.
+func adoptedOwnerReferences(Evan *samplev1alpha1.Evan, references []metav1.OwnerReference) []metav1.OwnerReference {
+	var adopted []metav1.OwnerReference
+	for _, reference := range references {
+		if reference.UID == Evan.UID {
+			continue
+		}
+		if reference.Controller != nil && *reference.Controller {
+			notController := false
+			reference.Controller = &notController
+		}
+		adopted = append(adopted, reference)
+	}
+	return append(adopted, ownerReferences(Evan)...)
+}
+
+// releasedOwnerReferences returns the owner references of an object without
+// the Evan.
+func releasedOwnerReferences(Evan *samplev1alpha1.Evan, references []metav1.OwnerReference) []metav1.OwnerReference {
+	var released []metav1.OwnerReference
+	for _, reference := range references {
+		if reference.UID != Evan.UID {
+			released = append(released, reference)
+		}
+	}
+	return released
+}
+
+// claimDeployment adopts or releases a Deployment as decided by claimObject.
+// A released Deployment is no longer the Evan's, so the sync fails with
+// ErrResourceExists afterwards.
+func (c *Controller) claimDeployment(ctx context.Context, Evan *samplev1alpha1.Evan, deployment *appsv1.Deployment, match map[string]string) (*appsv1.Deployment, error) {
+	action, err := c.claimObject(Evan, deployment, match)
+	if err != nil || action == claimKeep {
+		return deployment, err
+	}
+	deploymentCopy := deployment.DeepCopy()
+	if action == claimAdopt {
+		klog.FromContext(ctx).V(4).Info("Adopt Deployment resource", "deployment", klog.KObj(deployment))
+		adoptObject(Evan, deploymentCopy)
+		deployment, err = c.kubeclientset.AppsV1().Deployments(Evan.Namespace).Update(ctx, deploymentCopy, metav1.UpdateOptions{})
+		if err != nil {
+			return nil, err
+		}
+		c.recorder.Eventf(Evan, corev1.EventTypeNormal, Adopted, MessageAdopted, "Deployment", deployment.Name)
+		return deployment, nil
+	}
+	klog.FromContext(ctx).V(4).Info("Release Deployment resource", "deployment", klog.KObj(deployment))
+	releaseObject(Evan, deploymentCopy)
+	if _, err := c.kubeclientset.AppsV1().Deployments(Evan.Namespace).Update(ctx, deploymentCopy, metav1.UpdateOptions{}); err != nil {
+		return nil, err
+	}
+	c.recorder.Eventf(Evan, corev1.EventTypeNormal, Released, MessageReleased, "Deployment", deployment.Name, labels.Set(match).String())
+	return nil, fmt.Errorf(MessageResourceExists, deployment.Name)
+}
+
+// claimService adopts or releases a Service as decided by claimObject.
+// A released Service is no longer the Evan's, so the sync fails with
+// ErrResourceExists afterwards.
+func (c *Controller) claimService(ctx context.Context, Evan *samplev1alpha1.Evan, service *corev1.Service, match map[string]string) (*corev1.Service, error) {
+	action, err := c.claimObject(Evan, service, match)
+	if err != nil || action == claimKeep {
+		return service, err
+	}
+	serviceCopy := service.DeepCopy()
+	if action == claimAdopt {
+		klog.FromContext(ctx).V(4).Info("Adopt Service resource", "service", klog.KObj(service))
+		adoptObject(Evan, serviceCopy)
+		service, err = c.kubeclientset.CoreV1().Services(Evan.Namespace).Update(ctx, serviceCopy, metav1.UpdateOptions{})
+		if err != nil {
+			return nil, err
+		}
+		c.recorder.Eventf(Evan, corev1.EventTypeNormal, Adopted, MessageAdopted, "Service", service.Name)
+		return service, nil
+	}
+	klog.FromContext(ctx).V(4).Info("Release Service resource", "service", klog.KObj(service))
+	releaseObject(Evan, serviceCopy)
+	if _, err := c.kubeclientset.CoreV1().Services(Evan.Namespace).Update(ctx, serviceCopy, metav1.UpdateOptions{}); err != nil {
+		return nil, err
+	}
+	c.recorder.Eventf(Evan, corev1.EventTypeNormal, Released, MessageReleased, "Service", service.Name, labels.Set(match).String())
+	return nil, fmt.Errorf(MessageResourceExists, service.Name)
+}
+
+// claimStatefulSet adopts or releases a StatefulSet as decided by
+// claimObject. A released StatefulSet is no longer the Evan's, so the sync
+// fails with ErrResourceExists afterwards.
+func (c *Controller) claimStatefulSet(ctx context.Context, Evan *samplev1alpha1.Evan, statefulSet *appsv1.StatefulSet, match map[string]string) (*appsv1.StatefulSet, error) {
+	action, err := c.claimObject(Evan, statefulSet, match)
+	if err != nil || action == claimKeep {
+		return statefulSet, err
+	}
+	statefulSetCopy := statefulSet.DeepCopy()
+	if action == claimAdopt {
+		klog.FromContext(ctx).V(4).Info("Adopt StatefulSet resource", "statefulSet", klog.KObj(statefulSet))
+		adoptObject(Evan, statefulSetCopy)
+		statefulSet, err = c.kubeclientset.AppsV1().StatefulSets(Evan.Namespace).Update(ctx, statefulSetCopy, metav1.UpdateOptions{})
+		if err != nil {
+			return nil, err
+		}
+		c.recorder.Eventf(Evan, corev1.EventTypeNormal, Adopted, MessageAdopted, "StatefulSet", statefulSet.Name)
+		return statefulSet, nil
+	}
+	klog.FromContext(ctx).V(4).Info("Release StatefulSet resource", "statefulSet", klog.KObj(statefulSet))
+	releaseObject(Evan, statefulSetCopy)
+	if _, err := c.kubeclientset.AppsV1().StatefulSets(Evan.Namespace).Update(ctx, statefulSetCopy, metav1.UpdateOptions{}); err != nil {
+		return nil, err
+	}
+	c.recorder.Eventf(Evan, corev1.EventTypeNormal, Released, MessageReleased, "StatefulSet", statefulSet.Name, labels.Set(match).String())
+	return nil, fmt.Errorf(MessageResourceExists, statefulSet.Name)
+}
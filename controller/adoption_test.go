@@ -0,0 +1,256 @@
+package controller
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	samplev1alpha1 "github.com/evanraisul/k8s-sample-controller/pkg/apis/samplecontroller/v1alpha1"
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+	appslisters "k8s.io/client-go/listers/apps/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+// newAdoptionEvan returns an Evan with the deletion and adoption policies.
+func newAdoptionEvan(deletionPolicy samplev1alpha1.DeletionPolicy, adoptionPolicy samplev1alpha1.AdoptionPolicy) *samplev1alpha1.Evan {
+	return &samplev1alpha1.Evan{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              "my-book",
+			Namespace:         "default",
+			UID:               "uid-my-book",
+			CreationTimestamp: metav1.NewTime(time.Unix(1700000000, 0)),
+		},
+		Spec: samplev1alpha1.EvanSpec{
+			DeletionPolicy: deletionPolicy,
+			AdoptionPolicy: adoptionPolicy,
+			ServiceConfig:  samplev1alpha1.ServiceConfig{Port: 80},
+		},
+	}
+}
+
+func TestClaimDeployment(t *testing.T) {
+	isController := true
+	otherOwner := metav1.OwnerReference{
+		APIVersion: "apps/v1",
+		Kind:       "ReplicaSet",
+		Name:       "other",
+		UID:        types.UID("uid-other"),
+		Controller: &isController,
+	}
+	match := map[string]string{"app": "my-book"}
+	// child are the labels of a Deployment the Evan created, foreign the
+	// matching labels of one it did not.
+	child := map[string]string{"app": "my-book", EvanNameLabel: "my-book"}
+	foreign := map[string]string{"app": "my-book"}
+	relabeled := map[string]string{"app": "other", EvanNameLabel: "my-book"}
+
+	tests := []struct {
+		name string
+		Evan *samplev1alpha1.Evan
+		// owned makes the Evan the controller of the Deployment, as far as
+		// its deletion policy sets owner references.
+		owned  bool
+		owners []metav1.OwnerReference
+		labels map[string]string
+		// wantErr is set when the sync has to fail with ErrResourceExists.
+		wantErr        bool
+		wantController types.UID
+		wantNameLabel  bool
+		wantEvent      string
+	}{
+		{
+			name:           "owned Deployment is kept",
+			Evan:           newAdoptionEvan("WipeOut", samplev1alpha1.AdoptionPolicyNever),
+			owned:          true,
+			labels:         child,
+			wantController: "uid-my-book",
+			wantNameLabel:  true,
+		},
+		{
+			name:      "owned Deployment whose labels no longer match is released",
+			Evan:      newAdoptionEvan("WipeOut", samplev1alpha1.AdoptionPolicyNever),
+			owned:     true,
+			labels:    relabeled,
+			wantErr:   true,
+			wantEvent: "Normal Released Released Deployment my-book because its labels no longer match app=my-book",
+		},
+		{
+			name:      "unowned Deployment is refused by default",
+			Evan:      newAdoptionEvan("WipeOut", ""),
+			labels:    foreign,
+			wantErr:   true,
+			wantEvent: "Warning ErrResourceExists Resource \"my-book\" already exists and is not managed by Evan",
+		},
+		{
+			name:           "unowned Deployment is adopted if unowned",
+			Evan:           newAdoptionEvan("WipeOut", samplev1alpha1.AdoptionPolicyIfUnowned),
+			labels:         foreign,
+			wantController: "uid-my-book",
+			wantNameLabel:  true,
+			wantEvent:      "Normal Adopted Adopted Deployment my-book",
+		},
+		{
+			name:           "owned by another controller is refused if unowned",
+			Evan:           newAdoptionEvan("WipeOut", samplev1alpha1.AdoptionPolicyIfUnowned),
+			owners:         []metav1.OwnerReference{otherOwner},
+			labels:         foreign,
+			wantErr:        true,
+			wantController: "uid-other",
+			wantEvent:      "Warning ErrResourceExists Resource \"my-book\" already exists and is not managed by Evan",
+		},
+		{
+			name:           "owned by another controller is taken over by force",
+			Evan:           newAdoptionEvan("WipeOut", samplev1alpha1.AdoptionPolicyForce),
+			owners:         []metav1.OwnerReference{otherOwner},
+			labels:         foreign,
+			wantController: "uid-my-book",
+			wantNameLabel:  true,
+			wantEvent:      "Normal Adopted Adopted Deployment my-book",
+		},
+		{
+			name:      "Deployment with other labels is not adopted",
+			Evan:      newAdoptionEvan("WipeOut", samplev1alpha1.AdoptionPolicyForce),
+			labels:    map[string]string{"app": "other"},
+			wantErr:   true,
+			wantEvent: "Warning ErrResourceExists Resource \"my-book\" already exists and is not managed by Evan",
+		},
+		{
+			name:           "child created as a Delete Evan is reclaimed",
+			Evan:           newAdoptionEvan("WipeOut", samplev1alpha1.AdoptionPolicyNever),
+			labels:         child,
+			wantController: "uid-my-book",
+			wantNameLabel:  true,
+			wantEvent:      "Normal Adopted Adopted Deployment my-book",
+		},
+		{
+			name:          "labeled Deployment of a Delete Evan is kept",
+			Evan:          newAdoptionEvan("Delete", samplev1alpha1.AdoptionPolicyNever),
+			owned:         true,
+			labels:        child,
+			wantNameLabel: true,
+		},
+		{
+			name:      "labeled Deployment of a Delete Evan whose labels no longer match is released",
+			Evan:      newAdoptionEvan("Delete", samplev1alpha1.AdoptionPolicyNever),
+			owned:     true,
+			labels:    relabeled,
+			wantErr:   true,
+			wantEvent: "Normal Released Released Deployment my-book because its labels no longer match app=my-book",
+		},
+		{
+			name:      "unlabeled Deployment is refused by a Delete Evan",
+			Evan:      newAdoptionEvan("Delete", samplev1alpha1.AdoptionPolicyNever),
+			labels:    foreign,
+			wantErr:   true,
+			wantEvent: "Warning ErrResourceExists Resource \"my-book\" already exists and is not managed by Evan",
+		},
+		{
+			name:          "unlabeled Deployment is adopted by a Delete Evan if unowned",
+			Evan:          newAdoptionEvan("Delete", samplev1alpha1.AdoptionPolicyIfUnowned),
+			labels:        foreign,
+			wantNameLabel: true,
+			wantEvent:     "Normal Adopted Adopted Deployment my-book",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			owners := tt.owners
+			if tt.owned {
+				owners = ownerReferences(tt.Evan)
+			}
+			deployment := &appsv1.Deployment{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:            "my-book",
+					Namespace:       "default",
+					Labels:          tt.labels,
+					OwnerReferences: owners,
+				},
+			}
+			c := &Controller{
+				kubeclientset: fake.NewSimpleClientset(deployment),
+				recorder:      record.NewFakeRecorder(10),
+			}
+
+			_, err := c.claimDeployment(context.Background(), tt.Evan, deployment, match)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("claimDeployment() error = %v, want error: %v", err, tt.wantErr)
+			}
+
+			got, err := c.kubeclientset.AppsV1().Deployments("default").Get(context.Background(), "my-book", metav1.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var controller types.UID
+			if owner := metav1.GetControllerOf(got); owner != nil {
+				controller = owner.UID
+			}
+			if controller != tt.wantController {
+				t.Errorf("claimDeployment() controller = %q, want %q", controller, tt.wantController)
+			}
+			if _, ok := got.Labels[EvanNameLabel]; ok != tt.wantNameLabel {
+				t.Errorf("claimDeployment() labels = %v, want %s: %v", got.Labels, EvanNameLabel, tt.wantNameLabel)
+			}
+
+			var event string
+			select {
+			case event = <-c.recorder.(*record.FakeRecorder).Events:
+			default:
+			}
+			if event != tt.wantEvent {
+				t.Errorf("claimDeployment() event = %q, want %q", event, tt.wantEvent)
+			}
+		})
+	}
+}
+
+// TestSyncDeploymentRelease checks that a Deployment the Evan created is
+// released, and not overwritten, once its labels are edited to no longer
+// match, and that the Evan then reports the name as taken.
+func TestSyncDeploymentRelease(t *testing.T) {
+	for _, deletionPolicy := range []samplev1alpha1.DeletionPolicy{"WipeOut", "Delete"} {
+		t.Run(string(deletionPolicy), func(t *testing.T) {
+			Evan := newAdoptionEvan(deletionPolicy, samplev1alpha1.AdoptionPolicyNever)
+			name := generateDeploymentName(Evan.Name, "", Evan.CreationTimestamp.Unix())
+			deployment := newDeployment(Evan, name, evanLabels())
+			deployment.Labels["app"] = "other"
+			c := &Controller{
+				kubeclientset:     fake.NewSimpleClientset(deployment),
+				deploymentsLister: appslisters.NewDeploymentLister(newTestIndexer(t, deployment)),
+				recorder:          record.NewFakeRecorder(10),
+				expectations:      newControllerExpectations(),
+			}
+
+			if _, err := c.syncDeployment(context.Background(), Evan, name); err == nil {
+				t.Fatal("syncDeployment() of a released Deployment succeeded")
+			}
+			got, err := c.kubeclientset.AppsV1().Deployments("default").Get(context.Background(), name, metav1.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if isEvanChild(Evan, got) {
+				t.Errorf("syncDeployment() kept the Deployment, labels = %v, owners = %v", got.Labels, got.OwnerReferences)
+			}
+			if got.Labels["app"] != "other" {
+				t.Errorf("syncDeployment() overwrote the labels of the released Deployment: %v", got.Labels)
+			}
+
+			// The released Deployment is not adopted again.
+			c.deploymentsLister = appslisters.NewDeploymentLister(newTestIndexer(t, got))
+			if _, err := c.syncDeployment(context.Background(), Evan, name); err == nil {
+				t.Fatal("syncDeployment() took the released Deployment back")
+			}
+			events := c.recorder.(*record.FakeRecorder).Events
+			for _, want := range []string{
+				"Normal Released Released Deployment " + name + " because its labels no longer match app=my-book",
+				"Warning ErrResourceExists Resource \"" + name + "\" already exists and is not managed by Evan",
+			} {
+				if event := <-events; event != want {
+					t.Errorf("syncDeployment() event = %q, want %q", event, want)
+				}
+			}
+		})
+	}
+}
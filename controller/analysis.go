@@ -0,0 +1,250 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/evanraisul/k8s-sample-controller/pkg/analysis"
+	samplev1alpha1 "github.com/evanraisul/k8s-sample-controller/pkg/apis/samplecontroller/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// maxAnalysisRuns is the number of analysis runs kept in the Evan status.
+	maxAnalysisRuns = 10
+	// maxMeasurements is the number of measurements kept per analysis run.
+	maxMeasurements = 20
+
+	defaultAnalysisIntervalSeconds   = 30
+	defaultAnalysisInconclusiveLimit = 3
+)
+
+const (
+	// AnalysisSucceeded is used as part of the Event 'reason' when an
+	// analysis run passes
+	AnalysisSucceeded = "AnalysisSucceeded"
+	// AnalysisFailed is used as part of the Event 'reason' when an analysis
+	// run fails
+	AnalysisFailed = "AnalysisFailed"
+	// AnalysisInconclusive is used as part of the Event 'reason' when an
+	// analysis run fails because too many measurements were inconclusive
+	AnalysisInconclusive = "AnalysisInconclusive"
+
+	// MessageAnalysisSucceeded is the message used for an Event fired when an
+	// analysis run passes
+	MessageAnalysisSucceeded = "Analysis %s of image %s succeeded"
+	// MessageAnalysisFailed is the message used for an Event fired when an
+	// analysis run fails
+	MessageAnalysisFailed = "Analysis %s of image %s failed after %d failed measurements"
+	// MessageAnalysisInconclusive is the message used for an Event fired when
+	// an analysis run fails because too many measurements were inconclusive
+	MessageAnalysisInconclusive = "Analysis %s of image %s failed after %d inconclusive measurements"
+)
+
+// runAnalysis takes the next measurement of the named analysis run for the
+// current revision, if one is due, and returns the phase of the run. A run
+// that is still Running is requeued for its next measurement.
+func (c *Controller) runAnalysis(ctx context.Context, Evan *samplev1alpha1.Evan, status *samplev1alpha1.EvanStatus, name string, image string) samplev1alpha1.AnalysisPhase {
+	logger := klog.FromContext(ctx)
+	spec := Evan.Spec.Analysis
+
+	specHash, err := analysisSpecHash(spec)
+	if err != nil {
+		utilruntime.HandleError(err)
+		return samplev1alpha1.AnalysisPhaseRunning
+	}
+	run := findAnalysisRun(status, name, status.CurrentRevision, specHash)
+	if run == nil {
+		status.AnalysisRuns = append(status.AnalysisRuns, samplev1alpha1.AnalysisRun{
+			Name:      name,
+			Image:     image,
+			Revision:  status.CurrentRevision,
+			SpecHash:  specHash,
+			Phase:     samplev1alpha1.AnalysisPhaseRunning,
+			StartedAt: metav1.Now(),
+		})
+		if len(status.AnalysisRuns) > maxAnalysisRuns {
+			status.AnalysisRuns = status.AnalysisRuns[len(status.AnalysisRuns)-maxAnalysisRuns:]
+		}
+		run = &status.AnalysisRuns[len(status.AnalysisRuns)-1]
+	}
+	if run.Phase != samplev1alpha1.AnalysisPhaseRunning {
+		return run.Phase
+	}
+
+	interval := time.Duration(defaultAnalysisIntervalSeconds) * time.Second
+	if spec.IntervalSeconds != nil {
+		interval = time.Duration(*spec.IntervalSeconds) * time.Second
+	}
+	if n := len(run.Measurements); n > 0 {
+		if remaining := time.Until(run.Measurements[n-1].MeasuredAt.Add(interval)); remaining > 0 {
+			c.enqueueEvanAfter(Evan, remaining)
+			return run.Phase
+		}
+	}
+
+	address, reason := c.analysisAddress(spec)
+	querier := analysis.NewPrometheusClient(address, c.httpClient)
+
+	// A round fails when a metric fails. A metric that cannot be measured
+	// says nothing about the rollout, so the round is retried instead.
+	outcome := samplev1alpha1.AnalysisPhaseSuccessful
+	for _, metric := range spec.Metrics {
+		var measurement samplev1alpha1.Measurement
+		if address == "" {
+			measurement = samplev1alpha1.Measurement{
+				Metric:     metric.Name,
+				MeasuredAt: metav1.Now(),
+				Phase:      samplev1alpha1.AnalysisPhaseError,
+				Message:    reason,
+			}
+		} else {
+			measurement = measure(ctx, querier, metric)
+		}
+		switch measurement.Phase {
+		case samplev1alpha1.AnalysisPhaseFailed:
+			outcome = samplev1alpha1.AnalysisPhaseFailed
+		case samplev1alpha1.AnalysisPhaseError:
+			if outcome == samplev1alpha1.AnalysisPhaseSuccessful {
+				outcome = samplev1alpha1.AnalysisPhaseError
+			}
+		}
+		logger.V(4).Info("Took analysis measurement", "analysis", name, "metric", metric.Name, "phase", measurement.Phase, "value", measurement.Value)
+		run.Measurements = append(run.Measurements, measurement)
+	}
+	if len(run.Measurements) > maxMeasurements {
+		run.Measurements = run.Measurements[len(run.Measurements)-maxMeasurements:]
+	}
+	switch outcome {
+	case samplev1alpha1.AnalysisPhaseSuccessful:
+		run.Successful++
+	case samplev1alpha1.AnalysisPhaseFailed:
+		run.Failed++
+	default:
+		run.Inconclusive++
+	}
+
+	count, failureLimit, inconclusiveLimit := int32(1), int32(0), int32(defaultAnalysisInconclusiveLimit)
+	if spec.Count != nil {
+		count = *spec.Count
+	}
+	if spec.FailureLimit != nil {
+		failureLimit = *spec.FailureLimit
+	}
+	if spec.InconclusiveLimit != nil {
+		inconclusiveLimit = *spec.InconclusiveLimit
+	}
+	switch {
+	case run.Failed > failureLimit:
+		run.Phase = samplev1alpha1.AnalysisPhaseFailed
+		run.FinishedAt = &metav1.Time{Time: time.Now()}
+		c.recorder.Eventf(Evan, corev1.EventTypeWarning, AnalysisFailed, MessageAnalysisFailed, name, image, run.Failed)
+	case run.Inconclusive > inconclusiveLimit:
+		// A rollout that cannot be measured is not promoted blindly.
+		run.Phase = samplev1alpha1.AnalysisPhaseFailed
+		run.FinishedAt = &metav1.Time{Time: time.Now()}
+		c.recorder.Eventf(Evan, corev1.EventTypeWarning, AnalysisInconclusive, MessageAnalysisInconclusive, name, image, run.Inconclusive)
+	case run.Successful >= count:
+		run.Phase = samplev1alpha1.AnalysisPhaseSuccessful
+		run.FinishedAt = &metav1.Time{Time: time.Now()}
+		c.recorder.Eventf(Evan, corev1.EventTypeNormal, AnalysisSucceeded, MessageAnalysisSucceeded, name, image)
+	default:
+		c.enqueueEvanAfter(Evan, interval)
+	}
+	return run.Phase
+}
+
+// analysisAddress returns the Prometheus address an analysis queries, or an
+// empty address and the reason there is none. Besides its --prometheus-address
+// the controller only queries its --prometheus-allowed-addresses, so an Evan
+// cannot make it send requests to an arbitrary URL.
+func (c *Controller) analysisAddress(spec *samplev1alpha1.Analysis) (string, string) {
+	if spec.Address == "" || spec.Address == c.prometheusAddress {
+		if c.prometheusAddress == "" {
+			return "", "no Prometheus address is configured"
+		}
+		return c.prometheusAddress, ""
+	}
+	for _, allowed := range c.prometheusAllowedAddresses {
+		if spec.Address == allowed {
+			return spec.Address, ""
+		}
+	}
+	return "", fmt.Sprintf("Prometheus address %s is not one of the --prometheus-allowed-addresses", spec.Address)
+}
+
+// findAnalysisRun returns the analysis run with the given name for the given
+// revision and analysis spec, or nil if there is none yet.
+func findAnalysisRun(status *samplev1alpha1.EvanStatus, name string, revision int64, specHash string) *samplev1alpha1.AnalysisRun {
+	for i := range status.AnalysisRuns {
+		run := &status.AnalysisRuns[i]
+		if run.Name == name && run.Revision == revision && run.SpecHash == specHash {
+			return run
+		}
+	}
+	return nil
+}
+
+// analysisSpecHash returns a short hash of spec.analysis, so fixing a metric
+// starts a new run instead of reusing a failed one.
+func analysisSpecHash(spec *samplev1alpha1.Analysis) (string, error) {
+	data, err := json.Marshal(spec)
+	if err != nil {
+		return "", err
+	}
+	return revisionHash(data), nil
+}
+
+// measure evaluates a single metric. Metrics that cannot be queried or have
+// an invalid threshold are reported with the Error phase, which makes the
+// round inconclusive rather than failed.
+func measure(ctx context.Context, querier analysis.Querier, metric samplev1alpha1.AnalysisMetric) samplev1alpha1.Measurement {
+	measurement := samplev1alpha1.Measurement{
+		Metric:     metric.Name,
+		MeasuredAt: metav1.Now(),
+	}
+
+	threshold, err := strconv.ParseFloat(metric.Threshold, 64)
+	if err != nil {
+		measurement.Phase = samplev1alpha1.AnalysisPhaseError
+		measurement.Message = fmt.Sprintf("invalid threshold %q", metric.Threshold)
+		return measurement
+	}
+
+	value, err := querier.Query(ctx, metric.Query)
+	if err != nil {
+		measurement.Phase = samplev1alpha1.AnalysisPhaseError
+		measurement.Message = err.Error()
+		return measurement
+	}
+	measurement.Value = strconv.FormatFloat(value, 'g', -1, 64)
+
+	var ok bool
+	switch metric.Operator {
+	case samplev1alpha1.AnalysisOperatorLessThan:
+		ok = value < threshold
+	case samplev1alpha1.AnalysisOperatorLessThanOrEqual:
+		ok = value <= threshold
+	case samplev1alpha1.AnalysisOperatorGreaterThan:
+		ok = value > threshold
+	case samplev1alpha1.AnalysisOperatorGreaterThanOrEqual:
+		ok = value >= threshold
+	default:
+		measurement.Phase = samplev1alpha1.AnalysisPhaseError
+		measurement.Message = fmt.Sprintf("unknown operator %q", metric.Operator)
+		return measurement
+	}
+	if ok {
+		measurement.Phase = samplev1alpha1.AnalysisPhaseSuccessful
+	} else {
+		measurement.Phase = samplev1alpha1.AnalysisPhaseFailed
+		measurement.Message = fmt.Sprintf("%s is not %s %s", measurement.Value, metric.Operator, metric.Threshold)
+	}
+	return measurement
+}
@@ -0,0 +1,170 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	samplev1alpha1 "github.com/evanraisul/k8s-sample-controller/pkg/apis/samplecontroller/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/workqueue"
+)
+
+// fakeQuerier returns the same value, or error, for every query.
+type fakeQuerier struct {
+	value float64
+	err   error
+}
+
+func (q fakeQuerier) Query(ctx context.Context, query string) (float64, error) {
+	return q.value, q.err
+}
+
+func TestMeasure(t *testing.T) {
+	tests := []struct {
+		name      string
+		querier   fakeQuerier
+		operator  samplev1alpha1.AnalysisOperator
+		threshold string
+		want      samplev1alpha1.AnalysisPhase
+	}{
+		{
+			name:      "below threshold",
+			querier:   fakeQuerier{value: 0.01},
+			operator:  samplev1alpha1.AnalysisOperatorLessThan,
+			threshold: "0.05",
+			want:      samplev1alpha1.AnalysisPhaseSuccessful,
+		},
+		{
+			name:      "above threshold",
+			querier:   fakeQuerier{value: 0.2},
+			operator:  samplev1alpha1.AnalysisOperatorLessThan,
+			threshold: "0.05",
+			want:      samplev1alpha1.AnalysisPhaseFailed,
+		},
+		{
+			name:      "equal threshold",
+			querier:   fakeQuerier{value: 0.05},
+			operator:  samplev1alpha1.AnalysisOperatorGreaterThanOrEqual,
+			threshold: "0.05",
+			want:      samplev1alpha1.AnalysisPhaseSuccessful,
+		},
+		{
+			name:      "query error",
+			querier:   fakeQuerier{err: errors.New("connection refused")},
+			operator:  samplev1alpha1.AnalysisOperatorLessThan,
+			threshold: "0.05",
+			want:      samplev1alpha1.AnalysisPhaseError,
+		},
+		{
+			name:      "invalid threshold",
+			querier:   fakeQuerier{value: 1},
+			operator:  samplev1alpha1.AnalysisOperatorLessThan,
+			threshold: "five",
+			want:      samplev1alpha1.AnalysisPhaseError,
+		},
+		{
+			name:      "unknown operator",
+			querier:   fakeQuerier{value: 1},
+			operator:  "Equal",
+			threshold: "1",
+			want:      samplev1alpha1.AnalysisPhaseError,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			metric := samplev1alpha1.AnalysisMetric{
+				Name:      "error-rate",
+				Query:     "error_rate",
+				Operator:  tt.operator,
+				Threshold: tt.threshold,
+			}
+			got := measure(context.Background(), tt.querier, metric)
+			if got.Phase != tt.want {
+				t.Errorf("measure() phase = %s (%s), want %s", got.Phase, got.Message, tt.want)
+			}
+		})
+	}
+}
+
+func TestFindAnalysisRun(t *testing.T) {
+	status := &samplev1alpha1.EvanStatus{
+		AnalysisRuns: []samplev1alpha1.AnalysisRun{
+			{Name: "canary-step-0", Image: "book:v2", Revision: 2, SpecHash: "a", Phase: samplev1alpha1.AnalysisPhaseFailed},
+		},
+	}
+	if run := findAnalysisRun(status, "canary-step-0", 2, "a"); run == nil || run.Phase != samplev1alpha1.AnalysisPhaseFailed {
+		t.Errorf("findAnalysisRun() of the same revision and spec = %v, want the failed run", run)
+	}
+	if run := findAnalysisRun(status, "canary-step-0", 4, "a"); run != nil {
+		t.Errorf("findAnalysisRun() of a new revision of the same image = %v, want none", run)
+	}
+	if run := findAnalysisRun(status, "canary-step-0", 2, "b"); run != nil {
+		t.Errorf("findAnalysisRun() after the analysis changed = %v, want none", run)
+	}
+}
+
+func TestAnalysisAddress(t *testing.T) {
+	tests := []struct {
+		name              string
+		prometheusAddress string
+		allowed           []string
+		address           string
+		want              string
+	}{
+		{name: "default address", prometheusAddress: "http://prometheus:9090", want: "http://prometheus:9090"},
+		{name: "no address", want: ""},
+		{name: "default address set explicitly", prometheusAddress: "http://prometheus:9090", address: "http://prometheus:9090", want: "http://prometheus:9090"},
+		{name: "allowed address", prometheusAddress: "http://prometheus:9090", allowed: []string{"http://thanos:9090"}, address: "http://thanos:9090", want: "http://thanos:9090"},
+		{name: "address not allowed", prometheusAddress: "http://prometheus:9090", address: "http://169.254.169.254", want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Controller{prometheusAddress: tt.prometheusAddress, prometheusAllowedAddresses: tt.allowed}
+			got, reason := c.analysisAddress(&samplev1alpha1.Analysis{Address: tt.address})
+			if got != tt.want {
+				t.Errorf("analysisAddress() = %q, want %q", got, tt.want)
+			}
+			if (got == "") != (reason != "") {
+				t.Errorf("analysisAddress() reason = %q for address %q", reason, got)
+			}
+		})
+	}
+}
+
+func TestRunAnalysisInconclusiveLimit(t *testing.T) {
+	interval := int32(0)
+	Evan := &samplev1alpha1.Evan{
+		ObjectMeta: metav1.ObjectMeta{Name: "my-book", Namespace: "default"},
+		Spec: samplev1alpha1.EvanSpec{
+			Analysis: &samplev1alpha1.Analysis{
+				// The address is not allowed, so no measurement is conclusive.
+				Address:         "http://169.254.169.254",
+				IntervalSeconds: &interval,
+				Metrics: []samplev1alpha1.AnalysisMetric{
+					{Name: "error-rate", Query: "error_rate", Operator: samplev1alpha1.AnalysisOperatorLessThan, Threshold: "0.05"},
+				},
+			},
+		},
+	}
+	c := &Controller{
+		recorder:  record.NewFakeRecorder(10),
+		workqueue: workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter()),
+	}
+	defer c.workqueue.ShutDown()
+
+	status := &samplev1alpha1.EvanStatus{CurrentRevision: 1}
+	for i := 0; i < defaultAnalysisInconclusiveLimit; i++ {
+		if phase := c.runAnalysis(context.Background(), Evan, status, "canary-step-0", "book:v2"); phase != samplev1alpha1.AnalysisPhaseRunning {
+			t.Fatalf("runAnalysis() after %d inconclusive measurements = %s, want Running", i+1, phase)
+		}
+	}
+	if phase := c.runAnalysis(context.Background(), Evan, status, "canary-step-0", "book:v2"); phase != samplev1alpha1.AnalysisPhaseFailed {
+		t.Fatalf("runAnalysis() past the inconclusive limit = %s, want Failed", phase)
+	}
+	want := "Warning AnalysisInconclusive Analysis canary-step-0 of image book:v2 failed after 4 inconclusive measurements"
+	if event := <-c.recorder.(*record.FakeRecorder).Events; event != want {
+		t.Errorf("runAnalysis() event = %q, want %q", event, want)
+	}
+}
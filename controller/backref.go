@@ -0,0 +1,167 @@
+package controller
+
+import (
+	"context"
+
+	samplev1alpha1 "github.com/evanraisul/k8s-sample-controller/pkg/apis/samplecontroller/v1alpha1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/klog/v2"
+)
+
+// evanChildrenIndex indexes Evans by the kind, namespace and name of the
+// children they compute, so a child without an owner reference or
+// EvanNameLabel, such as one created before the label was set, still maps
+// back to its Evan.
+const evanChildrenIndex = "children"
+
+// childKey returns the key of a child in the evanChildrenIndex.
+func childKey(kind string, namespace string, name string) string {
+	return kind + "/" + namespace + "/" + name
+}
+
+// evanChildrenIndexFunc returns the keys of the Deployments, StatefulSets,
+// Services and NetworkPolicy the Evan creates under any strategy.
+func evanChildrenIndexFunc(obj interface{}) ([]string, error) {
+	Evan, ok := obj.(*samplev1alpha1.Evan)
+	if !ok {
+		return nil, nil
+	}
+	resourceCreationTimestamp := Evan.CreationTimestamp.Unix()
+	deploymentName := generateDeploymentName(Evan.Name, Evan.Spec.DeploymentConfig.Name, resourceCreationTimestamp)
+	serviceName := generateServiceName(Evan.Name, Evan.Spec.ServiceConfig.Name, resourceCreationTimestamp)
+	return []string{
+		childKey("Deployment", Evan.Namespace, deploymentName),
+		childKey("Deployment", Evan.Namespace, generateColorDeploymentName(deploymentName, colorBlue)),
+		childKey("Deployment", Evan.Namespace, generateColorDeploymentName(deploymentName, colorGreen)),
+		childKey("Deployment", Evan.Namespace, generateCanaryDeploymentName(deploymentName)),
+		childKey("StatefulSet", Evan.Namespace, deploymentName),
+		childKey("Service", Evan.Namespace, serviceName),
+		childKey("Service", Evan.Namespace, generatePreviewServiceName(serviceName)),
+		childKey("Service", Evan.Namespace, generateGoverningServiceName(serviceName)),
+		childKey("NetworkPolicy", Evan.Namespace, Evan.Name),
+	}, nil
+}
+
+// computesChild reports whether the object is one of the children the Evan
+// computes under any strategy.
+func computesChild(Evan *samplev1alpha1.Evan, object metav1.Object) bool {
+	keys, _ := evanChildrenIndexFunc(Evan)
+	key := childKey(childKind(object), object.GetNamespace(), object.GetName())
+	for _, computed := range keys {
+		if computed == key {
+			return true
+		}
+	}
+	return false
+}
+
+// childKind returns the kind of a child in the evanChildrenIndex, or an
+// empty string for objects that are not indexed.
+func childKind(object metav1.Object) string {
+	switch object.(type) {
+	case *appsv1.Deployment:
+		return "Deployment"
+	case *appsv1.StatefulSet:
+		return "StatefulSet"
+	case *corev1.Service:
+		return "Service"
+	case *networkingv1.NetworkPolicy:
+		return "NetworkPolicy"
+	}
+	return ""
+}
+
+// evanForObject returns the Evan a child belongs to. It follows the
+// controller reference of the child, which only WipeOut Evans set, then the
+// EvanNameLabel back-reference and finally the evanChildrenIndex. It returns
+// nil when the child belongs to no Evan, or to another controller.
+func (c *Controller) evanForObject(object metav1.Object) *samplev1alpha1.Evan {
+	if ownerRef := metav1.GetControllerOf(object); ownerRef != nil {
+		if ownerRef.Kind != "Evan" {
+			return nil
+		}
+		Evan, err := c.evansLister.Evans(object.GetNamespace()).Get(ownerRef.Name)
+		if err != nil || Evan.UID != ownerRef.UID {
+			return nil
+		}
+		return Evan
+	}
+
+	if name, ok := object.GetLabels()[EvanNameLabel]; ok {
+		if Evan, err := c.evansLister.Evans(object.GetNamespace()).Get(name); err == nil {
+			return Evan
+		}
+	}
+
+	kind := childKind(object)
+	if kind == "" {
+		return nil
+	}
+	evans, err := c.evansIndexer.ByIndex(evanChildrenIndex, childKey(kind, object.GetNamespace(), object.GetName()))
+	if err != nil {
+		utilruntime.HandleError(err)
+		return nil
+	}
+	if len(evans) == 0 {
+		return nil
+	}
+	return evans[0].(*samplev1alpha1.Evan)
+}
+
+// isEvanChild reports whether the Evan created the object: the Evan controls
+// it, or, for a Delete Evan that sets no owner references, the object has no
+// controller and refers back to the Evan through EvanNameLabel.
+func isEvanChild(Evan *samplev1alpha1.Evan, object metav1.Object) bool {
+	if metav1.IsControlledBy(object, Evan) {
+		return true
+	}
+	return metav1.GetControllerOfNoCopy(object) == nil && object.GetLabels()[EvanNameLabel] == Evan.Name
+}
+
+// deleteChildDeployment deletes the Deployment of the given name when it is a
+// child of the Evan. Objects of that name the Evan did not create are left
+// alone.
+func (c *Controller) deleteChildDeployment(ctx context.Context, Evan *samplev1alpha1.Evan, name string) error {
+	deployment, err := c.deploymentsLister.Deployments(Evan.Namespace).Get(name)
+	if errors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !isEvanChild(Evan, deployment) {
+		return nil
+	}
+	klog.FromContext(ctx).V(4).Info("Delete Deployment resource", "deployment", klog.KObj(deployment))
+	err = c.deleteDeployment(ctx, Evan, name, *metav1.NewPreconditionDeleteOptions(string(deployment.UID)))
+	if errors.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+// deleteChildService deletes the Service of the given name when it is a
+// child of the Evan.
+func (c *Controller) deleteChildService(ctx context.Context, Evan *samplev1alpha1.Evan, name string) error {
+	service, err := c.serviceLister.Services(Evan.Namespace).Get(name)
+	if errors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !isEvanChild(Evan, service) {
+		return nil
+	}
+	klog.FromContext(ctx).V(4).Info("Delete Service resource", "service", klog.KObj(service))
+	err = c.deleteService(ctx, Evan, name, *metav1.NewPreconditionDeleteOptions(string(service.UID)))
+	if errors.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,315 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	samplev1alpha1 "github.com/evanraisul/k8s-sample-controller/pkg/apis/samplecontroller/v1alpha1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+)
+
+const (
+	colorBlue  = "blue"
+	colorGreen = "green"
+
+	// colorLabel is added to the pods, selectors and Deployments of a
+	// BlueGreen Evan so the two colors never select each other's pods.
+	colorLabel = "samplecontroller.evan.com/color"
+
+	// defaultScaleDownDelaySeconds is how long the previous color is kept
+	// running when spec.blueGreen.scaleDownDelaySeconds is not set.
+	defaultScaleDownDelaySeconds = 30
+)
+
+const (
+	// SwitchedActiveColor is used as part of the Event 'reason' when the
+	// Service of a BlueGreen Evan is switched over to the new color
+	SwitchedActiveColor = "SwitchedActiveColor"
+	// ScaledDownPreviousColor is used as part of the Event 'reason' when the
+	// previous color of a BlueGreen Evan is scaled down
+	ScaledDownPreviousColor = "ScaledDownPreviousColor"
+	// BlueGreenAborted is used as part of the Event 'reason' when the
+	// rollout of a BlueGreen Evan is aborted by a failed analysis
+	BlueGreenAborted = "BlueGreenAborted"
+
+	// MessageSwitchedActiveColor is the message used for an Event fired when
+	// the Service is switched over to the new color
+	MessageSwitchedActiveColor = "Switched Service selector from %s to %s"
+	// MessageScaledDownPreviousColor is the message used for an Event fired
+	// when the previous color is scaled down
+	MessageScaledDownPreviousColor = "Scaled down previous color %s"
+	// MessageBlueGreenAborted is the message used for an Event fired when the
+	// rollout of a BlueGreen Evan is aborted
+	MessageBlueGreenAborted = "Aborted rollout of image %s, keeping the active color"
+)
+
+// otherColor returns the color that is not the given one.
+func otherColor(color string) string {
+	if color == colorBlue {
+		return colorGreen
+	}
+	return colorBlue
+}
+
+// colorLabels returns the Evan labels narrowed down to a single color.
+func colorLabels(color string) map[string]string {
+	labels := evanLabels()
+	labels[colorLabel] = color
+	return labels
+}
+
+// generateColorDeploymentName returns the name of the Deployment backing the
+// given color of a BlueGreen Evan.
+func generateColorDeploymentName(deploymentName string, color string) string {
+	return fmt.Sprintf("%s-%s", deploymentName, color)
+}
+
+// generatePreviewServiceName returns the name of the preview Service of a
+// BlueGreen Evan.
+func generatePreviewServiceName(serviceName string) string {
+	return fmt.Sprintf("%s-preview", serviceName)
+}
+
+// isDeploymentRolledOut reports whether every replica of the Deployment runs
+// the current pod template and is available.
+func isDeploymentRolledOut(deployment *appsv1.Deployment) bool {
+	if deployment.Generation > deployment.Status.ObservedGeneration {
+		return false
+	}
+	replicas := int32(1)
+	if deployment.Spec.Replicas != nil {
+		replicas = *deployment.Spec.Replicas
+	}
+	return deployment.Status.UpdatedReplicas == replicas &&
+		deployment.Status.Replicas == replicas &&
+		deployment.Status.AvailableReplicas == replicas
+}
+
+// isDeploymentUpToDate reports whether the Deployment already runs the
+// DeploymentConfig of the Evan.
+func isDeploymentUpToDate(Evan *samplev1alpha1.Evan, deployment *appsv1.Deployment) bool {
+	return !isDeploymentChanged(newDeployment(Evan, deployment.Name, deployment.Spec.Selector.MatchLabels), deployment)
+}
+
+// syncBlueGreen reconciles the two colored Deployments of a BlueGreen Evan.
+// The active color keeps serving until the preview color, which runs the
+// current DeploymentConfig, is fully available. Only then is the Service
+// switched over, and the previous color is kept for the scale down delay so a
+// rollback is instant. When spec.analysis is set the preview must also pass
+// its analysis, and a failed analysis aborts the rollout. It returns the
+// active Deployment and the selector the main Service must use.
+func (c *Controller) syncBlueGreen(ctx context.Context, Evan *samplev1alpha1.Evan, deploymentName string, status *samplev1alpha1.EvanStatus) (*appsv1.Deployment, map[string]string, error) {
+	logger := klog.FromContext(ctx)
+
+	if status.BlueGreen == nil {
+		status.BlueGreen = &samplev1alpha1.BlueGreenStatus{}
+	}
+	bg := status.BlueGreen
+	if bg.ActiveColor == "" {
+		bg.ActiveColor = colorBlue
+	}
+
+	activeName := generateColorDeploymentName(deploymentName, bg.ActiveColor)
+	active, err := c.deploymentsLister.Deployments(Evan.Namespace).Get(activeName)
+	if errors.IsNotFound(err) {
+		// First sync, there is nothing to cut over from.
+		active, err = c.createDeployment(ctx, Evan, newDeployment(Evan, activeName, colorLabels(bg.ActiveColor)))
+		if err != nil {
+			return nil, nil, err
+		}
+		logger.Info("Created active deployment", "deployment", klog.KObj(active), "color", bg.ActiveColor)
+	} else if err != nil {
+		return nil, nil, err
+	}
+	active, err = c.claimDeployment(ctx, Evan, active, colorLabels(bg.ActiveColor))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if bg.AbortedImage != "" && bg.AbortedImage != Evan.Spec.DeploymentConfig.Image {
+		bg.AbortedImage = ""
+	}
+
+	if isDeploymentUpToDate(Evan, active) || bg.AbortedImage != "" {
+		// Nothing to roll out. A preview that was abandoned, for example by
+		// reverting the image, is treated like a previous color.
+		if bg.PreviewColor != "" && bg.PreviousColor == "" {
+			bg.PreviousColor = bg.PreviewColor
+			bg.ScaleDownAt = &metav1.Time{Time: time.Now()}
+		}
+		bg.PreviewColor = ""
+		if err := c.scaleDownPreviousColor(ctx, Evan, deploymentName, bg); err != nil {
+			return nil, nil, err
+		}
+		if Evan.Spec.DeploymentConfig.Replicas != nil {
+			// The active color of an aborted rollout still follows the replicas.
+			if active, err = c.scaleDeployment(ctx, Evan, active, *Evan.Spec.DeploymentConfig.Replicas); err != nil {
+				return nil, nil, err
+			}
+		}
+		return active, colorLabels(bg.ActiveColor), nil
+	}
+
+	// A new version needs to go out. Roll it out to the idle color first.
+	if Evan.Spec.DeploymentConfig.Image == active.Spec.Template.Spec.Containers[0].Image {
+		// Only the replicas changed, which is safe to apply in place.
+		desired := newDeployment(Evan, activeName, colorLabels(bg.ActiveColor))
+		keepForeignDeploymentMetadata(desired, active)
+		active, err = c.kubeclientset.AppsV1().Deployments(Evan.Namespace).Update(ctx, desired, metav1.UpdateOptions{})
+		if err != nil {
+			return nil, nil, err
+		}
+		return active, colorLabels(bg.ActiveColor), nil
+	}
+
+	previewColor := otherColor(bg.ActiveColor)
+	bg.PreviewColor = previewColor
+	if bg.PreviousColor == previewColor {
+		// The previous color is reused for the new version, so it must not be
+		// scaled down underneath the rollout.
+		bg.PreviousColor = ""
+		bg.ScaleDownAt = nil
+	}
+
+	preview, err := c.syncColorDeployment(ctx, Evan, deploymentName, previewColor)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !isDeploymentRolledOut(preview) {
+		logger.V(4).Info("Waiting for preview color to become available", "color", previewColor, "availableReplicas", preview.Status.AvailableReplicas)
+		return active, colorLabels(bg.ActiveColor), nil
+	}
+
+	if Evan.Spec.Analysis != nil {
+		switch c.runAnalysis(ctx, Evan, status, "bluegreen-preview", Evan.Spec.DeploymentConfig.Image) {
+		case samplev1alpha1.AnalysisPhaseRunning:
+			return active, colorLabels(bg.ActiveColor), nil
+		case samplev1alpha1.AnalysisPhaseFailed:
+			// Keep serving the active color and scale the preview down.
+			c.recorder.Eventf(Evan, corev1.EventTypeWarning, BlueGreenAborted, MessageBlueGreenAborted, Evan.Spec.DeploymentConfig.Image)
+			bg.AbortedImage = Evan.Spec.DeploymentConfig.Image
+			bg.PreviousColor = previewColor
+			bg.PreviewColor = ""
+			bg.ScaleDownAt = nil
+			if err := c.scaleDownPreviousColor(ctx, Evan, deploymentName, bg); err != nil {
+				return nil, nil, err
+			}
+			return active, colorLabels(bg.ActiveColor), nil
+		}
+	}
+
+	// The preview color is fully available, cut the Service over to it.
+	delay := time.Duration(defaultScaleDownDelaySeconds) * time.Second
+	if Evan.Spec.BlueGreen != nil && Evan.Spec.BlueGreen.ScaleDownDelaySeconds != nil {
+		delay = time.Duration(*Evan.Spec.BlueGreen.ScaleDownDelaySeconds) * time.Second
+	}
+	c.recorder.Eventf(Evan, corev1.EventTypeNormal, SwitchedActiveColor, MessageSwitchedActiveColor, bg.ActiveColor, previewColor)
+	bg.PreviousColor = bg.ActiveColor
+	bg.ScaleDownAt = &metav1.Time{Time: time.Now().Add(delay)}
+	bg.ActiveColor = previewColor
+	bg.PreviewColor = ""
+	c.enqueueEvanAfter(Evan, delay)
+
+	return preview, colorLabels(bg.ActiveColor), nil
+}
+
+// syncColorDeployment creates or updates the Deployment of the given color so
+// it runs the current DeploymentConfig of the Evan.
+func (c *Controller) syncColorDeployment(ctx context.Context, Evan *samplev1alpha1.Evan, deploymentName string, color string) (*appsv1.Deployment, error) {
+	name := generateColorDeploymentName(deploymentName, color)
+	desired := newDeployment(Evan, name, colorLabels(color))
+
+	deployment, err := c.deploymentsLister.Deployments(Evan.Namespace).Get(name)
+	if errors.IsNotFound(err) {
+		return c.createDeployment(ctx, Evan, desired)
+	}
+	if err != nil {
+		return nil, err
+	}
+	deployment, err = c.claimDeployment(ctx, Evan, deployment, colorLabels(color))
+	if err != nil {
+		return nil, err
+	}
+	if isDeploymentUpToDate(Evan, deployment) {
+		return deployment, nil
+	}
+	keepForeignDeploymentMetadata(desired, deployment)
+	return c.kubeclientset.AppsV1().Deployments(Evan.Namespace).Update(ctx, desired, metav1.UpdateOptions{})
+}
+
+// scaleDownPreviousColor scales the previous color to zero once its scale
+// down delay has passed. The Deployment itself is kept and reused by the next
+// rollout.
+func (c *Controller) scaleDownPreviousColor(ctx context.Context, Evan *samplev1alpha1.Evan, deploymentName string, bg *samplev1alpha1.BlueGreenStatus) error {
+	if bg.PreviousColor == "" {
+		return nil
+	}
+	if bg.ScaleDownAt != nil {
+		if remaining := time.Until(bg.ScaleDownAt.Time); remaining > 0 {
+			c.enqueueEvanAfter(Evan, remaining)
+			return nil
+		}
+	}
+
+	name := generateColorDeploymentName(deploymentName, bg.PreviousColor)
+	deployment, err := c.deploymentsLister.Deployments(Evan.Namespace).Get(name)
+	if err == nil && (deployment.Spec.Replicas == nil || *deployment.Spec.Replicas != 0) {
+		if _, err = c.scaleDeployment(ctx, Evan, deployment, 0); err != nil {
+			return err
+		}
+		c.recorder.Eventf(Evan, corev1.EventTypeNormal, ScaledDownPreviousColor, MessageScaledDownPreviousColor, bg.PreviousColor)
+	} else if err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+
+	bg.PreviousColor = ""
+	bg.ScaleDownAt = nil
+	return nil
+}
+
+// syncPreviewService reconciles the optional preview Service of a BlueGreen
+// Evan. It selects the color being rolled out, or the active color when no
+// rollout is in progress.
+func (c *Controller) syncPreviewService(ctx context.Context, Evan *samplev1alpha1.Evan, serviceName string, status *samplev1alpha1.EvanStatus) error {
+	previewName := generatePreviewServiceName(serviceName)
+	if Evan.Spec.BlueGreen == nil || !Evan.Spec.BlueGreen.PreviewService {
+		return c.deleteChildService(ctx, Evan, previewName)
+	}
+
+	color := status.BlueGreen.ActiveColor
+	if status.BlueGreen.PreviewColor != "" {
+		color = status.BlueGreen.PreviewColor
+	}
+	_, err := c.syncService(ctx, Evan, previewName, colorLabels(color))
+	return err
+}
+
+// deletePreviousStrategy removes the children of the rollout strategy the Evan
+// used before. A BlueGreen Evan runs colored Deployments instead of the main
+// one, no other strategy runs colors or a preview Service, and only a Canary
+// Evan runs a canary Deployment. Their pods share the app label, so the
+// children of the previous strategy must go once the current one serves
+// every replica, or both versions keep serving.
+func (c *Controller) deletePreviousStrategy(ctx context.Context, Evan *samplev1alpha1.Evan, deploymentName string, serviceName string, status *samplev1alpha1.EvanStatus) error {
+	if Evan.Spec.Strategy != samplev1alpha1.CanaryStrategyType {
+		if err := c.deleteCanary(ctx, Evan, deploymentName); err != nil {
+			return err
+		}
+		status.Canary = nil
+	}
+	if Evan.Spec.Strategy == samplev1alpha1.BlueGreenStrategyType {
+		return c.deleteChildDeployment(ctx, Evan, deploymentName)
+	}
+	for _, color := range []string{colorBlue, colorGreen} {
+		if err := c.deleteChildDeployment(ctx, Evan, generateColorDeploymentName(deploymentName, color)); err != nil {
+			return err
+		}
+	}
+	status.BlueGreen = nil
+	return c.deleteChildService(ctx, Evan, generatePreviewServiceName(serviceName))
+}
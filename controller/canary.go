@@ -0,0 +1,313 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	samplev1alpha1 "github.com/evanraisul/k8s-sample-controller/pkg/apis/samplecontroller/v1alpha1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// canaryActionAnnotation is set by users on an Evan to promote or abort
+	// the Canary rollout in progress. It is removed once it is acted upon.
+	canaryActionAnnotation = "samplecontroller.evan.com/canary-action"
+
+	canaryActionPromote = "promote"
+	canaryActionAbort   = "abort"
+
+	// trackLabel is added to the pods and selector of the canary Deployment.
+	// The stable Deployment keeps its original selector, which cannot be
+	// changed, and does not adopt the canary ReplicaSets since they are
+	// controlled by the canary Deployment.
+	trackLabel  = "samplecontroller.evan.com/track"
+	trackCanary = "canary"
+)
+
+const (
+	// CanaryStepCompleted is used as part of the Event 'reason' when a step
+	// of a Canary rollout is completed
+	CanaryStepCompleted = "CanaryStepCompleted"
+	// CanaryPromoted is used as part of the Event 'reason' when a Canary
+	// rollout is promoted
+	CanaryPromoted = "CanaryPromoted"
+	// CanaryAborted is used as part of the Event 'reason' when a Canary
+	// rollout is aborted
+	CanaryAborted = "CanaryAborted"
+	// InvalidCanaryAction is used as part of the Event 'reason' when the
+	// canary action annotation holds an unknown value
+	InvalidCanaryAction = "InvalidCanaryAction"
+
+	// MessageCanaryStepCompleted is the message used for an Event fired when
+	// a step of a Canary rollout is completed
+	MessageCanaryStepCompleted = "Completed canary step %d of %d"
+	// MessageCanaryPromoted is the message used for an Event fired when a
+	// Canary rollout is promoted
+	MessageCanaryPromoted = "Promoted canary image %s"
+	// MessageCanaryAborted is the message used for an Event fired when a
+	// Canary rollout is aborted
+	MessageCanaryAborted = "Aborted canary image %s"
+	// MessageInvalidCanaryAction is the message used for an Event fired when
+	// the canary action annotation holds an unknown value
+	MessageInvalidCanaryAction = "Ignoring unknown canary action %q"
+)
+
+// generateCanaryDeploymentName returns the name of the canary Deployment that
+// runs next to the stable one.
+func generateCanaryDeploymentName(deploymentName string) string {
+	return fmt.Sprintf("%s-canary", deploymentName)
+}
+
+// canaryLabels returns the labels of the canary pods. They keep the Evan
+// labels so the shared Service routes to them.
+func canaryLabels() map[string]string {
+	labels := evanLabels()
+	labels[trackLabel] = trackCanary
+	return labels
+}
+
+// canaryReplicas returns how many of the replicas run the canary for the
+// given weight. Any weight above zero gets at least one canary replica.
+func canaryReplicas(replicas int32, weight int32) int32 {
+	return (replicas*weight + 99) / 100
+}
+
+// syncCanary reconciles the stable and canary Deployments of a Canary Evan.
+// A new image is rolled out to the canary Deployment while replicas are
+// shifted over from the stable Deployment according to spec.canary.steps.
+// When spec.analysis is set, every weight step must also pass its analysis,
+// and a failed analysis aborts the rollout. Once every step has passed, or
+// the rollout is promoted, the stable Deployment is updated and the canary is
+// removed. It returns the stable Deployment.
+func (c *Controller) syncCanary(ctx context.Context, Evan *samplev1alpha1.Evan, deploymentName string, action string, status *samplev1alpha1.EvanStatus) (*appsv1.Deployment, error) {
+	logger := klog.FromContext(ctx)
+
+	stable, err := c.deploymentsLister.Deployments(Evan.Namespace).Get(deploymentName)
+	if errors.IsNotFound(err) {
+		// The first version goes straight to the stable Deployment.
+		stable, err = c.createDeployment(ctx, Evan, newDeployment(Evan, deploymentName, evanLabels()))
+		if err != nil {
+			return nil, err
+		}
+		logger.Info("Created stable deployment", "deployment", klog.KObj(stable))
+		return stable, nil
+	} else if err != nil {
+		return nil, err
+	}
+	stable, err = c.claimDeployment(ctx, Evan, stable, evanLabels())
+	if err != nil {
+		return nil, err
+	}
+
+	replicas := int32(1)
+	if Evan.Spec.DeploymentConfig.Replicas != nil {
+		replicas = *Evan.Spec.DeploymentConfig.Replicas
+	}
+	image := Evan.Spec.DeploymentConfig.Image
+
+	if status.Canary == nil {
+		status.Canary = &samplev1alpha1.CanaryStatus{}
+	}
+	cs := status.Canary
+
+	stableImage := stable.Spec.Template.Spec.Containers[0].Image
+	if image == stableImage || (cs.Phase == samplev1alpha1.CanaryPhaseAborted && cs.CanaryImage == image) {
+		// No rollout in progress. The canary is only removed once the stable
+		// Deployment serves every replica again.
+		if image == stableImage {
+			*cs = samplev1alpha1.CanaryStatus{}
+		}
+		cs.CurrentWeight = 0
+		if stable, err = c.scaleDeployment(ctx, Evan, stable, replicas); err != nil {
+			return nil, err
+		}
+		if isDeploymentRolledOut(stable) {
+			if err := c.deleteCanary(ctx, Evan, deploymentName); err != nil {
+				return nil, err
+			}
+			cs.CanaryReplicas = 0
+		}
+		cs.StableReplicas = replicas
+		return stable, nil
+	}
+
+	if cs.CanaryImage != image {
+		// A new rollout, possibly replacing one that was still in progress.
+		*cs = samplev1alpha1.CanaryStatus{
+			Phase:         samplev1alpha1.CanaryPhaseProgressing,
+			CanaryImage:   image,
+			StepStartedAt: &metav1.Time{Time: time.Now()},
+		}
+	}
+
+	switch action {
+	case canaryActionAbort:
+		return c.abortCanary(ctx, Evan, stable, replicas, cs)
+	case canaryActionPromote:
+		return c.promoteCanary(ctx, Evan, stable, replicas, cs)
+	case "":
+	default:
+		c.recorder.Eventf(Evan, corev1.EventTypeWarning, InvalidCanaryAction, MessageInvalidCanaryAction, action)
+	}
+
+	if cs.Phase == samplev1alpha1.CanaryPhasePromoting {
+		return c.promoteCanary(ctx, Evan, stable, replicas, cs)
+	}
+
+	var steps []samplev1alpha1.CanaryStep
+	if Evan.Spec.Canary != nil {
+		steps = Evan.Spec.Canary.Steps
+	}
+	for int(cs.CurrentStepIndex) < len(steps) {
+		step := steps[cs.CurrentStepIndex]
+		switch {
+		case step.SetWeight != nil:
+			cs.Phase = samplev1alpha1.CanaryPhaseProgressing
+			cs.CurrentWeight = *step.SetWeight
+			cs.CanaryReplicas = canaryReplicas(replicas, cs.CurrentWeight)
+			cs.StableReplicas = replicas - cs.CanaryReplicas
+
+			canary, err := c.syncCanaryDeployment(ctx, Evan, deploymentName, cs.CanaryReplicas)
+			if err != nil {
+				return nil, err
+			}
+			if stable, err = c.scaleDeployment(ctx, Evan, stable, cs.StableReplicas); err != nil {
+				return nil, err
+			}
+			if !isDeploymentRolledOut(canary) {
+				logger.V(4).Info("Waiting for canary to become available", "step", cs.CurrentStepIndex, "weight", cs.CurrentWeight)
+				return stable, nil
+			}
+			if Evan.Spec.Analysis != nil {
+				switch c.runAnalysis(ctx, Evan, status, fmt.Sprintf("canary-step-%d", cs.CurrentStepIndex), image) {
+				case samplev1alpha1.AnalysisPhaseRunning:
+					return stable, nil
+				case samplev1alpha1.AnalysisPhaseFailed:
+					return c.abortCanary(ctx, Evan, stable, replicas, cs)
+				}
+			}
+		case step.Pause != nil:
+			cs.Phase = samplev1alpha1.CanaryPhasePaused
+			if step.Pause.DurationSeconds == nil {
+				// Paused until promoted or aborted.
+				return stable, nil
+			}
+			end := cs.StepStartedAt.Add(time.Duration(*step.Pause.DurationSeconds) * time.Second)
+			if remaining := time.Until(end); remaining > 0 {
+				c.enqueueEvanAfter(Evan, remaining)
+				return stable, nil
+			}
+		}
+
+		cs.CurrentStepIndex++
+		cs.StepStartedAt = &metav1.Time{Time: time.Now()}
+		c.recorder.Eventf(Evan, corev1.EventTypeNormal, CanaryStepCompleted, MessageCanaryStepCompleted, cs.CurrentStepIndex, len(steps))
+	}
+
+	return c.promoteCanary(ctx, Evan, stable, replicas, cs)
+}
+
+// abortCanary stops the Canary rollout in progress. The stable Deployment is
+// scaled back up and the canary is removed once it serves every replica.
+func (c *Controller) abortCanary(ctx context.Context, Evan *samplev1alpha1.Evan, stable *appsv1.Deployment, replicas int32, cs *samplev1alpha1.CanaryStatus) (*appsv1.Deployment, error) {
+	klog.FromContext(ctx).Info("Aborting canary rollout", "image", cs.CanaryImage)
+	c.recorder.Eventf(Evan, corev1.EventTypeWarning, CanaryAborted, MessageCanaryAborted, cs.CanaryImage)
+	cs.Phase = samplev1alpha1.CanaryPhaseAborted
+	cs.CurrentWeight = 0
+	cs.StableReplicas = replicas
+	return c.scaleDeployment(ctx, Evan, stable, replicas)
+}
+
+// promoteCanary rolls the canary image out to the stable Deployment. Only the
+// pod template and replicas of the stable Deployment are set, so its labels,
+// annotations and owner references are kept. The canary keeps serving until
+// the stable Deployment is fully rolled out, after which the next sync
+// removes it.
+func (c *Controller) promoteCanary(ctx context.Context, Evan *samplev1alpha1.Evan, stable *appsv1.Deployment, replicas int32, cs *samplev1alpha1.CanaryStatus) (*appsv1.Deployment, error) {
+	if cs.Phase != samplev1alpha1.CanaryPhasePromoting {
+		c.recorder.Eventf(Evan, corev1.EventTypeNormal, CanaryPromoted, MessageCanaryPromoted, cs.CanaryImage)
+	}
+	cs.Phase = samplev1alpha1.CanaryPhasePromoting
+	cs.CurrentWeight = 100
+	cs.StableReplicas = replicas
+
+	desired := newDeployment(Evan, stable.Name, evanLabels())
+	desired.Spec.Replicas = &replicas
+	keepForeignDeploymentMetadata(desired, stable)
+	if !isDeploymentChanged(desired, stable) {
+		return stable, nil
+	}
+	stableCopy := stable.DeepCopy()
+	stableCopy.Spec.Template = desired.Spec.Template
+	stableCopy.Spec.Replicas = desired.Spec.Replicas
+	return c.kubeclientset.AppsV1().Deployments(Evan.Namespace).Update(ctx, stableCopy, metav1.UpdateOptions{})
+}
+
+// syncCanaryDeployment creates or updates the canary Deployment so it runs the
+// current DeploymentConfig with the given number of replicas.
+func (c *Controller) syncCanaryDeployment(ctx context.Context, Evan *samplev1alpha1.Evan, deploymentName string, replicas int32) (*appsv1.Deployment, error) {
+	name := generateCanaryDeploymentName(deploymentName)
+	desired := newDeployment(Evan, name, canaryLabels())
+	desired.Spec.Replicas = &replicas
+
+	canary, err := c.deploymentsLister.Deployments(Evan.Namespace).Get(name)
+	if errors.IsNotFound(err) {
+		return c.createDeployment(ctx, Evan, desired)
+	}
+	if err != nil {
+		return nil, err
+	}
+	canary, err = c.claimDeployment(ctx, Evan, canary, canaryLabels())
+	if err != nil {
+		return nil, err
+	}
+	if !isDeploymentChanged(desired, canary) && canary.Spec.Replicas != nil && *canary.Spec.Replicas == replicas {
+		return canary, nil
+	}
+	keepForeignDeploymentMetadata(desired, canary)
+	return c.kubeclientset.AppsV1().Deployments(Evan.Namespace).Update(ctx, desired, metav1.UpdateOptions{})
+}
+
+// scaleDeployment sets the replicas of a Deployment without touching its pod
+// template, so the stable Deployment keeps running the stable image.
+func (c *Controller) scaleDeployment(ctx context.Context, Evan *samplev1alpha1.Evan, deployment *appsv1.Deployment, replicas int32) (*appsv1.Deployment, error) {
+	if deployment.Spec.Replicas != nil && *deployment.Spec.Replicas == replicas {
+		return deployment, nil
+	}
+	deploymentCopy := deployment.DeepCopy()
+	deploymentCopy.Spec.Replicas = &replicas
+	return c.kubeclientset.AppsV1().Deployments(Evan.Namespace).Update(ctx, deploymentCopy, metav1.UpdateOptions{})
+}
+
+// deleteCanary removes the canary Deployment, if there is one.
+func (c *Controller) deleteCanary(ctx context.Context, Evan *samplev1alpha1.Evan, deploymentName string) error {
+	return c.deleteChildDeployment(ctx, Evan, generateCanaryDeploymentName(deploymentName))
+}
+
+// clearCanaryAction removes the canary action annotation once it has been
+// acted upon. The patch tests the value first, so an action set by the user
+// in the meantime is kept.
+func (c *Controller) clearCanaryAction(ctx context.Context, Evan *samplev1alpha1.Evan, action string) error {
+	path := "/metadata/annotations/" + strings.ReplaceAll(strings.ReplaceAll(canaryActionAnnotation, "~", "~0"), "/", "~1")
+	patch, err := json.Marshal([]map[string]string{
+		{"op": "test", "path": path, "value": action},
+		{"op": "remove", "path": path},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = c.sampleclientset.SamplecontrollerV1alpha1().Evans(Evan.Namespace).Patch(ctx, Evan.Name, types.JSONPatchType, patch, metav1.PatchOptions{})
+	if errors.IsNotFound(err) || errors.IsInvalid(err) {
+		// The Evan is gone, or the annotation changed since the sync.
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,56 @@
+package controller
+
+import (
+	"context"
+	"testing"
+
+	samplev1alpha1 "github.com/evanraisul/k8s-sample-controller/pkg/apis/samplecontroller/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestPromoteCanary(t *testing.T) {
+	Evan := newAdoptionEvan("WipeOut", samplev1alpha1.AdoptionPolicyNever)
+	Evan.Spec.DeploymentConfig.Image = "book:v2"
+	stableEvan := Evan.DeepCopy()
+	stableEvan.Spec.DeploymentConfig.Image = "book:v1"
+
+	stable := newDeployment(stableEvan, "my-book-1700000000", evanLabels())
+	stable.Labels["istio"] = "sidecar"
+	stable.Annotations = map[string]string{"deployment.kubernetes.io/revision": "3"}
+	stable.Spec.Template.Annotations = map[string]string{"kubectl.kubernetes.io/restartedAt": "2026-10-01T00:00:00Z"}
+	stable.OwnerReferences = append(stable.OwnerReferences, metav1.OwnerReference{APIVersion: "v1", Kind: "ConfigMap", Name: "other", UID: "uid-other"})
+
+	c := &Controller{
+		kubeclientset: fake.NewSimpleClientset(stable),
+		recorder:      record.NewFakeRecorder(10),
+	}
+	cs := &samplev1alpha1.CanaryStatus{CanaryImage: "book:v2"}
+	if _, err := c.promoteCanary(context.Background(), Evan, stable, 3, cs); err != nil {
+		t.Fatalf("promoteCanary() error = %v", err)
+	}
+	if cs.Phase != samplev1alpha1.CanaryPhasePromoting || cs.CurrentWeight != 100 {
+		t.Errorf("promoteCanary() status = %+v, want promoting at weight 100", cs)
+	}
+
+	got, err := c.kubeclientset.AppsV1().Deployments("default").Get(context.Background(), stable.Name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if image := got.Spec.Template.Spec.Containers[0].Image; image != "book:v2" {
+		t.Errorf("promoteCanary() image = %s, want book:v2", image)
+	}
+	if got.Spec.Replicas == nil || *got.Spec.Replicas != 3 {
+		t.Errorf("promoteCanary() replicas = %v, want 3", got.Spec.Replicas)
+	}
+	if got.Labels["istio"] != "sidecar" || got.Annotations["deployment.kubernetes.io/revision"] != "3" {
+		t.Errorf("promoteCanary() dropped the metadata of the stable Deployment: labels %v, annotations %v", got.Labels, got.Annotations)
+	}
+	if got.Spec.Template.Annotations["kubectl.kubernetes.io/restartedAt"] == "" {
+		t.Errorf("promoteCanary() dropped the pod template annotations: %v", got.Spec.Template.Annotations)
+	}
+	if len(got.OwnerReferences) != 2 {
+		t.Errorf("promoteCanary() owner references = %v, want the Evan and the ConfigMap", got.OwnerReferences)
+	}
+}
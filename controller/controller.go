@@ -18,32 +18,47 @@ package controller
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"golang.org/x/time/rate"
-	"k8s.io/apimachinery/pkg/util/intstr"
+	"reflect"
 	"strconv"
 
-	"log"
+	"net/http"
 	"time"
 
+	jsonpatch "github.com/evanphx/json-patch"
 	samplev1alpha1 "github.com/evanraisul/k8s-sample-controller/pkg/apis/samplecontroller/v1alpha1"
 	clientset "github.com/evanraisul/k8s-sample-controller/pkg/generated/clientset/versioned"
 	samplescheme "github.com/evanraisul/k8s-sample-controller/pkg/generated/clientset/versioned/scheme"
 	informers "github.com/evanraisul/k8s-sample-controller/pkg/generated/informers/externalversions/samplecontroller/v1alpha1"
 	listers "github.com/evanraisul/k8s-sample-controller/pkg/generated/listers/samplecontroller/v1alpha1"
 	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
 	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	"k8s.io/apimachinery/pkg/util/wait"
 	appsinformers "k8s.io/client-go/informers/apps/v1"
+	batchinformers "k8s.io/client-go/informers/batch/v1"
 	corev1informers "k8s.io/client-go/informers/core/v1"
+	discoveryinformers "k8s.io/client-go/informers/discovery/v1"
+	networkinginformers "k8s.io/client-go/informers/networking/v1"
+	rbacinformers "k8s.io/client-go/informers/rbac/v1"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/kubernetes/scheme"
 	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
 	appslisters "k8s.io/client-go/listers/apps/v1"
+	batchlisters "k8s.io/client-go/listers/batch/v1"
 	corev1lister "k8s.io/client-go/listers/core/v1"
+	discoverylisters "k8s.io/client-go/listers/discovery/v1"
+	networkinglisters "k8s.io/client-go/listers/networking/v1"
+	rbaclisters "k8s.io/client-go/listers/rbac/v1"
 	"k8s.io/client-go/tools/cache"
 	"k8s.io/client-go/tools/record"
 	"k8s.io/client-go/util/workqueue"
@@ -78,13 +93,52 @@ type Controller struct {
 	deploymentsLister appslisters.DeploymentLister
 	deploymentsSynced cache.InformerSynced
 
+	// StatefulSet
+	statefulSetsLister appslisters.StatefulSetLister
+	statefulSetsSynced cache.InformerSynced
+
+	// Job, scoped to the hook Jobs of Evans
+	jobsLister batchlisters.JobLister
+	jobsSynced cache.InformerSynced
+
+	// PersistentVolumeClaim, scoped to the claims of Evans
+	volumeClaimsLister corev1lister.PersistentVolumeClaimLister
+	volumeClaimsSynced cache.InformerSynced
+
 	// Service
 	serviceLister corev1lister.ServiceLister
 	serviceSynced cache.InformerSynced
 
+	// ControllerRevision
+	controllerRevisionsLister appslisters.ControllerRevisionLister
+	controllerRevisionsSynced cache.InformerSynced
+
+	// Pod, scoped to the pods of Evans
+	podsLister corev1lister.PodLister
+	podsSynced cache.InformerSynced
+
+	// EndpointSlice
+	endpointSlicesLister discoverylisters.EndpointSliceLister
+	endpointSlicesSynced cache.InformerSynced
+
+	// NetworkPolicy
+	networkPoliciesLister networkinglisters.NetworkPolicyLister
+	networkPoliciesSynced cache.InformerSynced
+
+	// ServiceAccount, Role and RoleBinding, scoped to the ones of Evans
+	serviceAccountsLister corev1lister.ServiceAccountLister
+	serviceAccountsSynced cache.InformerSynced
+	rolesLister           rbaclisters.RoleLister
+	rolesSynced           cache.InformerSynced
+	roleBindingsLister    rbaclisters.RoleBindingLister
+	roleBindingsSynced    cache.InformerSynced
+
 	// Evan Resource
 	evansLister listers.EvanLister
 	evansSynced cache.InformerSynced
+	// evansIndexer finds the Evans that depend on an Evan, and the Evan that
+	// computes a child.
+	evansIndexer cache.Indexer
 
 	// workqueue is a rate limited work queue. This is used to queue work to be
 	// processed instead of performing it as soon as a change happens. This
@@ -96,6 +150,21 @@ type Controller struct {
 	// recorder is an event recorder for recording Event resources to the
 	// Kubernetes API.
 	recorder record.EventRecorder
+
+	// prometheusAddress is the Prometheus HTTP API used for analysis when an
+	// Evan does not set its own address, and prometheusAllowedAddresses the
+	// other addresses an Evan may set.
+	prometheusAddress          string
+	prometheusAllowedAddresses []string
+	httpClient                 *http.Client
+
+	// orphanSweep configures the sweeper of orphaned children.
+	orphanSweep OrphanSweepConfig
+	// expectations tracks the children each Evan created or deleted that the
+	// informers have not observed yet.
+	expectations *controllerExpectations
+	// accessReviews caches the access reviews of each Evan.
+	accessReviews *accessReviewCache
 }
 
 // NewController returns a new sample controller
@@ -105,9 +174,22 @@ func NewController(
 	sampleclientset clientset.Interface,
 
 	deploymentInformer appsinformers.DeploymentInformer,
+	statefulSetInformer appsinformers.StatefulSetInformer,
 	serviceInformer corev1informers.ServiceInformer,
-
-	EvanInformer informers.EvanInformer) *Controller {
+	controllerRevisionInformer appsinformers.ControllerRevisionInformer,
+	podInformer corev1informers.PodInformer,
+	volumeClaimInformer corev1informers.PersistentVolumeClaimInformer,
+	jobInformer batchinformers.JobInformer,
+	endpointSliceInformer discoveryinformers.EndpointSliceInformer,
+	networkPolicyInformer networkinginformers.NetworkPolicyInformer,
+	serviceAccountInformer corev1informers.ServiceAccountInformer,
+	roleInformer rbacinformers.RoleInformer,
+	roleBindingInformer rbacinformers.RoleBindingInformer,
+
+	EvanInformer informers.EvanInformer,
+	prometheusAddress string,
+	prometheusAllowedAddresses []string,
+	orphanSweep OrphanSweepConfig) *Controller {
 	logger := klog.FromContext(ctx)
 
 	// Create event broadcaster
@@ -134,25 +216,94 @@ func NewController(
 		deploymentsLister: deploymentInformer.Lister(),
 		deploymentsSynced: deploymentInformer.Informer().HasSynced,
 
+		// StatefulSet
+		statefulSetsLister: statefulSetInformer.Lister(),
+		statefulSetsSynced: statefulSetInformer.Informer().HasSynced,
+
 		//Service
 		serviceLister: serviceInformer.Lister(),
 		serviceSynced: serviceInformer.Informer().HasSynced,
 
+		// ControllerRevision
+		controllerRevisionsLister: controllerRevisionInformer.Lister(),
+		controllerRevisionsSynced: controllerRevisionInformer.Informer().HasSynced,
+
+		// Pod
+		podsLister: podInformer.Lister(),
+		podsSynced: podInformer.Informer().HasSynced,
+
+		// Job
+		jobsLister: jobInformer.Lister(),
+		jobsSynced: jobInformer.Informer().HasSynced,
+
+		// PersistentVolumeClaim
+		volumeClaimsLister: volumeClaimInformer.Lister(),
+		volumeClaimsSynced: volumeClaimInformer.Informer().HasSynced,
+
+		// EndpointSlice
+		endpointSlicesLister: endpointSliceInformer.Lister(),
+		endpointSlicesSynced: endpointSliceInformer.Informer().HasSynced,
+
+		// NetworkPolicy
+		networkPoliciesLister: networkPolicyInformer.Lister(),
+		networkPoliciesSynced: networkPolicyInformer.Informer().HasSynced,
+
+		serviceAccountsLister: serviceAccountInformer.Lister(),
+		serviceAccountsSynced: serviceAccountInformer.Informer().HasSynced,
+		rolesLister:           roleInformer.Lister(),
+		rolesSynced:           roleInformer.Informer().HasSynced,
+		roleBindingsLister:    roleBindingInformer.Lister(),
+		roleBindingsSynced:    roleBindingInformer.Informer().HasSynced,
+
 		// Evan Resource
-		evansLister: EvanInformer.Lister(),
-		evansSynced: EvanInformer.Informer().HasSynced,
+		evansLister:  EvanInformer.Lister(),
+		evansSynced:  EvanInformer.Informer().HasSynced,
+		evansIndexer: EvanInformer.Informer().GetIndexer(),
 
 		workqueue: workqueue.NewRateLimitingQueue(ratelimiter),
 		recorder:  recorder,
+
+		prometheusAddress:          prometheusAddress,
+		prometheusAllowedAddresses: prometheusAllowedAddresses,
+		httpClient:                 &http.Client{Timeout: 10 * time.Second},
+
+		orphanSweep:   orphanSweep,
+		expectations:  newControllerExpectations(),
+		accessReviews: newAccessReviewCache(),
 	}
 
+	// Index the Evans by their dependencies, so a change to an Evan reaches
+	// the Evans that depend on it.
+	// Index them by the children they compute too, so children without an
+	// owner reference map back to their Evan.
+	utilruntime.Must(EvanInformer.Informer().AddIndexers(cache.Indexers{
+		dependsOnIndex:    dependsOnIndexFunc,
+		evanChildrenIndex: evanChildrenIndexFunc,
+	}))
+
 	logger.Info("Setting up event handlers")
 	// Set up an event handler for when Evan resources change
 	EvanInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
-		AddFunc: controller.enqueueEvan,
+		AddFunc: func(obj interface{}) {
+			controller.enqueueEvan(obj)
+			controller.enqueueDependents(obj)
+		},
 		UpdateFunc: func(old, new interface{}) {
-			controller.enqueueEvan(new)
+			oldEvan := old.(*samplev1alpha1.Evan)
+			newEvan := new.(*samplev1alpha1.Evan)
+			if oldEvan.ResourceVersion == newEvan.ResourceVersion {
+				// Periodic resync will send update events for all known Evans.
+				return
+			}
+			// The status written by a sync must not trigger the next sync, so
+			// only changes to the spec, labels or annotations are enqueued.
+			if isEvanChanged(oldEvan, newEvan) {
+				controller.enqueueEvan(new)
+			}
+			// Dependents wait on the status of the Evan.
+			controller.enqueueDependents(new)
 		},
+		DeleteFunc: controller.enqueueDependents,
 	})
 
 	// Set up an event handler for when Deployment resources change. This
@@ -162,7 +313,7 @@ func NewController(
 	// handling Deployment resources. More info on this pattern:
 	// https://github.com/kubernetes/community/blob/8cafef897a22026d42f5e5bb3f104febe7e29830/contributors/devel/controllers.md
 	deploymentInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
-		AddFunc: controller.handleObject,
+		AddFunc: controller.observeCreation,
 		UpdateFunc: func(old, new interface{}) {
 			newDepl := new.(*appsv1.Deployment)
 			oldDepl := old.(*appsv1.Deployment)
@@ -173,12 +324,26 @@ func NewController(
 			}
 			controller.handleObject(new)
 		},
-		DeleteFunc: controller.handleObject,
+		DeleteFunc: controller.observeDeletion,
+	})
+
+	// Set up an event handler to handle StatefulSet
+	statefulSetInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: controller.observeCreation,
+		UpdateFunc: func(old, new interface{}) {
+			oldStatefulSet := old.(*appsv1.StatefulSet)
+			newStatefulSet := new.(*appsv1.StatefulSet)
+			if oldStatefulSet.ResourceVersion == newStatefulSet.ResourceVersion {
+				return
+			}
+			controller.handleObject(new)
+		},
+		DeleteFunc: controller.observeDeletion,
 	})
 
 	// Set up an event handler to handle Service
 	serviceInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
-		AddFunc: controller.handleObject,
+		AddFunc: controller.observeCreation,
 		UpdateFunc: func(old, new interface{}) {
 			oldSvc := old.(*corev1.Service)
 			newSvc := new.(*corev1.Service)
@@ -187,8 +352,100 @@ func NewController(
 			}
 			controller.handleObject(new)
 		},
-		DeleteFunc: controller.handleObject,
+		DeleteFunc: controller.observeDeletion,
+	})
+
+	// Set up an event handler to aggregate the health of the pods of an Evan
+	podInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: controller.handlePod,
+		UpdateFunc: func(old, new interface{}) {
+			oldPod := old.(*corev1.Pod)
+			newPod := new.(*corev1.Pod)
+			if oldPod.ResourceVersion == newPod.ResourceVersion {
+				return
+			}
+			controller.handlePod(new)
+		},
+		DeleteFunc: controller.handlePod,
+	})
+
+	// Set up an event handler to report the binding of the storage of an Evan
+	volumeClaimInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: controller.observeVolumeClaimCreation,
+		UpdateFunc: func(old, new interface{}) {
+			oldClaim := old.(*corev1.PersistentVolumeClaim)
+			newClaim := new.(*corev1.PersistentVolumeClaim)
+			if oldClaim.ResourceVersion == newClaim.ResourceVersion {
+				return
+			}
+			controller.handleVolumeClaim(new)
+		},
+		DeleteFunc: controller.observeVolumeClaimDeletion,
+	})
+
+	// Set up an event handler to track hook Jobs to completion
+	jobInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: controller.observeCreation,
+		UpdateFunc: func(old, new interface{}) {
+			oldJob := old.(*batchv1.Job)
+			newJob := new.(*batchv1.Job)
+			if oldJob.ResourceVersion == newJob.ResourceVersion {
+				return
+			}
+			controller.handleObject(new)
+		},
+		DeleteFunc: controller.observeDeletion,
+	})
+
+	// Set up an event handler to report the endpoints behind each Service
+	endpointSliceInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: controller.handleEndpointSlice,
+		UpdateFunc: func(old, new interface{}) {
+			oldSlice := old.(*discoveryv1.EndpointSlice)
+			newSlice := new.(*discoveryv1.EndpointSlice)
+			if oldSlice.ResourceVersion == newSlice.ResourceVersion {
+				return
+			}
+			controller.handleEndpointSlice(new)
+		},
+		DeleteFunc: controller.handleEndpointSlice,
+	})
+
+	// Set up an event handler to handle NetworkPolicy
+	networkPolicyInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: controller.observeCreation,
+		UpdateFunc: func(old, new interface{}) {
+			oldPolicy := old.(*networkingv1.NetworkPolicy)
+			newPolicy := new.(*networkingv1.NetworkPolicy)
+			if oldPolicy.ResourceVersion == newPolicy.ResourceVersion {
+				return
+			}
+			controller.handleObject(new)
+		},
+		DeleteFunc: controller.observeDeletion,
+	})
+
+	// Revisions are only written by the controller itself, so only their
+	// creation and deletion are observed.
+	controllerRevisionInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    controller.observeCreation,
+		DeleteFunc: controller.observeDeletion,
 	})
+
+	// Set up event handlers to handle the ServiceAccount, Role and
+	// RoleBinding of an Evan
+	for _, informer := range []cache.SharedIndexInformer{serviceAccountInformer.Informer(), roleInformer.Informer(), roleBindingInformer.Informer()} {
+		informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+			AddFunc: controller.observeCreation,
+			UpdateFunc: func(old, new interface{}) {
+				if old.(metav1.Object).GetResourceVersion() == new.(metav1.Object).GetResourceVersion() {
+					return
+				}
+				controller.handleObject(new)
+			},
+			DeleteFunc: controller.observeDeletion,
+		})
+	}
 	return controller
 }
 
@@ -207,7 +464,7 @@ func (c *Controller) Run(ctx context.Context, workers int) error {
 	// Wait for the caches to be synced before starting workers
 	logger.Info("Waiting for informer caches to sync")
 
-	if ok := cache.WaitForCacheSync(ctx.Done(), c.deploymentsSynced, c.serviceSynced, c.evansSynced); !ok {
+	if ok := cache.WaitForCacheSync(ctx.Done(), c.deploymentsSynced, c.statefulSetsSynced, c.serviceSynced, c.controllerRevisionsSynced, c.podsSynced, c.volumeClaimsSynced, c.jobsSynced, c.endpointSlicesSynced, c.networkPoliciesSynced, c.serviceAccountsSynced, c.rolesSynced, c.roleBindingsSynced, c.evansSynced); !ok {
 		return fmt.Errorf("failed to wait for caches to sync")
 	}
 
@@ -218,6 +475,13 @@ func (c *Controller) Run(ctx context.Context, workers int) error {
 	}
 
 	logger.Info("Started workers")
+
+	if c.orphanSweep.Interval > 0 {
+		logger.Info("Starting orphan sweeper", "interval", c.orphanSweep.Interval, "policy", c.orphanSweep.Policy, "dryRun", c.orphanSweep.DryRun)
+		go wait.UntilWithContext(ctx, func(ctx context.Context) {
+			c.sweepOrphans(ctx, c.orphanSweep)
+		}, c.orphanSweep.Interval)
+	}
 	<-ctx.Done()
 	logger.Info("Shutting down workers")
 
@@ -323,17 +587,92 @@ func isDeploymentImageChanged(evanDeploymentImage string, deploymentImage string
 	return false
 }
 
+func isPodEvanLabelChanged(evanName string, podEvanLabel string) bool {
+	return evanName != podEvanLabel
+}
+
+// isLabelsChanged reports whether an object lacks one of the desired labels,
+// for example because someone edited the labels its Evan matches it on.
+func isLabelsChanged(desired map[string]string, current map[string]string) bool {
+	for key, value := range desired {
+		if currentValue, ok := current[key]; !ok || currentValue != value {
+			return true
+		}
+	}
+	return false
+}
+
 func isServiceNameChanged(evanServiceName string, serviceName string) bool {
 	if evanServiceName != "" && evanServiceName != serviceName {
 		return true
 	}
 	return false
 }
-func isServicePortChanged(evanServicePort int32, servicePort int32) bool {
-	if evanServicePort != 0 && evanServicePort != servicePort {
+func isServiceSelectorChanged(evanSelector map[string]string, serviceSelector map[string]string) bool {
+	return !reflect.DeepEqual(evanSelector, serviceSelector)
+}
+
+// isDeploymentChanged reports whether a Deployment drifted from the desired
+// one computed from the Evan spec: its replicas, image, Evan labels, service
+// account, volumes, ports, hardening, propagated metadata or rollout options.
+func isDeploymentChanged(desired *appsv1.Deployment, deployment *appsv1.Deployment) bool {
+	if desired.Spec.Replicas != nil && deployment.Spec.Replicas != nil &&
+		isReplicasChanged(*desired.Spec.Replicas, *deployment.Spec.Replicas) {
 		return true
 	}
-	return false
+	desiredPod := desired.Spec.Template.Spec
+	pod := deployment.Spec.Template.Spec
+	if len(pod.Containers) == 0 ||
+		isDeploymentImageChanged(desiredPod.Containers[0].Image, pod.Containers[0].Image) ||
+		isContainerPortsChanged(desiredPod.Containers[0].Ports, pod.Containers[0].Ports) {
+		return true
+	}
+	if isLabelsChanged(desired.Labels, deployment.Labels) ||
+		isPodEvanLabelChanged(desired.Spec.Template.Labels[EvanNameLabel], deployment.Spec.Template.Labels[EvanNameLabel]) {
+		return true
+	}
+	return isServiceAccountChanged(desiredPod, pod) ||
+		isVolumesChanged(desiredPod, pod) ||
+		isPodHardeningChanged(desiredPod, pod) ||
+		isDeploymentPropagationChanged(desired, deployment) ||
+		isDeploymentOptionsChanged(desired, deployment)
+}
+
+// EvanNameLabel records the name of the Evan on the objects it creates that
+// are looked up by label, such as its ControllerRevisions and pods. On its
+// Deployments, StatefulSets and Services it is the back-reference to the
+// Evan. The Pod informer passed to NewController should be scoped to this
+// label.
+const EvanNameLabel = "samplecontroller.evan.com/name"
+
+// evanLabels returns the labels every pod generated for an Evan carries.
+func evanLabels() map[string]string {
+	return map[string]string{
+		"app": "my-book",
+	}
+}
+
+// ownerReferences returns the OwnerReferences for a child of the Evan. Only a
+// WipeOut Evan owns its children, so they are garbage collected with it.
+func ownerReferences(Evan *samplev1alpha1.Evan) []metav1.OwnerReference {
+	if Evan.Spec.DeletionPolicy != "WipeOut" {
+		return nil
+	}
+	return []metav1.OwnerReference{
+		*metav1.NewControllerRef(Evan, samplev1alpha1.SchemeGroupVersion.WithKind("Evan")),
+	}
+}
+
+// defaultEvan returns the Evan with the default DeletionPolicy applied. The
+// Evan of the lister is shared with the informer cache, so the default is
+// applied on a copy.
+func defaultEvan(Evan *samplev1alpha1.Evan) *samplev1alpha1.Evan {
+	if Evan.Spec.DeletionPolicy != "" {
+		return Evan
+	}
+	Evan = Evan.DeepCopy()
+	Evan.Spec.DeletionPolicy = "WipeOut"
+	return Evan
 }
 
 // syncHandler compares the actual state with the desired, and attempts to
@@ -343,6 +682,7 @@ func (c *Controller) syncHandler(ctx context.Context, key string) error {
 
 	// Convert the namespace/name string into a distinct namespace and name
 	logger := klog.LoggerWithValues(klog.FromContext(ctx), "resourceName", key)
+	ctx = klog.NewContext(ctx, logger)
 
 	namespace, name, err := cache.SplitMetaNamespaceKey(key)
 
@@ -358,78 +698,123 @@ func (c *Controller) syncHandler(ctx context.Context, key string) error {
 		//The Evan resource may no longer exist, in which case we stop processing.
 		if errors.IsNotFound(err) {
 			utilruntime.HandleError(fmt.Errorf("Evan '%s' in work queue no longer exists", key))
+			c.expectations.deleteExpectations(key)
+			c.accessReviews.forget(key)
 			return nil
 		}
 		return err
 	}
 
+	// Until the informers observed the children the last sync created or
+	// deleted, the listers are stale and a sync could create them twice. The
+	// events of those children requeue the Evan, and the error requeues it
+	// with the rate limiter of the workqueue in case they never come.
+	if !c.expectations.satisfiedExpectations(key) {
+		return fmt.Errorf("waiting for the informers to observe the children of the Evan")
+	}
+
 	// Get Resource CreationTimestamp
 	resourceCreationTimestamp := Evan.CreationTimestamp.Unix()
 	// Deployment Name
 	deploymentName := generateDeploymentName(Evan.Name, Evan.Spec.DeploymentConfig.Name, resourceCreationTimestamp)
 
+	// A requested rollback rewrites the spec. The update triggers a new sync
+	// that rolls the restored spec out.
+	if rolledBack, err := c.rollback(ctx, Evan); err != nil || rolledBack {
+		return err
+	}
+
 	// Check DeletionPolicy
-	if Evan.Spec.DeletionPolicy == "" {
-		Evan.Spec.DeletionPolicy = "WipeOut"
+	Evan = defaultEvan(Evan)
+
+	// Status is accumulated on a copy and written once at the end of the sync.
+	status := Evan.Status.DeepCopy()
+
+	// Children are only created or updated once the dependencies are Ready.
+	ready, err := c.syncDependencies(ctx, Evan, status)
+	if err != nil {
+		return err
+	}
+	if !ready {
+		return c.updateevan(ctx, Evan, status)
 	}
 
-	// If DeletionPolicy is WipeOut, add owner reference
-	updateDeployment := newDeployment(Evan, deploymentName)
-	if Evan.Spec.DeletionPolicy == "WipeOut" {
-		updateDeployment.ObjectMeta.OwnerReferences = []metav1.OwnerReference{
-			*metav1.NewControllerRef(Evan, samplev1alpha1.SchemeGroupVersion.WithKind("Evan")),
-		}
+	if err := c.syncRevisions(ctx, Evan, status); err != nil {
+		return err
 	}
 
-	// Get the deployment with the name specified in Evan.spec
-	deployment, err := c.deploymentsLister.Deployments(Evan.ObjectMeta.Namespace).Get(deploymentName)
-	// If the resource doesn't exist, we'll create it
-	if errors.IsNotFound(err) {
-		deployment, err = c.kubeclientset.AppsV1().Deployments(Evan.ObjectMeta.Namespace).Create(context.TODO(), updateDeployment, metav1.CreateOptions{})
-		if err != nil {
-			log.Println(err)
-			return err
-		}
-		log.Printf("\ndeployment %s created .....\n", deploymentName)
+	if err := c.syncServiceAccount(ctx, Evan); err != nil {
+		return err
 	}
 
-	// If the Deployment is not controlled by this Evan resource, we should log
-	// a warning to the event recorder and return error msg.
-	if Evan.Spec.DeletionPolicy == "WipeOut" && !metav1.IsControlledBy(deployment, Evan) {
-		msg := fmt.Sprintf(MessageResourceExists, deploymentName)
-		c.recorder.Event(Evan, corev1.EventTypeWarning, ErrResourceExists, msg)
-		return fmt.Errorf("%s", msg)
+	if err := c.syncStorage(ctx, Evan, status); err != nil {
+		return err
 	}
 
-	// If this number of the replicas on the Evan resource is specified, and the
-	// number does not equal the current desired replicas on the Deployment, we
-	// should update the Deployment resource.
-	if isReplicasChanged(*Evan.Spec.DeploymentConfig.Replicas, *deployment.Spec.Replicas) {
-		logger.V(4).Info("Update deployment resource", "currentReplicas", *Evan.Spec.DeploymentConfig.Replicas, "desiredReplicas", *deployment.Spec.Replicas)
-		deployment, err = c.kubeclientset.AppsV1().Deployments(Evan.ObjectMeta.Namespace).Update(context.TODO(), updateDeployment, metav1.UpdateOptions{})
-		if err != nil {
-			fmt.Println(err)
-		}
+	// A new image only reaches the workload once the pre-rollout hook
+	// succeeded. Until then only the status is updated.
+	blocked, rolledBack, err := c.syncPreRolloutHook(ctx, Evan, deploymentName, status)
+	if err != nil || rolledBack {
+		return err
 	}
+	if blocked {
+		return c.updateevan(ctx, Evan, status)
+	}
+
+	// The Service routes to every pod of the Evan unless the rollout strategy
+	// narrows the selector down to a single color.
+	selector := evanLabels()
 
-	// If Deployment Name Change ------------------------------------------------
-	if isDeploymentNameChanged(deploymentName, deployment.ObjectMeta.Name) {
-		logger.V(4).Info("Update deployment resource", "currentName", deploymentName, "desiredName", deployment.ObjectMeta.Name)
-		deployment, err = c.kubeclientset.AppsV1().Deployments(Evan.ObjectMeta.Namespace).Update(context.TODO(), updateDeployment, metav1.UpdateOptions{})
+	// A canary action is consumed by this sync and removed from the Evan
+	// together with the status update.
+	canaryAction := Evan.Annotations[canaryActionAnnotation]
+
+	// The StatefulSet and its governing Service are named after the
+	// Deployment and the Service.
+	serviceName := generateServiceName(Evan.Name, Evan.Spec.ServiceConfig.Name, resourceCreationTimestamp)
+
+	var availableReplicas int32
+	if Evan.Spec.WorkloadKind == samplev1alpha1.StatefulSetWorkloadKind {
+		statefulSet, err := c.syncStatefulSet(ctx, Evan, deploymentName, serviceName)
 		if err != nil {
-			fmt.Println(err)
+			return err
+		}
+		availableReplicas = statefulSet.Status.AvailableReplicas
+	} else {
+		if err := c.deleteStatefulSet(ctx, Evan, deploymentName, serviceName); err != nil {
+			return err
 		}
-	}
 
-	// If Deployment Image Change ------------------------------------------------
-	if isDeploymentImageChanged(Evan.Spec.DeploymentConfig.Image, deployment.Spec.Template.Spec.Containers[0].Image) {
-		logger.V(4).Info("Update deployment resource", "currentImage", Evan.Spec.DeploymentConfig.Image, "desiredImage", deployment.Spec.Template.Spec.Containers[0].Image)
-		deployment, err = c.kubeclientset.AppsV1().Deployments(Evan.ObjectMeta.Namespace).Update(context.TODO(), updateDeployment, metav1.UpdateOptions{})
+		var deployment *appsv1.Deployment
+		switch Evan.Spec.Strategy {
+		case samplev1alpha1.BlueGreenStrategyType:
+			deployment, selector, err = c.syncBlueGreen(ctx, Evan, deploymentName, status)
+		case samplev1alpha1.CanaryStrategyType:
+			deployment, err = c.syncCanary(ctx, Evan, deploymentName, canaryAction, status)
+		default:
+			deployment, err = c.syncDeployment(ctx, Evan, deploymentName)
+		}
 		if err != nil {
-			fmt.Println(err)
+			return err
+		}
+		availableReplicas = deployment.Status.AvailableReplicas
+
+		if isDeploymentRolledOut(deployment) {
+			if err := c.deletePreviousStrategy(ctx, Evan, deploymentName, serviceName, status); err != nil {
+				return err
+			}
 		}
 	}
 
+	crashLoop, err := c.syncPodHealth(Evan, status)
+	if err != nil {
+		return err
+	}
+
+	if rolledBack, err := c.syncRolloutProgress(ctx, Evan, deploymentName, crashLoop, status); err != nil || rolledBack {
+		return err
+	}
+
 	// Service Get-----------------------------------------------------------------------------
 	Evan, err = c.evansLister.Evans(namespace).Get(name)
 	if err != nil {
@@ -442,88 +827,248 @@ func (c *Controller) syncHandler(ctx context.Context, key string) error {
 		return err
 	}
 
+	Evan = defaultEvan(Evan)
+
 	// Service Name
-	serviceName := generateServiceName(Evan.Name, Evan.Spec.ServiceConfig.Name, resourceCreationTimestamp)
+	serviceName = generateServiceName(Evan.Name, Evan.Spec.ServiceConfig.Name, resourceCreationTimestamp)
 
-	// Get the service port
-	servicePort := Evan.Spec.ServiceConfig.Port
-	if servicePort == 0 {
+	// Get the service ports
+	if len(servicePorts(Evan)) == 0 {
 		utilruntime.HandleError(fmt.Errorf("Service Port is not provided by user"))
 		return nil
 	}
 
-	// If TargetPort is not defined by User, set the TargetPort as same as Port
-	serviceTargetPort := Evan.Spec.ServiceConfig.TargetPort
-	if Evan.Spec.ServiceConfig.TargetPort == 0 {
-		serviceTargetPort = servicePort
+	service, err := c.syncService(ctx, Evan, serviceName, selector)
+	if err != nil {
+		return err
 	}
+	status.Addresses = serviceAddresses(service)
 
-	// If deletion Policy is WipeOut, then set the owner Reference
-	updateService := newService(Evan, serviceName, serviceTargetPort)
-	if Evan.Spec.DeletionPolicy == "WipeOut" {
-		updateService.ObjectMeta.OwnerReferences = []metav1.OwnerReference{
-			*metav1.NewControllerRef(Evan, samplev1alpha1.SchemeGroupVersion.WithKind("Evan")),
+	serviceNames := []string{serviceName}
+	if Evan.Spec.Strategy == samplev1alpha1.BlueGreenStrategyType && Evan.Spec.WorkloadKind != samplev1alpha1.StatefulSetWorkloadKind {
+		if err = c.syncPreviewService(ctx, Evan, serviceName, status); err != nil {
+			return err
+		}
+		if Evan.Spec.BlueGreen != nil && Evan.Spec.BlueGreen.PreviewService {
+			serviceNames = append(serviceNames, generatePreviewServiceName(serviceName))
 		}
 	}
 
+	if err = c.syncServiceEndpoints(Evan, status, serviceNames...); err != nil {
+		return err
+	}
+
+	// The smoke test hits the Service, so it runs once the Service is
+	// reconciled.
+	if err = c.syncSmokeTest(ctx, Evan, serviceName, status); err != nil {
+		return err
+	}
+
+	if err = c.syncNetworkPolicy(ctx, Evan); err != nil {
+		return err
+	}
+
+	status.AvailableReplicas = availableReplicas
+	err = c.updateevan(ctx, Evan, status)
+	if err != nil {
+		return err
+	}
+
+	if canaryAction != "" && Evan.Annotations[canaryActionAnnotation] == canaryAction {
+		return c.clearCanaryAction(ctx, Evan, canaryAction)
+	}
+
+	return nil
+}
+
+// syncDeployment creates the Deployment of a RollingUpdate Evan, or updates it
+// in place when the replicas or image in the Evan spec changed.
+func (c *Controller) syncDeployment(ctx context.Context, Evan *samplev1alpha1.Evan, deploymentName string) (*appsv1.Deployment, error) {
+	logger := klog.FromContext(ctx)
+
+	updateDeployment := newDeployment(Evan, deploymentName, evanLabels())
+
+	// Get the deployment with the name specified in Evan.spec
+	deployment, err := c.deploymentsLister.Deployments(Evan.ObjectMeta.Namespace).Get(deploymentName)
+	// If the resource doesn't exist, we'll create it
+	if errors.IsNotFound(err) {
+		deployment, err = c.createDeployment(ctx, Evan, updateDeployment)
+		if err != nil {
+			return nil, err
+		}
+		logger.Info("Created deployment", "deployment", klog.KObj(deployment))
+	}
+
+	// If the Deployment is not controlled by this Evan resource, adopt it as
+	// the adoption policy allows, or log a warning to the event recorder and
+	// return error msg.
+	deployment, err = c.claimDeployment(ctx, Evan, deployment, evanLabels())
+	if err != nil {
+		return nil, err
+	}
+
+	// Keep the labels and annotations other controllers set on the deployment
+	keepForeignDeploymentMetadata(updateDeployment, deployment)
+
+	// If the replicas, image, labels or any other field computed from the Evan
+	// spec drifted, update the deployment once with the desired state.
+	if isDeploymentChanged(updateDeployment, deployment) {
+		logger.V(4).Info("Update deployment resource", "deployment", klog.KObj(deployment), "desiredImage", Evan.Spec.DeploymentConfig.Image)
+		deployment, err = c.kubeclientset.AppsV1().Deployments(Evan.ObjectMeta.Namespace).Update(ctx, updateDeployment, metav1.UpdateOptions{})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return deployment, nil
+}
+
+// syncService creates the Service with the given selector, or updates it when
+// its name, ports, selector or options drifted from the Evan spec. A Service
+// whose immutable fields changed is recreated.
+func (c *Controller) syncService(ctx context.Context, Evan *samplev1alpha1.Evan, serviceName string, selector map[string]string) (*corev1.Service, error) {
+	logger := klog.FromContext(ctx)
+
+	updateService := newService(Evan, serviceName, selector)
+
 	// Get the service with the name specified in Evan.spec
-	service, err := c.kubeclientset.CoreV1().Services(Evan.ObjectMeta.Namespace).Get(context.TODO(), serviceName, metav1.GetOptions{})
+	service, err := c.serviceLister.Services(Evan.ObjectMeta.Namespace).Get(serviceName)
 	if errors.IsNotFound(err) {
 		// Create the service
-		service, err = c.kubeclientset.CoreV1().Services(Evan.ObjectMeta.Namespace).Create(context.TODO(), updateService, metav1.CreateOptions{})
+		service, err = c.createService(ctx, Evan, updateService)
 		if err != nil {
-			log.Println(err)
-			return err
+			return nil, err
 		}
-		log.Printf("\nservice %s created .....\n", serviceName)
+		logger.Info("Created service", "service", klog.KObj(service))
 	}
 
-	if Evan.Spec.DeletionPolicy == "WipeOut" && !metav1.IsControlledBy(service, Evan) {
-		msg := fmt.Sprintf(MessageResourceExists, serviceName)
-		c.recorder.Event(Evan, corev1.EventTypeWarning, ErrResourceExists, msg)
-		return fmt.Errorf("%s", msg)
+	service, err = c.claimService(ctx, Evan, service, evanLabels())
+	if err != nil {
+		return nil, err
+	}
+
+	// If an immutable field changed, recreate the service
+	if reason := serviceRecreateReason(updateService, service); reason != "" {
+		logger.V(4).Info("Recreate Service resource", "service", klog.KObj(service), "field", reason)
+		return c.recreateService(ctx, Evan, updateService, service, reason)
+	}
+
+	// Keep the labels and annotations other controllers set on the service
+	keepForeignMetadata(&updateService.ObjectMeta, service.ObjectMeta)
+
+	// If Service is not labeled with the Evan yet, or its labels were edited,
+	// update the service
+	if isLabelsChanged(updateService.Labels, service.Labels) {
+		logger.V(4).Info("Update Service resource", "currentLabels", service.Labels, "desiredLabels", updateService.Labels)
+		service, err = c.kubeclientset.CoreV1().Services(Evan.ObjectMeta.Namespace).Update(ctx, updateService, metav1.UpdateOptions{})
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	// If Service Name Change, update the service
 	if isServiceNameChanged(serviceName, service.ObjectMeta.Name) {
 		logger.V(4).Info("Update Service resource", "currentName", serviceName, "desiredName", service.ObjectMeta.Name)
-		service, err = c.kubeclientset.CoreV1().Services(Evan.ObjectMeta.Namespace).Update(context.TODO(), updateService, metav1.UpdateOptions{})
+		service, err = c.kubeclientset.CoreV1().Services(Evan.ObjectMeta.Namespace).Update(ctx, updateService, metav1.UpdateOptions{})
 		if err != nil {
-			fmt.Println(err)
+			return nil, err
 		}
 	}
 
-	// If Service Port Change, update the service
-	if isServicePortChanged(servicePort, service.Spec.Ports[0].Port) {
-		logger.V(4).Info("Update Service resource", "currentName", servicePort, "desiredName", service.Spec.Ports[0].Port)
-		service, err = c.kubeclientset.CoreV1().Services(Evan.ObjectMeta.Namespace).Update(context.TODO(), updateService, metav1.UpdateOptions{})
+	// If any Service Port Change, update the service
+	if isServicePortsChanged(updateService.Spec.Ports, service.Spec.Ports) {
+		logger.V(4).Info("Update Service resource", "currentPorts", service.Spec.Ports, "desiredPorts", updateService.Spec.Ports)
+		service, err = c.kubeclientset.CoreV1().Services(Evan.ObjectMeta.Namespace).Update(ctx, updateService, metav1.UpdateOptions{})
 		if err != nil {
-			fmt.Println(err)
+			return nil, err
 		}
 	}
 
-	err = c.updateevan(Evan, updateDeployment)
-	if err != nil {
-		return err
+	// If Service Selector Change, update the service. This is how a BlueGreen
+	// Evan cuts traffic over from one color to the other.
+	if isServiceSelectorChanged(selector, service.Spec.Selector) {
+		logger.V(4).Info("Update Service resource", "currentSelector", service.Spec.Selector, "desiredSelector", selector)
+		service, err = c.kubeclientset.CoreV1().Services(Evan.ObjectMeta.Namespace).Update(ctx, updateService, metav1.UpdateOptions{})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// If Propagated Labels or Annotations Change, update the service
+	if isPropagationChanged(updateService.ObjectMeta, service.ObjectMeta) {
+		logger.V(4).Info("Update Service resource", "currentLabels", service.Labels, "desiredLabels", updateService.Labels)
+		service, err = c.kubeclientset.CoreV1().Services(Evan.ObjectMeta.Namespace).Update(ctx, updateService, metav1.UpdateOptions{})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// If any other Service option Change, update the service
+	if isServiceOptionsChanged(updateService, service) {
+		logger.V(4).Info("Update Service resource", "service", klog.KObj(service))
+		service, err = c.kubeclientset.CoreV1().Services(Evan.ObjectMeta.Namespace).Update(ctx, updateService, metav1.UpdateOptions{})
+		if err != nil {
+			return nil, err
+		}
 	}
 
+	return service, nil
+}
+
+// checkControlledBy returns an error, and records a warning event, when a
+// WipeOut Evan finds a child object it does not control.
+func (c *Controller) checkControlledBy(Evan *samplev1alpha1.Evan, object metav1.Object) error {
+	if Evan.Spec.DeletionPolicy == "WipeOut" && !metav1.IsControlledBy(object, Evan) {
+		msg := fmt.Sprintf(MessageResourceExists, object.GetName())
+		c.recorder.Event(Evan, corev1.EventTypeWarning, ErrResourceExists, msg)
+		return fmt.Errorf("%s", msg)
+	}
 	return nil
 }
 
-func (c *Controller) updateevan(Evan *samplev1alpha1.Evan, deployment *appsv1.Deployment) error {
-	// NEVER modify objects from the store. It's a read-only, local cache.
-	// You can use DeepCopy() to make a deep copy of original object and modify this copy
-	// Or create a copy manually for better performance
-	EvanCopy := Evan.DeepCopy()
-	EvanCopy.Status.AvailableReplicas = deployment.Status.AvailableReplicas
-	// If the CustomResourceSubresources feature gate is not enabled,
-	// we must use Update instead of UpdateStatus to update the Status block of the Evan resource.
-	// UpdateStatus will not allow changes to the Spec of the resource,
-	// which is ideal for ensuring nothing other than resource status has been updated.
-	_, err := c.sampleclientset.SamplecontrollerV1alpha1().Evans(Evan.ObjectMeta.Namespace).Update(context.TODO(), EvanCopy, metav1.UpdateOptions{})
+// isEvanChanged reports whether an update of an Evan needs a sync: its spec
+// changed, which bumps its generation, or its labels or annotations changed.
+// Status-only updates do not.
+func isEvanChanged(old *samplev1alpha1.Evan, new *samplev1alpha1.Evan) bool {
+	return old.Generation != new.Generation ||
+		!reflect.DeepEqual(old.Labels, new.Labels) ||
+		!reflect.DeepEqual(old.Annotations, new.Annotations) ||
+		!reflect.DeepEqual(old.DeletionTimestamp, new.DeletionTimestamp)
+}
+
+// updateevan writes the status of the Evan through the status subresource.
+// Only the fields that differ from the status in the store are sent, as a
+// merge patch guarded by the resourceVersion, and nothing is written when the
+// status is unchanged.
+func (c *Controller) updateevan(ctx context.Context, Evan *samplev1alpha1.Evan, status *samplev1alpha1.EvanStatus) error {
+	if equality.Semantic.DeepEqual(Evan.Status, *status) {
+		return nil
+	}
+	patch, err := statusPatch(Evan, status)
+	if err != nil {
+		return err
+	}
+	_, err = c.sampleclientset.SamplecontrollerV1alpha1().Evans(Evan.ObjectMeta.Namespace).Patch(ctx, Evan.Name, types.MergePatchType, patch, metav1.PatchOptions{}, "status")
 	return err
 }
 
+// statusPatch returns the merge patch from the status of the Evan to the
+// given status.
+func statusPatch(Evan *samplev1alpha1.Evan, status *samplev1alpha1.EvanStatus) ([]byte, error) {
+	original, err := json.Marshal(samplev1alpha1.Evan{Status: Evan.Status})
+	if err != nil {
+		return nil, err
+	}
+	modified, err := json.Marshal(samplev1alpha1.Evan{
+		ObjectMeta: metav1.ObjectMeta{ResourceVersion: Evan.ResourceVersion},
+		Status:     *status,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return jsonpatch.CreateMergePatch(original, modified)
+}
+
 // enqueueEvan takes a Evan resource and converts it into a namespace/name
 // string which is then put onto the work queue. This method should *not* be
 // passed resources of any type other than Evan.
@@ -537,6 +1082,19 @@ func (c *Controller) enqueueEvan(obj interface{}) {
 	c.workqueue.Add(key)
 }
 
+// enqueueEvanAfter puts the Evan back onto the work queue once the given
+// duration has passed, for work that is waiting on a deadline rather than on
+// a watch event.
+func (c *Controller) enqueueEvanAfter(obj interface{}, after time.Duration) {
+	var key string
+	var err error
+	if key, err = cache.MetaNamespaceKeyFunc(obj); err != nil {
+		utilruntime.HandleError(err)
+		return
+	}
+	c.workqueue.AddAfter(key, after)
+}
+
 // handleObject will take any resource implementing metav1.Object and attempt
 // to find the Evan resource that 'owns' it. It does this by looking at the
 // objects metadata.ownerReferences field for an appropriate OwnerReference.
@@ -560,88 +1118,107 @@ func (c *Controller) handleObject(obj interface{}) {
 		logger.V(4).Info("Recovered deleted object", "resourceName", object.GetName())
 	}
 	logger.V(4).Info("Processing object", "object", klog.KObj(object))
-	if ownerRef := metav1.GetControllerOf(object); ownerRef != nil {
-		// If this object is not owned by a Evan, we should not do anything more
-		// with it.
-		if ownerRef.Kind != "Evan" {
-			return
-		}
-
-		Evan, err := c.evansLister.Evans(object.GetNamespace()).Get(ownerRef.Name)
-		if err != nil {
-			logger.V(4).Info("Ignore orphaned object", "object", klog.KObj(object), "Evan", ownerRef.Name)
-			return
-		}
-		c.enqueueEvan(Evan)
+	// Children of a Delete Evan have no owner reference, so they are found
+	// through their back-reference instead.
+	Evan := c.evanForObject(object)
+	if Evan == nil {
+		logger.V(4).Info("Ignore orphaned object", "object", klog.KObj(object))
 		return
 	}
+	c.enqueueEvan(Evan)
 }
 
 // newDeployment creates a new Deployment for an Evan resource. It also sets
 // the appropriate OwnerReferences on the resource so handleObject can discover
-// the Evan resource that 'owns' it.
-func newDeployment(Evan *samplev1alpha1.Evan, deploymentName string) *appsv1.Deployment {
+// the Evan resource that 'owns' it. The labels are used for the Deployment,
+// its selector and its pod template.
+func newDeployment(Evan *samplev1alpha1.Evan, deploymentName string, labels map[string]string) *appsv1.Deployment {
 
 	deployment := &appsv1.Deployment{}
-	labels := map[string]string{
-		"app": "my-book",
+	// The Deployment gets its own copy of the labels, as propagated labels
+	// must not reach its selector.
+	if labels != nil {
+		deployment.Labels = map[string]string{}
+		for key, value := range labels {
+			deployment.Labels[key] = value
+		}
 	}
-	deployment.Labels = labels
 	deployment.TypeMeta.Kind = "Deployment"
+	// The Deployment refers back to its Evan, which matters when it has no
+	// owner reference.
+	if deployment.Labels == nil {
+		deployment.Labels = map[string]string{}
+	}
+	deployment.Labels[EvanNameLabel] = Evan.Name
 
 	deployment.ObjectMeta.Name = deploymentName
 	deployment.ObjectMeta.Namespace = Evan.ObjectMeta.Namespace
+	deployment.ObjectMeta.OwnerReferences = ownerReferences(Evan)
 
 	deployment.Spec.Replicas = Evan.Spec.DeploymentConfig.Replicas
 	deployment.Spec.Selector = &metav1.LabelSelector{
 		MatchLabels: labels,
 	}
+	// The pods also carry the name of the Evan, which is not part of the
+	// selector, so the Pod informer can be scoped to them.
+	podLabels := map[string]string{
+		EvanNameLabel: Evan.Name,
+	}
+	for key, value := range labels {
+		podLabels[key] = value
+	}
 	deployment.Spec.Template.ObjectMeta = metav1.ObjectMeta{
-		Labels: labels,
+		Labels: podLabels,
 	}
+	propagateMetadata(Evan, &deployment.ObjectMeta)
+	propagateMetadata(Evan, &deployment.Spec.Template.ObjectMeta)
 	deployment.Spec.Template.Spec = corev1.PodSpec{
+		ServiceAccountName: serviceAccountName(Evan),
 		Containers: []corev1.Container{
 			{
 				Name:  "my-book",
 				Image: Evan.Spec.DeploymentConfig.Image,
-				Ports: []corev1.ContainerPort{
-					{
-						ContainerPort: Evan.Spec.ServiceConfig.Port,
-					},
-				},
+				Ports: newContainerPorts(servicePorts(Evan)),
 			},
 		},
 	}
+	volumes, mounts := storageVolumes(Evan)
+	deployment.Spec.Template.Spec.Volumes = volumes
+	deployment.Spec.Template.Spec.Containers[0].VolumeMounts = mounts
+	if Evan.Spec.ServiceAccount != nil {
+		deployment.Spec.Template.Spec.AutomountServiceAccountToken = Evan.Spec.ServiceAccount.AutomountServiceAccountToken
+	}
+	container := &deployment.Spec.Template.Spec.Containers[0]
+	container.Resources = containerResources(Evan)
+	container.ReadinessProbe, container.LivenessProbe = newProbes(Evan)
+	hardenPodSpec(Evan, &deployment.Spec.Template.Spec)
+	applyDeploymentOptions(Evan, deployment)
 	return deployment
 }
 
-func newService(Evan *samplev1alpha1.Evan, serviceName string, serviceTargetPort int32) *corev1.Service {
+func newService(Evan *samplev1alpha1.Evan, serviceName string, selector map[string]string) *corev1.Service {
 
-	labels := map[string]string{
-		"app": "my-book",
-	}
+	labels := evanLabels()
+	labels[EvanNameLabel] = Evan.Name
 	service := &corev1.Service{}
-	service.Labels = labels
 
 	service.TypeMeta = metav1.TypeMeta{
 		Kind: "Service",
 	}
 
 	service.ObjectMeta = metav1.ObjectMeta{
-		Name:      serviceName,
-		Namespace: Evan.ObjectMeta.Namespace,
+		Name:            serviceName,
+		Namespace:       Evan.ObjectMeta.Namespace,
+		Labels:          labels,
+		OwnerReferences: ownerReferences(Evan),
 	}
 
 	service.Spec = corev1.ServiceSpec{
 		Type:     Evan.Spec.ServiceConfig.Type,
-		Selector: labels,
-	}
-	service.Spec.Ports = []corev1.ServicePort{
-		corev1.ServicePort{
-			Port:       Evan.Spec.ServiceConfig.Port,
-			TargetPort: intstr.FromInt32(serviceTargetPort),
-			NodePort:   Evan.Spec.ServiceConfig.NodePort,
-		},
+		Selector: selector,
 	}
+	service.Spec.Ports = newServicePorts(servicePorts(Evan))
+	applyServiceOptions(Evan, service)
+	propagateMetadata(Evan, &service.ObjectMeta)
 	return service
 }
@@ -0,0 +1,199 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	samplev1alpha1 "github.com/evanraisul/k8s-sample-controller/pkg/apis/samplecontroller/v1alpha1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/client-go/tools/cache"
+)
+
+// dependsOnIndex indexes Evans by the namespace/name keys of the Evans they
+// depend on.
+const dependsOnIndex = "dependsOn"
+
+// Reasons of the DependenciesReady condition of an Evan.
+const (
+	ReasonDependenciesReady   = "DependenciesReady"
+	ReasonDependencyNotFound  = "DependencyNotFound"
+	ReasonDependencyNotReady  = "DependencyNotReady"
+	ReasonDependencyForbidden = "DependencyForbidden"
+	ReasonDependencyCycle     = "DependencyCycle"
+)
+
+// dependencyKey returns the namespace/name key of a dependency of the Evan.
+func dependencyKey(Evan *samplev1alpha1.Evan, dependency samplev1alpha1.EvanReference) string {
+	namespace := dependency.Namespace
+	if namespace == "" {
+		namespace = Evan.Namespace
+	}
+	return namespace + "/" + dependency.Name
+}
+
+// dependsOnIndexFunc returns the keys of the Evans the Evan depends on.
+func dependsOnIndexFunc(obj interface{}) ([]string, error) {
+	Evan, ok := obj.(*samplev1alpha1.Evan)
+	if !ok {
+		return nil, nil
+	}
+	var keys []string
+	for _, dependency := range Evan.Spec.DependsOn {
+		keys = append(keys, dependencyKey(Evan, dependency))
+	}
+	return keys, nil
+}
+
+// enqueueDependents enqueues the Evans that depend on the given Evan, so they
+// notice when it becomes Ready.
+func (c *Controller) enqueueDependents(obj interface{}) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	key, err := cache.MetaNamespaceKeyFunc(obj)
+	if err != nil {
+		utilruntime.HandleError(err)
+		return
+	}
+	dependents, err := c.evansIndexer.ByIndex(dependsOnIndex, key)
+	if err != nil {
+		utilruntime.HandleError(err)
+		return
+	}
+	for _, dependent := range dependents {
+		c.enqueueEvan(dependent)
+	}
+}
+
+// dependencyCycle returns the cycle through the Evan, following dependsOn,
+// as a list of keys, or nil if there is none.
+func (c *Controller) dependencyCycle(Evan *samplev1alpha1.Evan) []string {
+	start, _ := cache.MetaNamespaceKeyFunc(Evan)
+	visited := map[string]bool{}
+	var path []string
+	var visit func(*samplev1alpha1.Evan) bool
+	visit = func(current *samplev1alpha1.Evan) bool {
+		for _, dependency := range current.Spec.DependsOn {
+			key := dependencyKey(current, dependency)
+			if key == start {
+				path = append(path, key)
+				return true
+			}
+			if visited[key] {
+				continue
+			}
+			visited[key] = true
+			namespace, name, _ := cache.SplitMetaNamespaceKey(key)
+			next, err := c.evansLister.Evans(namespace).Get(name)
+			if err != nil {
+				continue
+			}
+			path = append(path, key)
+			if visit(next) {
+				return true
+			}
+			path = path[:len(path)-1]
+		}
+		return false
+	}
+	if !visit(Evan) {
+		return nil
+	}
+	return append([]string{start}, path...)
+}
+
+// canReferenceDependency asks the API server whether the ServiceAccount of
+// the pods of the Evan may get the Evan it depends on in another namespace.
+// The decision is cached until the generation of the Evan changes or
+// accessReviewTTL passes.
+func (c *Controller) canReferenceDependency(ctx context.Context, Evan *samplev1alpha1.Evan, namespace, name string) (bool, error) {
+	serviceAccountName := serviceAccountName(Evan)
+	if serviceAccountName == "" {
+		serviceAccountName = "default"
+	}
+	reviewName := "dependency/" + namespace + "/" + name
+	spec := fmt.Sprintf("%s/%d", serviceAccountName, Evan.Generation)
+	if allowed, ok := c.accessReviews.lookup(evanKey(Evan), reviewName, spec); ok {
+		return allowed == "true", nil
+	}
+	review, err := c.kubeclientset.AuthorizationV1().SubjectAccessReviews().Create(ctx, &authorizationv1.SubjectAccessReview{
+		Spec: authorizationv1.SubjectAccessReviewSpec{
+			User:   fmt.Sprintf("system:serviceaccount:%s:%s", Evan.Namespace, serviceAccountName),
+			Groups: []string{"system:serviceaccounts", "system:serviceaccounts:" + Evan.Namespace},
+			ResourceAttributes: &authorizationv1.ResourceAttributes{
+				Namespace: namespace,
+				Verb:      "get",
+				Group:     samplev1alpha1.SchemeGroupVersion.Group,
+				Resource:  "evans",
+				Name:      name,
+			},
+		},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		return false, err
+	}
+	c.accessReviews.record(evanKey(Evan), reviewName, spec, strconv.FormatBool(review.Status.Allowed))
+	return review.Status.Allowed, nil
+}
+
+// syncDependencies sets the DependenciesReady condition and reports whether
+// every dependency of the Evan is Ready at its current generation. Until then
+// the children of the Evan are left alone; the Evan is synced again when a
+// dependency changes.
+func (c *Controller) syncDependencies(ctx context.Context, Evan *samplev1alpha1.Evan, status *samplev1alpha1.EvanStatus) (bool, error) {
+	if len(Evan.Spec.DependsOn) == 0 {
+		meta.RemoveStatusCondition(&status.Conditions, samplev1alpha1.EvanConditionDependenciesReady)
+		return true, nil
+	}
+
+	if cycle := c.dependencyCycle(Evan); cycle != nil {
+		setCondition(Evan, status, samplev1alpha1.EvanConditionDependenciesReady, metav1.ConditionFalse, ReasonDependencyCycle,
+			fmt.Sprintf("Dependency cycle %s", strings.Join(cycle, " -> ")))
+		return false, nil
+	}
+
+	for _, dependency := range Evan.Spec.DependsOn {
+		key := dependencyKey(Evan, dependency)
+		namespace, name, _ := cache.SplitMetaNamespaceKey(key)
+		if namespace != Evan.Namespace {
+			allowed, err := c.canReferenceDependency(ctx, Evan, namespace, name)
+			if err != nil {
+				return false, err
+			}
+			if !allowed {
+				setCondition(Evan, status, samplev1alpha1.EvanConditionDependenciesReady, metav1.ConditionFalse, ReasonDependencyForbidden,
+					fmt.Sprintf("The ServiceAccount of the Evan is not allowed to get Evan %s", key))
+				// Review again once the denial expires, as nothing else
+				// tells the controller about a new grant.
+				c.enqueueEvanAfter(Evan, accessReviewTTL)
+				return false, nil
+			}
+		}
+
+		dependencyEvan, err := c.evansLister.Evans(namespace).Get(name)
+		if errors.IsNotFound(err) {
+			setCondition(Evan, status, samplev1alpha1.EvanConditionDependenciesReady, metav1.ConditionFalse, ReasonDependencyNotFound,
+				fmt.Sprintf("Evan %s does not exist", key))
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		ready := meta.FindStatusCondition(dependencyEvan.Status.Conditions, samplev1alpha1.EvanConditionReady)
+		if ready == nil || ready.Status != metav1.ConditionTrue || ready.ObservedGeneration != dependencyEvan.Generation {
+			setCondition(Evan, status, samplev1alpha1.EvanConditionDependenciesReady, metav1.ConditionFalse, ReasonDependencyNotReady,
+				fmt.Sprintf("Evan %s is not Ready", key))
+			return false, nil
+		}
+	}
+
+	setCondition(Evan, status, samplev1alpha1.EvanConditionDependenciesReady, metav1.ConditionTrue, ReasonDependenciesReady,
+		"All dependencies are Ready")
+	return true, nil
+}
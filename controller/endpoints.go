@@ -0,0 +1,116 @@
+package controller
+
+import (
+	"fmt"
+
+	samplev1alpha1 "github.com/evanraisul/k8s-sample-controller/pkg/apis/samplecontroller/v1alpha1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/client-go/tools/cache"
+)
+
+// Reasons of the ServiceReady condition of an Evan.
+const (
+	ReasonEndpointsReady   = "EndpointsReady"
+	ReasonNoEndpoints      = "NoEndpoints"
+	ReasonNoReadyEndpoints = "NoReadyEndpoints"
+)
+
+// handleEndpointSlice maps an EndpointSlice to the Service it belongs to and
+// lets handleObject find the Evan that owns that Service.
+func (c *Controller) handleEndpointSlice(obj interface{}) {
+	slice, ok := obj.(*discoveryv1.EndpointSlice)
+	if !ok {
+		tombstone, ok := obj.(cache.DeletedFinalStateUnknown)
+		if !ok {
+			utilruntime.HandleError(fmt.Errorf("error decoding object, invalid type"))
+			return
+		}
+		slice, ok = tombstone.Obj.(*discoveryv1.EndpointSlice)
+		if !ok {
+			utilruntime.HandleError(fmt.Errorf("error decoding object tombstone, invalid type"))
+			return
+		}
+	}
+	serviceName, ok := slice.Labels[discoveryv1.LabelServiceName]
+	if !ok {
+		return
+	}
+	service, err := c.serviceLister.Services(slice.Namespace).Get(serviceName)
+	if err != nil {
+		return
+	}
+	c.handleObject(service)
+}
+
+// countEndpoints returns the ready and not ready endpoints of a Service. An
+// endpoint that appears in more than one slice, as with dual-stack Services,
+// is counted once.
+func (c *Controller) countEndpoints(namespace string, serviceName string) (int32, int32, error) {
+	selector := labels.SelectorFromSet(labels.Set{discoveryv1.LabelServiceName: serviceName})
+	slices, err := c.endpointSlicesLister.EndpointSlices(namespace).List(selector)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	ready := map[string]bool{}
+	for _, slice := range slices {
+		for _, endpoint := range slice.Endpoints {
+			key := ""
+			if endpoint.TargetRef != nil {
+				key = string(endpoint.TargetRef.UID)
+			} else if len(endpoint.Addresses) > 0 {
+				key = endpoint.Addresses[0]
+			}
+			// A nil Ready condition means ready.
+			isReady := endpoint.Conditions.Ready == nil || *endpoint.Conditions.Ready
+			ready[key] = ready[key] || isReady
+		}
+	}
+
+	var readyCount, notReadyCount int32
+	for _, isReady := range ready {
+		if isReady {
+			readyCount++
+		} else {
+			notReadyCount++
+		}
+	}
+	return readyCount, notReadyCount, nil
+}
+
+// syncServiceEndpoints records the endpoints of the Services of the Evan. The
+// first Service is the main one, which drives the ServiceReady condition.
+func (c *Controller) syncServiceEndpoints(Evan *samplev1alpha1.Evan, status *samplev1alpha1.EvanStatus, serviceNames ...string) error {
+	status.Endpoints = nil
+	for _, serviceName := range serviceNames {
+		ready, notReady, err := c.countEndpoints(Evan.Namespace, serviceName)
+		if err != nil {
+			return err
+		}
+		status.Endpoints = append(status.Endpoints, samplev1alpha1.ServiceEndpoints{
+			Service:  serviceName,
+			Ready:    ready,
+			NotReady: notReady,
+		})
+	}
+	if len(status.Endpoints) == 0 {
+		return nil
+	}
+
+	main := status.Endpoints[0]
+	switch {
+	case main.Ready > 0:
+		setCondition(Evan, status, samplev1alpha1.EvanConditionServiceReady, metav1.ConditionTrue, ReasonEndpointsReady,
+			fmt.Sprintf("Service %s routes to %d ready endpoints", main.Service, main.Ready))
+	case main.NotReady > 0:
+		setCondition(Evan, status, samplev1alpha1.EvanConditionServiceReady, metav1.ConditionFalse, ReasonNoReadyEndpoints,
+			fmt.Sprintf("None of the %d endpoints of Service %s are ready", main.NotReady, main.Service))
+	default:
+		setCondition(Evan, status, samplev1alpha1.EvanConditionServiceReady, metav1.ConditionFalse, ReasonNoEndpoints,
+			fmt.Sprintf("Service %s selects no pods", main.Service))
+	}
+	return nil
+}
@@ -0,0 +1,319 @@
+package controller
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	samplev1alpha1 "github.com/evanraisul/k8s-sample-controller/pkg/apis/samplecontroller/v1alpha1"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/client-go/tools/cache"
+)
+
+// expectationsTimeout is how long an Evan waits for the informers to observe
+// its creations and deletions before it is synced anyway, in case an event
+// was missed.
+const expectationsTimeout = 5 * time.Minute
+
+// expectation counts the creations and deletions of children an Evan is
+// still waiting to observe.
+type expectation struct {
+	add       int64
+	del       int64
+	timestamp time.Time
+}
+
+// controllerExpectations tracks, like the ControllerExpectations of
+// kube-controller-manager, the children each Evan created or deleted that the
+// informers have not observed yet. Until they have, the listers are stale
+// and the Evan is not synced, so it neither creates a child twice nor reads
+// a child it just deleted.
+type controllerExpectations struct {
+	mu    sync.Mutex
+	store map[string]*expectation
+}
+
+func newControllerExpectations() *controllerExpectations {
+	return &controllerExpectations{store: map[string]*expectation{}}
+}
+
+// satisfiedExpectations reports whether the Evan with the given key observed
+// every creation and deletion it expects, or waited for them for too long.
+func (e *controllerExpectations) satisfiedExpectations(key string) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	exp, ok := e.store[key]
+	if !ok {
+		return true
+	}
+	if exp.add <= 0 && exp.del <= 0 {
+		return true
+	}
+	return time.Since(exp.timestamp) > expectationsTimeout
+}
+
+// raiseExpectations adds to the creations and deletions the Evan expects.
+func (e *controllerExpectations) raiseExpectations(key string, add, del int64) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	exp, ok := e.store[key]
+	if !ok || time.Since(exp.timestamp) > expectationsTimeout {
+		exp = &expectation{}
+		e.store[key] = exp
+	}
+	exp.add += add
+	exp.del += del
+	exp.timestamp = time.Now()
+}
+
+// lowerExpectations records observed, or failed, creations and deletions.
+// Events of children the Evan did not expect, such as the ones listed when
+// the informers start, never count against later creations or deletions.
+func (e *controllerExpectations) lowerExpectations(key string, add, del int64) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if exp, ok := e.store[key]; ok {
+		exp.add = max(exp.add-add, 0)
+		exp.del = max(exp.del-del, 0)
+	}
+}
+
+// deleteExpectations forgets the expectations of a deleted Evan.
+func (e *controllerExpectations) deleteExpectations(key string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	delete(e.store, key)
+}
+
+// observeCreation lowers the creation expectation of the Evan a new child
+// belongs to, then handles the child.
+func (c *Controller) observeCreation(obj interface{}) {
+	c.observe(obj, 1, 0)
+	c.handleObject(obj)
+}
+
+// observeDeletion lowers the deletion expectation of the Evan a deleted child
+// belonged to, then handles the child.
+func (c *Controller) observeDeletion(obj interface{}) {
+	c.observe(obj, 0, 1)
+	c.handleObject(obj)
+}
+
+// observeVolumeClaimCreation and observeVolumeClaimDeletion only count the
+// claim of spec.storage, as the claims of volumeClaimTemplates are created by
+// the StatefulSet controller.
+func (c *Controller) observeVolumeClaimCreation(obj interface{}) {
+	if isStorageClaim(obj) {
+		c.observe(obj, 1, 0)
+	}
+	c.handleVolumeClaim(obj)
+}
+
+func (c *Controller) observeVolumeClaimDeletion(obj interface{}) {
+	if isStorageClaim(obj) {
+		c.observe(obj, 0, 1)
+	}
+	c.handleVolumeClaim(obj)
+}
+
+// observe lowers the expectations of the Evan the child, or its tombstone,
+// belongs to.
+func (c *Controller) observe(obj interface{}, add, del int64) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	object, err := meta.Accessor(obj)
+	if err != nil {
+		utilruntime.HandleError(err)
+		return
+	}
+	if Evan := c.evanForObject(object); Evan != nil {
+		c.expectations.lowerExpectations(evanKey(Evan), add, del)
+	}
+}
+
+// isStorageClaim reports whether the object, or its tombstone, is the claim
+// of spec.storage.
+func isStorageClaim(obj interface{}) bool {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	claim, ok := obj.(*corev1.PersistentVolumeClaim)
+	return ok && claim.Name == generateStorageClaimName(claim.Labels[EvanNameLabel])
+}
+
+// evanKey returns the work queue key of the Evan.
+func evanKey(Evan *samplev1alpha1.Evan) string {
+	return Evan.Namespace + "/" + Evan.Name
+}
+
+// expectCreation raises the creation expectation of the Evan around a create
+// call, and lowers it again when the call fails, as no event will follow.
+func (c *Controller) expectCreation(Evan *samplev1alpha1.Evan, create func() error) error {
+	key := evanKey(Evan)
+	c.expectations.raiseExpectations(key, 1, 0)
+	err := create()
+	if err != nil {
+		c.expectations.lowerExpectations(key, 1, 0)
+	}
+	return err
+}
+
+// expectDeletion raises the deletion expectation of the Evan around a delete
+// call, and lowers it again when the call fails, as no event will follow.
+func (c *Controller) expectDeletion(Evan *samplev1alpha1.Evan, remove func() error) error {
+	key := evanKey(Evan)
+	c.expectations.raiseExpectations(key, 0, 1)
+	err := remove()
+	if err != nil {
+		c.expectations.lowerExpectations(key, 0, 1)
+	}
+	return err
+}
+
+func (c *Controller) createDeployment(ctx context.Context, Evan *samplev1alpha1.Evan, deployment *appsv1.Deployment) (*appsv1.Deployment, error) {
+	var created *appsv1.Deployment
+	err := c.expectCreation(Evan, func() (err error) {
+		created, err = c.kubeclientset.AppsV1().Deployments(Evan.Namespace).Create(ctx, deployment, metav1.CreateOptions{})
+		return err
+	})
+	return created, err
+}
+
+func (c *Controller) deleteDeployment(ctx context.Context, Evan *samplev1alpha1.Evan, name string, options metav1.DeleteOptions) error {
+	return c.expectDeletion(Evan, func() error {
+		return c.kubeclientset.AppsV1().Deployments(Evan.Namespace).Delete(ctx, name, options)
+	})
+}
+
+func (c *Controller) createStatefulSet(ctx context.Context, Evan *samplev1alpha1.Evan, statefulSet *appsv1.StatefulSet) (*appsv1.StatefulSet, error) {
+	var created *appsv1.StatefulSet
+	err := c.expectCreation(Evan, func() (err error) {
+		created, err = c.kubeclientset.AppsV1().StatefulSets(Evan.Namespace).Create(ctx, statefulSet, metav1.CreateOptions{})
+		return err
+	})
+	return created, err
+}
+
+func (c *Controller) removeStatefulSet(ctx context.Context, Evan *samplev1alpha1.Evan, name string, options metav1.DeleteOptions) error {
+	return c.expectDeletion(Evan, func() error {
+		return c.kubeclientset.AppsV1().StatefulSets(Evan.Namespace).Delete(ctx, name, options)
+	})
+}
+
+func (c *Controller) createService(ctx context.Context, Evan *samplev1alpha1.Evan, service *corev1.Service) (*corev1.Service, error) {
+	var created *corev1.Service
+	err := c.expectCreation(Evan, func() (err error) {
+		created, err = c.kubeclientset.CoreV1().Services(Evan.Namespace).Create(ctx, service, metav1.CreateOptions{})
+		return err
+	})
+	return created, err
+}
+
+func (c *Controller) deleteService(ctx context.Context, Evan *samplev1alpha1.Evan, name string, options metav1.DeleteOptions) error {
+	return c.expectDeletion(Evan, func() error {
+		return c.kubeclientset.CoreV1().Services(Evan.Namespace).Delete(ctx, name, options)
+	})
+}
+
+func (c *Controller) createNetworkPolicy(ctx context.Context, Evan *samplev1alpha1.Evan, policy *networkingv1.NetworkPolicy) error {
+	return c.expectCreation(Evan, func() error {
+		_, err := c.kubeclientset.NetworkingV1().NetworkPolicies(Evan.Namespace).Create(ctx, policy, metav1.CreateOptions{})
+		return err
+	})
+}
+
+func (c *Controller) deleteNetworkPolicy(ctx context.Context, Evan *samplev1alpha1.Evan, name string) error {
+	return c.expectDeletion(Evan, func() error {
+		return c.kubeclientset.NetworkingV1().NetworkPolicies(Evan.Namespace).Delete(ctx, name, metav1.DeleteOptions{})
+	})
+}
+
+func (c *Controller) createJob(ctx context.Context, Evan *samplev1alpha1.Evan, job *batchv1.Job) error {
+	return c.expectCreation(Evan, func() error {
+		_, err := c.kubeclientset.BatchV1().Jobs(Evan.Namespace).Create(ctx, job, metav1.CreateOptions{})
+		return err
+	})
+}
+
+func (c *Controller) deleteJob(ctx context.Context, Evan *samplev1alpha1.Evan, name string, options metav1.DeleteOptions) error {
+	return c.expectDeletion(Evan, func() error {
+		return c.kubeclientset.BatchV1().Jobs(Evan.Namespace).Delete(ctx, name, options)
+	})
+}
+
+func (c *Controller) createVolumeClaim(ctx context.Context, Evan *samplev1alpha1.Evan, claim *corev1.PersistentVolumeClaim) (*corev1.PersistentVolumeClaim, error) {
+	var created *corev1.PersistentVolumeClaim
+	err := c.expectCreation(Evan, func() (err error) {
+		created, err = c.kubeclientset.CoreV1().PersistentVolumeClaims(Evan.Namespace).Create(ctx, claim, metav1.CreateOptions{})
+		return err
+	})
+	return created, err
+}
+
+func (c *Controller) deleteVolumeClaim(ctx context.Context, Evan *samplev1alpha1.Evan, name string) error {
+	return c.expectDeletion(Evan, func() error {
+		return c.kubeclientset.CoreV1().PersistentVolumeClaims(Evan.Namespace).Delete(ctx, name, metav1.DeleteOptions{})
+	})
+}
+
+func (c *Controller) createControllerRevision(ctx context.Context, Evan *samplev1alpha1.Evan, revision *appsv1.ControllerRevision) (*appsv1.ControllerRevision, error) {
+	var created *appsv1.ControllerRevision
+	err := c.expectCreation(Evan, func() (err error) {
+		created, err = c.kubeclientset.AppsV1().ControllerRevisions(Evan.Namespace).Create(ctx, revision, metav1.CreateOptions{})
+		return err
+	})
+	return created, err
+}
+
+func (c *Controller) deleteControllerRevision(ctx context.Context, Evan *samplev1alpha1.Evan, name string) error {
+	return c.expectDeletion(Evan, func() error {
+		return c.kubeclientset.AppsV1().ControllerRevisions(Evan.Namespace).Delete(ctx, name, metav1.DeleteOptions{})
+	})
+}
+
+func (c *Controller) createServiceAccount(ctx context.Context, Evan *samplev1alpha1.Evan, serviceAccount *corev1.ServiceAccount) error {
+	return c.expectCreation(Evan, func() error {
+		_, err := c.kubeclientset.CoreV1().ServiceAccounts(Evan.Namespace).Create(ctx, serviceAccount, metav1.CreateOptions{})
+		return err
+	})
+}
+
+func (c *Controller) deleteServiceAccount(ctx context.Context, Evan *samplev1alpha1.Evan, name string) error {
+	return c.expectDeletion(Evan, func() error {
+		return c.kubeclientset.CoreV1().ServiceAccounts(Evan.Namespace).Delete(ctx, name, metav1.DeleteOptions{})
+	})
+}
+
+func (c *Controller) createRole(ctx context.Context, Evan *samplev1alpha1.Evan, role *rbacv1.Role) error {
+	return c.expectCreation(Evan, func() error {
+		_, err := c.kubeclientset.RbacV1().Roles(Evan.Namespace).Create(ctx, role, metav1.CreateOptions{})
+		return err
+	})
+}
+
+func (c *Controller) deleteRole(ctx context.Context, Evan *samplev1alpha1.Evan, name string) error {
+	return c.expectDeletion(Evan, func() error {
+		return c.kubeclientset.RbacV1().Roles(Evan.Namespace).Delete(ctx, name, metav1.DeleteOptions{})
+	})
+}
+
+func (c *Controller) createRoleBinding(ctx context.Context, Evan *samplev1alpha1.Evan, roleBinding *rbacv1.RoleBinding) error {
+	return c.expectCreation(Evan, func() error {
+		_, err := c.kubeclientset.RbacV1().RoleBindings(Evan.Namespace).Create(ctx, roleBinding, metav1.CreateOptions{})
+		return err
+	})
+}
+
+func (c *Controller) deleteRoleBinding(ctx context.Context, Evan *samplev1alpha1.Evan, name string) error {
+	return c.expectDeletion(Evan, func() error {
+		return c.kubeclientset.RbacV1().RoleBindings(Evan.Namespace).Delete(ctx, name, metav1.DeleteOptions{})
+	})
+}
@@ -0,0 +1,124 @@
+package controller
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	samplev1alpha1 "github.com/evanraisul/k8s-sample-controller/pkg/apis/samplecontroller/v1alpha1"
+	samplefake "github.com/evanraisul/k8s-sample-controller/pkg/generated/clientset/versioned/fake"
+	listers "github.com/evanraisul/k8s-sample-controller/pkg/generated/listers/samplecontroller/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestControllerExpectations(t *testing.T) {
+	const key = "default/my-book"
+	tests := []struct {
+		name string
+		// run raises and lowers the expectations of key.
+		run  func(e *controllerExpectations)
+		want bool
+	}{
+		{
+			name: "unknown Evan",
+			run:  func(e *controllerExpectations) {},
+			want: true,
+		},
+		{
+			name: "pending creation",
+			run: func(e *controllerExpectations) {
+				e.raiseExpectations(key, 1, 0)
+			},
+			want: false,
+		},
+		{
+			name: "observed creation",
+			run: func(e *controllerExpectations) {
+				e.raiseExpectations(key, 1, 0)
+				e.lowerExpectations(key, 1, 0)
+			},
+			want: true,
+		},
+		{
+			name: "pending deletion after observed creation",
+			run: func(e *controllerExpectations) {
+				e.raiseExpectations(key, 1, 1)
+				e.lowerExpectations(key, 1, 0)
+			},
+			want: false,
+		},
+		{
+			name: "unexpected events do not count against later creations",
+			run: func(e *controllerExpectations) {
+				e.raiseExpectations(key, 0, 0)
+				e.lowerExpectations(key, 2, 2)
+				e.raiseExpectations(key, 1, 0)
+			},
+			want: false,
+		},
+		{
+			name: "events of other Evans",
+			run: func(e *controllerExpectations) {
+				e.raiseExpectations(key, 1, 0)
+				e.lowerExpectations("default/other", 1, 0)
+			},
+			want: false,
+		},
+		{
+			name: "expired expectations",
+			run: func(e *controllerExpectations) {
+				e.raiseExpectations(key, 1, 1)
+				e.store[key].timestamp = time.Now().Add(-expectationsTimeout - time.Second)
+			},
+			want: true,
+		},
+		{
+			name: "expired expectations are reset when raised",
+			run: func(e *controllerExpectations) {
+				e.raiseExpectations(key, 3, 0)
+				e.store[key].timestamp = time.Now().Add(-expectationsTimeout - time.Second)
+				e.raiseExpectations(key, 1, 0)
+				e.lowerExpectations(key, 1, 0)
+			},
+			want: true,
+		},
+		{
+			name: "deleted Evan",
+			run: func(e *controllerExpectations) {
+				e.raiseExpectations(key, 1, 0)
+				e.deleteExpectations(key)
+			},
+			want: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newControllerExpectations()
+			tt.run(e)
+			if got := e.satisfiedExpectations(key); got != tt.want {
+				t.Errorf("satisfiedExpectations() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestSyncHandlerWaitsForExpectations checks that an Evan whose children are
+// not observed yet is not synced, and is requeued through the rate limited
+// error path instead.
+func TestSyncHandlerWaitsForExpectations(t *testing.T) {
+	const key = "default/my-book"
+	Evan := &samplev1alpha1.Evan{ObjectMeta: metav1.ObjectMeta{Name: "my-book", Namespace: "default"}}
+	c := &Controller{
+		sampleclientset: samplefake.NewSimpleClientset(Evan),
+		evansLister:     listers.NewEvanLister(newTestIndexer(t, Evan)),
+		expectations:    newControllerExpectations(),
+	}
+	c.expectations.raiseExpectations(key, 1, 0)
+
+	if err := c.syncHandler(context.Background(), key); err == nil {
+		t.Fatal("syncHandler() with unsatisfied expectations succeeded, want an error that requeues the Evan")
+	}
+	if actions := c.sampleclientset.(*samplefake.Clientset).Actions(); len(actions) != 0 {
+		t.Errorf("syncHandler() with unsatisfied expectations wrote %v", actions)
+	}
+}
@@ -0,0 +1,199 @@
+package controller
+
+import (
+	samplev1alpha1 "github.com/evanraisul/k8s-sample-controller/pkg/apis/samplecontroller/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/utils/ptr"
+)
+
+// tmpVolumeName is the name of the writable volume mounted at /tmp when the
+// root filesystem of the container is read-only.
+const tmpVolumeName = "tmp"
+
+// sizePresets are the resource requests and limits of each Size.
+var sizePresets = map[samplev1alpha1.SizePreset]corev1.ResourceRequirements{
+	samplev1alpha1.SmallSize:  newResourceRequirements("100m", "128Mi", "500m", "256Mi"),
+	samplev1alpha1.MediumSize: newResourceRequirements("250m", "256Mi", "1", "512Mi"),
+	samplev1alpha1.LargeSize:  newResourceRequirements("500m", "512Mi", "2", "1Gi"),
+}
+
+func newResourceRequirements(cpuRequest, memoryRequest, cpuLimit, memoryLimit string) corev1.ResourceRequirements {
+	return corev1.ResourceRequirements{
+		Requests: corev1.ResourceList{
+			corev1.ResourceCPU:    resource.MustParse(cpuRequest),
+			corev1.ResourceMemory: resource.MustParse(memoryRequest),
+		},
+		Limits: corev1.ResourceList{
+			corev1.ResourceCPU:    resource.MustParse(cpuLimit),
+			corev1.ResourceMemory: resource.MustParse(memoryLimit),
+		},
+	}
+}
+
+// containerResources returns the requests and limits of the container. As
+// the API server does, a limit without a request also becomes the request.
+func containerResources(Evan *samplev1alpha1.Evan) corev1.ResourceRequirements {
+	config := Evan.Spec.DeploymentConfig
+	if config.Resources == nil {
+		size := config.Size
+		if size == "" {
+			size = samplev1alpha1.SmallSize
+		}
+		preset := sizePresets[size]
+		return *preset.DeepCopy()
+	}
+	resources := *config.Resources.DeepCopy()
+	for name, limit := range resources.Limits {
+		if _, ok := resources.Requests[name]; ok {
+			continue
+		}
+		if resources.Requests == nil {
+			resources.Requests = corev1.ResourceList{}
+		}
+		resources.Requests[name] = limit
+	}
+	return resources
+}
+
+// podSecurityContext returns the security context of the pod. The default
+// runs it as a non-root user with the runtime's seccomp profile.
+func podSecurityContext(Evan *samplev1alpha1.Evan) *corev1.PodSecurityContext {
+	if Evan.Spec.DeploymentConfig.PodSecurityContext != nil {
+		return Evan.Spec.DeploymentConfig.PodSecurityContext.DeepCopy()
+	}
+	return &corev1.PodSecurityContext{
+		RunAsNonRoot: ptr.To(true),
+		SeccompProfile: &corev1.SeccompProfile{
+			Type: corev1.SeccompProfileTypeRuntimeDefault,
+		},
+	}
+}
+
+// containerSecurityContext returns the security context of the containers.
+// The default forbids privilege escalation, drops every capability and makes
+// the root filesystem read-only.
+func containerSecurityContext(Evan *samplev1alpha1.Evan) *corev1.SecurityContext {
+	if Evan.Spec.DeploymentConfig.SecurityContext != nil {
+		return Evan.Spec.DeploymentConfig.SecurityContext.DeepCopy()
+	}
+	return &corev1.SecurityContext{
+		AllowPrivilegeEscalation: ptr.To(false),
+		ReadOnlyRootFilesystem:   ptr.To(true),
+		RunAsNonRoot:             ptr.To(true),
+		Capabilities: &corev1.Capabilities{
+			Drop: []corev1.Capability{"ALL"},
+		},
+		SeccompProfile: &corev1.SeccompProfile{
+			Type: corev1.SeccompProfileTypeRuntimeDefault,
+		},
+	}
+}
+
+// hardenPodSpec sets the security contexts of the Evan on the pod and its
+// containers. When the root filesystem is read-only, an emptyDir is mounted
+// at /tmp so the containers still have a scratch directory.
+func hardenPodSpec(Evan *samplev1alpha1.Evan, podSpec *corev1.PodSpec) {
+	podSpec.SecurityContext = podSecurityContext(Evan)
+	securityContext := containerSecurityContext(Evan)
+	readOnly := securityContext.ReadOnlyRootFilesystem != nil && *securityContext.ReadOnlyRootFilesystem
+	if readOnly {
+		podSpec.Volumes = append(podSpec.Volumes, corev1.Volume{
+			Name: tmpVolumeName,
+			VolumeSource: corev1.VolumeSource{
+				EmptyDir: &corev1.EmptyDirVolumeSource{},
+			},
+		})
+	}
+	for i := range podSpec.Containers {
+		podSpec.Containers[i].SecurityContext = securityContext.DeepCopy()
+		if readOnly {
+			podSpec.Containers[i].VolumeMounts = append(podSpec.Containers[i].VolumeMounts, corev1.VolumeMount{
+				Name:      tmpVolumeName,
+				MountPath: "/tmp",
+			})
+		}
+	}
+}
+
+// newProbes returns the readiness and liveness probes of the container. They
+// are derived from the first TCP port, unless the Evan sets its own, and are
+// nil when there is no such port.
+func newProbes(Evan *samplev1alpha1.Evan) (*corev1.Probe, *corev1.Probe) {
+	config := Evan.Spec.DeploymentConfig
+	var handler *corev1.ProbeHandler
+	for _, port := range newContainerPorts(servicePorts(Evan)) {
+		if port.Protocol != corev1.ProtocolTCP {
+			continue
+		}
+		target := intstr.FromInt32(port.ContainerPort)
+		if port.Name != "" {
+			target = intstr.FromString(port.Name)
+		}
+		if config.ProbePath != "" {
+			handler = &corev1.ProbeHandler{HTTPGet: &corev1.HTTPGetAction{Path: config.ProbePath, Port: target}}
+		} else {
+			handler = &corev1.ProbeHandler{TCPSocket: &corev1.TCPSocketAction{Port: target}}
+		}
+		break
+	}
+
+	readiness, liveness := config.ReadinessProbe.DeepCopy(), config.LivenessProbe.DeepCopy()
+	if readiness == nil && handler != nil {
+		readiness = &corev1.Probe{ProbeHandler: *handler.DeepCopy(), InitialDelaySeconds: 5}
+	}
+	if liveness == nil && handler != nil {
+		liveness = &corev1.Probe{ProbeHandler: *handler.DeepCopy(), InitialDelaySeconds: 15, PeriodSeconds: 20}
+	}
+	defaultProbe(readiness)
+	defaultProbe(liveness)
+	return readiness, liveness
+}
+
+// defaultProbe fills in the fields the API server defaults, so the desired
+// probe compares equal to the live one.
+func defaultProbe(probe *corev1.Probe) {
+	if probe == nil {
+		return
+	}
+	if probe.TimeoutSeconds == 0 {
+		probe.TimeoutSeconds = 1
+	}
+	if probe.PeriodSeconds == 0 {
+		probe.PeriodSeconds = 10
+	}
+	if probe.SuccessThreshold == 0 {
+		probe.SuccessThreshold = 1
+	}
+	if probe.FailureThreshold == 0 {
+		probe.FailureThreshold = 3
+	}
+	if probe.HTTPGet != nil && probe.HTTPGet.Scheme == "" {
+		probe.HTTPGet.Scheme = corev1.URISchemeHTTP
+	}
+}
+
+// isPodHardeningChanged reports whether the security contexts, resources or
+// probes of the pod drifted from the desired ones.
+func isPodHardeningChanged(evanPodSpec corev1.PodSpec, podSpec corev1.PodSpec) bool {
+	if !equality.Semantic.DeepEqual(evanPodSpec.SecurityContext, podSpec.SecurityContext) {
+		return true
+	}
+	desired, container := evanPodSpec.Containers[0], podSpec.Containers[0]
+	securityContext := container.SecurityContext
+	if securityContext != nil && desired.SecurityContext != nil && desired.SecurityContext.ProcMount == nil {
+		// The API server may default the proc mount type.
+		securityContext = securityContext.DeepCopy()
+		securityContext.ProcMount = nil
+	}
+	if !equality.Semantic.DeepEqual(desired.SecurityContext, securityContext) {
+		return true
+	}
+	if !equality.Semantic.DeepEqual(desired.Resources, container.Resources) {
+		return true
+	}
+	return !equality.Semantic.DeepEqual(desired.ReadinessProbe, container.ReadinessProbe) ||
+		!equality.Semantic.DeepEqual(desired.LivenessProbe, container.LivenessProbe)
+}
@@ -0,0 +1,325 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	samplev1alpha1 "github.com/evanraisul/k8s-sample-controller/pkg/apis/samplecontroller/v1alpha1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// hookLabel records the type of hook on its Job.
+	hookLabel = "samplecontroller.evan.com/hook"
+
+	// maxHookRuns is the number of hook runs kept in the Evan status. The
+	// Jobs of older runs are deleted.
+	maxHookRuns = 10
+
+	defaultHookAttempts = 3
+	// hookBackoff is the wait before the first retry of a failed hook. It
+	// doubles with every further retry.
+	hookBackoff = 10 * time.Second
+)
+
+const (
+	// HookSucceeded is used as part of the Event 'reason' when a hook Job
+	// completes
+	HookSucceeded = "HookSucceeded"
+	// HookFailed is used as part of the Event 'reason' when a hook Job fails
+	// its last attempt
+	HookFailed = "HookFailed"
+
+	// MessageHookSucceeded is the message used for an Event fired when a hook
+	// Job completes
+	MessageHookSucceeded = "%s hook Job %s for image %s succeeded"
+	// MessageHookFailed is the message used for an Event fired when a hook
+	// Job fails
+	MessageHookFailed = "%s hook Job %s for image %s failed after %d attempts: %s"
+)
+
+// generateJobName joins the name of an Evan and a suffix into the name of a
+// Job. The Job controller copies the name into the job-name label of its
+// pods, which is limited to 63 characters, so a longer name is cut and a hash
+// of the whole Evan name keeps the Jobs of Evans sharing a prefix apart.
+func generateJobName(evanName string, suffix string) string {
+	name := fmt.Sprintf("%s-%s", evanName, suffix)
+	if len(name) <= validation.DNS1123LabelMaxLength {
+		return name
+	}
+	hash := revisionHash([]byte(evanName))
+	keep := validation.DNS1123LabelMaxLength - len(hash) - len(suffix) - 2
+	// A dot right before the hash would end a DNS label.
+	return fmt.Sprintf("%s-%s-%s", strings.TrimRight(evanName[:keep], ".-"), hash, suffix)
+}
+
+// generateHookJobName returns the name of the Job of an attempt of a hook
+// run.
+func generateHookJobName(evanName string, hookType samplev1alpha1.HookType, specHash string, attempt int32) string {
+	return generateJobName(evanName, fmt.Sprintf("%s-%s-%d", strings.ToLower(string(hookType)), specHash, attempt))
+}
+
+// hookSpecHash returns a short hash of the hook, the image it runs for and
+// the revision being rolled out, so every rollout of a revision runs the
+// hook again.
+func hookSpecHash(hook *samplev1alpha1.HookJob, image string, revision int64) (string, error) {
+	data, err := json.Marshal(struct {
+		Hook     *samplev1alpha1.HookJob `json:"hook"`
+		Image    string                  `json:"image"`
+		Revision int64                   `json:"revision"`
+	}{hook, image, revision})
+	if err != nil {
+		return "", err
+	}
+	return revisionHash(data), nil
+}
+
+// newHookJob creates the Job of an attempt of a hook for the rollout of an
+// image. Its pods do not carry the Evan name label, so they do not count
+// towards the pod health of the Evan.
+func newHookJob(Evan *samplev1alpha1.Evan, hookType samplev1alpha1.HookType, hook *samplev1alpha1.HookJob, image string, name string) *batchv1.Job {
+	hookImage := hook.Image
+	if hookImage == "" {
+		hookImage = image
+	}
+	backoffLimit := int32(0)
+	if hook.BackoffLimit != nil {
+		backoffLimit = *hook.BackoffLimit
+	}
+	job := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: Evan.Namespace,
+			Labels: map[string]string{
+				EvanNameLabel: Evan.Name,
+				hookLabel:     string(hookType),
+			},
+			OwnerReferences: []metav1.OwnerReference{
+				*metav1.NewControllerRef(Evan, samplev1alpha1.SchemeGroupVersion.WithKind("Evan")),
+			},
+		},
+		Spec: batchv1.JobSpec{
+			BackoffLimit:          &backoffLimit,
+			ActiveDeadlineSeconds: hook.ActiveDeadlineSeconds,
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: map[string]string{hookLabel: string(hookType)},
+				},
+				Spec: corev1.PodSpec{
+					RestartPolicy:      corev1.RestartPolicyNever,
+					ServiceAccountName: serviceAccountName(Evan),
+					Containers: []corev1.Container{
+						{
+							Name:    "hook",
+							Image:   hookImage,
+							Command: hook.Command,
+							Args:    hook.Args,
+							Env:     hook.Env,
+						},
+					},
+				},
+			},
+		},
+	}
+	hardenPodSpec(Evan, &job.Spec.Template.Spec)
+	return job
+}
+
+// findHookRun returns the run of a hook for a revision and hook spec, or nil
+// if there is none yet.
+func findHookRun(status *samplev1alpha1.EvanStatus, hookType samplev1alpha1.HookType, revision int64, specHash string) *samplev1alpha1.HookRun {
+	for i := range status.HookRuns {
+		run := &status.HookRuns[i]
+		if run.Type == hookType && run.Revision == revision && run.SpecHash == specHash {
+			return run
+		}
+	}
+	return nil
+}
+
+// runHook starts the Job of a hook for the current revision, if it has not
+// run yet, and tracks it to completion through the Job informer. Failed
+// attempts are retried with an exponential backoff until hook.attempts is
+// used up. It returns the run.
+func (c *Controller) runHook(ctx context.Context, Evan *samplev1alpha1.Evan, status *samplev1alpha1.EvanStatus, hookType samplev1alpha1.HookType, hook *samplev1alpha1.HookJob, image string) (samplev1alpha1.HookRun, error) {
+	logger := klog.FromContext(ctx)
+
+	specHash, err := hookSpecHash(hook, image, status.CurrentRevision)
+	if err != nil {
+		return samplev1alpha1.HookRun{}, err
+	}
+	run := findHookRun(status, hookType, status.CurrentRevision, specHash)
+	if run != nil && run.Phase != samplev1alpha1.HookPhaseRunning {
+		return *run, nil
+	}
+
+	attempts := int32(defaultHookAttempts)
+	if hook.Attempts != nil {
+		attempts = *hook.Attempts
+	}
+
+	if run == nil {
+		status.HookRuns = append(status.HookRuns, samplev1alpha1.HookRun{
+			Type:      hookType,
+			Image:     image,
+			Revision:  status.CurrentRevision,
+			SpecHash:  specHash,
+			Phase:     samplev1alpha1.HookPhaseRunning,
+			StartedAt: metav1.Now(),
+		})
+		if len(status.HookRuns) > maxHookRuns {
+			status.HookRuns = status.HookRuns[len(status.HookRuns)-maxHookRuns:]
+		}
+		run = &status.HookRuns[len(status.HookRuns)-1]
+	}
+
+	if run.Job == "" || run.NextAttemptAt != nil {
+		// Start the first attempt, or retry once the backoff has passed.
+		if run.NextAttemptAt != nil {
+			if wait := time.Until(run.NextAttemptAt.Time); wait > 0 {
+				c.enqueueEvanAfter(Evan, wait)
+				return *run, nil
+			}
+		}
+		run.Attempts++
+		run.NextAttemptAt = nil
+		run.Job = generateHookJobName(Evan.Name, hookType, specHash, run.Attempts)
+		run.Logs = fmt.Sprintf("kubectl logs -n %s job/%s", Evan.Namespace, run.Job)
+		if err := c.pruneHookJobs(ctx, Evan, status); err != nil {
+			return samplev1alpha1.HookRun{}, err
+		}
+	}
+
+	job, err := c.jobsLister.Jobs(Evan.Namespace).Get(run.Job)
+	if errors.IsNotFound(err) {
+		logger.V(4).Info("Create hook Job", "hook", hookType, "job", run.Job, "image", image, "attempt", run.Attempts)
+		err = c.createJob(ctx, Evan, newHookJob(Evan, hookType, hook, image, run.Job))
+		if err != nil && !errors.IsAlreadyExists(err) {
+			return samplev1alpha1.HookRun{}, err
+		}
+		// The Job informer requeues the Evan as the Job progresses.
+		return *run, nil
+	}
+	if err != nil {
+		return samplev1alpha1.HookRun{}, err
+	}
+	if err := c.checkControlledBy(Evan, job); err != nil {
+		return samplev1alpha1.HookRun{}, err
+	}
+
+	for _, condition := range job.Status.Conditions {
+		if condition.Status != corev1.ConditionTrue {
+			continue
+		}
+		switch condition.Type {
+		case batchv1.JobComplete:
+			run.Phase = samplev1alpha1.HookPhaseSucceeded
+			run.Message = ""
+			run.FinishedAt = &metav1.Time{Time: time.Now()}
+			c.recorder.Eventf(Evan, corev1.EventTypeNormal, HookSucceeded, MessageHookSucceeded, hookType, job.Name, image)
+		case batchv1.JobFailed:
+			run.Message = condition.Message
+			if run.Attempts >= attempts {
+				run.Phase = samplev1alpha1.HookPhaseFailed
+				run.FinishedAt = &metav1.Time{Time: time.Now()}
+				c.recorder.Eventf(Evan, corev1.EventTypeWarning, HookFailed, MessageHookFailed, hookType, job.Name, image, run.Attempts, condition.Message)
+				return *run, nil
+			}
+			backoff := hookBackoff << (run.Attempts - 1)
+			run.NextAttemptAt = &metav1.Time{Time: time.Now().Add(backoff)}
+			logger.V(4).Info("Retry failed hook Job", "hook", hookType, "job", klog.KObj(job), "attempt", run.Attempts, "backoff", backoff)
+			c.enqueueEvanAfter(Evan, backoff)
+		}
+	}
+	return *run, nil
+}
+
+// pruneHookJobs deletes the hook Jobs of the Evan whose runs are no longer
+// kept in its status.
+func (c *Controller) pruneHookJobs(ctx context.Context, Evan *samplev1alpha1.Evan, status *samplev1alpha1.EvanStatus) error {
+	kept := map[string]bool{}
+	for _, run := range status.HookRuns {
+		kept[run.Job] = true
+	}
+	selector := labels.SelectorFromSet(labels.Set{EvanNameLabel: Evan.Name})
+	jobs, err := c.jobsLister.Jobs(Evan.Namespace).List(selector)
+	if err != nil {
+		return err
+	}
+	background := metav1.DeletePropagationBackground
+	for _, job := range jobs {
+		if _, ok := job.Labels[hookLabel]; !ok || kept[job.Name] || !metav1.IsControlledBy(job, Evan) {
+			continue
+		}
+		err := c.deleteJob(ctx, Evan, job.Name, metav1.DeleteOptions{PropagationPolicy: &background})
+		if err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// rolloutImage returns the image run by the workload that carries the
+// rollout, or an empty string before the workload exists.
+func (c *Controller) rolloutImage(Evan *samplev1alpha1.Evan, deploymentName string, status *samplev1alpha1.EvanStatus) (string, error) {
+	if Evan.Spec.WorkloadKind == samplev1alpha1.StatefulSetWorkloadKind {
+		statefulSet, err := c.statefulSetsLister.StatefulSets(Evan.Namespace).Get(deploymentName)
+		if errors.IsNotFound(err) {
+			return "", nil
+		}
+		if err != nil {
+			return "", err
+		}
+		return statefulSet.Spec.Template.Spec.Containers[0].Image, nil
+	}
+	deployment, err := c.deploymentsLister.Deployments(Evan.Namespace).Get(rolloutDeploymentName(Evan, deploymentName, status))
+	if errors.IsNotFound(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return deployment.Spec.Template.Spec.Containers[0].Image, nil
+}
+
+// syncPreRolloutHook runs the pre-rollout hook before a new image reaches the
+// workload. It reports whether the rollout has to wait, for the hook to
+// complete or because it failed, and whether a failed hook rolled the Evan
+// back.
+func (c *Controller) syncPreRolloutHook(ctx context.Context, Evan *samplev1alpha1.Evan, deploymentName string, status *samplev1alpha1.EvanStatus) (bool, bool, error) {
+	if Evan.Spec.Hooks == nil || Evan.Spec.Hooks.PreRollout == nil {
+		return false, false, nil
+	}
+	image := Evan.Spec.DeploymentConfig.Image
+	current, err := c.rolloutImage(Evan, deploymentName, status)
+	if err != nil {
+		return false, false, err
+	}
+	if current == image {
+		return false, false, nil
+	}
+
+	run, err := c.runHook(ctx, Evan, status, samplev1alpha1.PreRolloutHook, Evan.Spec.Hooks.PreRollout, image)
+	if err != nil {
+		return false, false, err
+	}
+	switch run.Phase {
+	case samplev1alpha1.HookPhaseRunning:
+		setCondition(Evan, status, samplev1alpha1.EvanConditionProgressing, metav1.ConditionTrue, ReasonPreRolloutHookRunning,
+			fmt.Sprintf("Waiting for pre-rollout hook Job %s of revision %d", run.Job, status.CurrentRevision))
+		return true, false, nil
+	case samplev1alpha1.HookPhaseFailed:
+		rolledBack, err := c.failRollout(ctx, Evan, status, ReasonHookFailed, fmt.Sprintf("pre-rollout hook Job %s failed: %s", run.Job, run.Message))
+		return true, rolledBack, err
+	}
+	return false, false, nil
+}
@@ -0,0 +1,48 @@
+package controller
+
+import (
+	"strings"
+	"testing"
+
+	samplev1alpha1 "github.com/evanraisul/k8s-sample-controller/pkg/apis/samplecontroller/v1alpha1"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+func TestGenerateHookJobName(t *testing.T) {
+	long := strings.Repeat("a", 50)
+	tests := []struct {
+		name     string
+		evanName string
+		want     string
+	}{
+		{name: "short Evan name", evanName: "my-book", want: "my-book-postrollout-5d4f8b-10"},
+		{name: "long Evan name", evanName: long + "-one"},
+		{name: "other long Evan name of the same prefix", evanName: long + "-two"},
+		{name: "longest Evan name", evanName: strings.Repeat("b", 253)},
+		// One of the two is cut right after a dot.
+		{name: "long Evan name of dotted labels", evanName: strings.Repeat("c.", 40) + "c"},
+		{name: "other long Evan name of dotted labels", evanName: "c" + strings.Repeat("c.", 40)},
+	}
+	seen := map[string]string{}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := generateHookJobName(tt.evanName, samplev1alpha1.PostRolloutHook, "5d4f8b", 10)
+			if tt.want != "" && got != tt.want {
+				t.Errorf("generateHookJobName() = %s, want %s", got, tt.want)
+			}
+			if errs := validation.IsValidLabelValue(got); len(errs) > 0 {
+				t.Errorf("generateHookJobName() = %s, not a valid job-name label: %v", got, errs)
+			}
+			if errs := validation.IsDNS1123Subdomain(got); len(errs) > 0 {
+				t.Errorf("generateHookJobName() = %s, not a valid Job name: %v", got, errs)
+			}
+			if !strings.HasSuffix(got, "-postrollout-5d4f8b-10") {
+				t.Errorf("generateHookJobName() = %s, lost the hook suffix", got)
+			}
+			if other, ok := seen[got]; ok {
+				t.Errorf("generateHookJobName() = %s for both %s and %s", got, other, tt.evanName)
+			}
+			seen[got] = tt.evanName
+		})
+	}
+}
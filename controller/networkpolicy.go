@@ -0,0 +1,116 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+
+	samplev1alpha1 "github.com/evanraisul/k8s-sample-controller/pkg/apis/samplecontroller/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+)
+
+// newNetworkPolicy creates the NetworkPolicy for the pods of an Evan. It
+// selects the pods by the Evan name label, so it covers every Deployment of
+// the strategy, and allows ingress only on the target ports of the Service.
+func newNetworkPolicy(Evan *samplev1alpha1.Evan) *networkingv1.NetworkPolicy {
+	config := Evan.Spec.NetworkPolicy
+
+	var ports []networkingv1.NetworkPolicyPort
+	for _, port := range servicePorts(Evan) {
+		protocol := port.Protocol
+		targetPort := port.TargetPort
+		ports = append(ports, networkingv1.NetworkPolicyPort{
+			Protocol: &protocol,
+			Port:     &targetPort,
+		})
+	}
+
+	policy := &networkingv1.NetworkPolicy{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            Evan.Name,
+			Namespace:       Evan.Namespace,
+			Labels:          map[string]string{EvanNameLabel: Evan.Name},
+			OwnerReferences: ownerReferences(Evan),
+		},
+		Spec: networkingv1.NetworkPolicySpec{
+			PodSelector: metav1.LabelSelector{
+				MatchLabels: map[string]string{EvanNameLabel: Evan.Name},
+			},
+			Ingress: []networkingv1.NetworkPolicyIngressRule{
+				{
+					Ports: ports,
+					From:  config.From,
+				},
+			},
+			PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeIngress},
+		},
+	}
+
+	if len(config.Egress) > 0 {
+		// The API server defaults the protocol of a port to TCP, set it here
+		// so the policy does not look drifted afterwards.
+		egress := make([]networkingv1.NetworkPolicyEgressRule, 0, len(config.Egress))
+		for _, rule := range config.Egress {
+			rule = *rule.DeepCopy()
+			for i := range rule.Ports {
+				if rule.Ports[i].Protocol == nil {
+					protocol := corev1.ProtocolTCP
+					rule.Ports[i].Protocol = &protocol
+				}
+			}
+			egress = append(egress, rule)
+		}
+		policy.Spec.Egress = egress
+		policy.Spec.PolicyTypes = append(policy.Spec.PolicyTypes, networkingv1.PolicyTypeEgress)
+	}
+	return policy
+}
+
+// syncNetworkPolicy creates or updates the NetworkPolicy of the Evan, and
+// deletes the one it created before when spec.networkPolicy was removed.
+func (c *Controller) syncNetworkPolicy(ctx context.Context, Evan *samplev1alpha1.Evan) error {
+	logger := klog.FromContext(ctx)
+
+	policy, err := c.networkPoliciesLister.NetworkPolicies(Evan.Namespace).Get(Evan.Name)
+	if err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+
+	if Evan.Spec.NetworkPolicy == nil {
+		if policy == nil || policy.Labels[EvanNameLabel] != Evan.Name {
+			return nil
+		}
+		logger.V(4).Info("Delete NetworkPolicy resource", "networkPolicy", klog.KObj(policy))
+		err := c.deleteNetworkPolicy(ctx, Evan, policy.Name)
+		if errors.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+
+	desired := newNetworkPolicy(Evan)
+	if policy == nil {
+		err := c.createNetworkPolicy(ctx, Evan, desired)
+		return err
+	}
+	// The NetworkPolicy is named after the Evan, so one written by hand may
+	// well have the same name. Only a policy labeled with the Evan is
+	// updated, whatever the deletion policy.
+	if !isEvanChild(Evan, policy) || policy.Labels[EvanNameLabel] != Evan.Name {
+		msg := fmt.Sprintf(MessageResourceExists, policy.Name)
+		c.recorder.Event(Evan, corev1.EventTypeWarning, ErrResourceExists, msg)
+		return fmt.Errorf("%s", msg)
+	}
+	if equality.Semantic.DeepEqual(desired.Spec, policy.Spec) {
+		return nil
+	}
+	logger.V(4).Info("Update NetworkPolicy resource", "networkPolicy", klog.KObj(policy))
+	policyCopy := policy.DeepCopy()
+	policyCopy.Spec = desired.Spec
+	_, err = c.kubeclientset.NetworkingV1().NetworkPolicies(Evan.Namespace).Update(ctx, policyCopy, metav1.UpdateOptions{})
+	return err
+}
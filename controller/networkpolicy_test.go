@@ -0,0 +1,154 @@
+package controller
+
+import (
+	"context"
+	"testing"
+
+	samplev1alpha1 "github.com/evanraisul/k8s-sample-controller/pkg/apis/samplecontroller/v1alpha1"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	networkinglisters "k8s.io/client-go/listers/networking/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+)
+
+// newTestIndexer returns an indexer, as used by the listers, holding the
+// objects.
+func newTestIndexer(t *testing.T, objects ...runtime.Object) cache.Indexer {
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	for _, object := range objects {
+		if err := indexer.Add(object); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return indexer
+}
+
+func TestSyncNetworkPolicy(t *testing.T) {
+	newEvan := func(deletionPolicy samplev1alpha1.DeletionPolicy, networkPolicy *samplev1alpha1.NetworkPolicyConfig) *samplev1alpha1.Evan {
+		return &samplev1alpha1.Evan{
+			ObjectMeta: metav1.ObjectMeta{Name: "my-book", Namespace: "default", UID: "uid-my-book"},
+			Spec: samplev1alpha1.EvanSpec{
+				DeletionPolicy: deletionPolicy,
+				ServiceConfig:  samplev1alpha1.ServiceConfig{Port: 80},
+				NetworkPolicy:  networkPolicy,
+			},
+		}
+	}
+	foreign := &networkingv1.NetworkPolicy{
+		ObjectMeta: metav1.ObjectMeta{Name: "my-book", Namespace: "default"},
+	}
+
+	tests := []struct {
+		name     string
+		Evan     *samplev1alpha1.Evan
+		existing *networkingv1.NetworkPolicy
+		// drift is applied to the policy generated for the Evan before it
+		// is stored as the existing one.
+		drift      func(policy *networkingv1.NetworkPolicy)
+		wantErr    bool
+		wantPolicy bool
+		// wantPorts is the number of ingress ports of the stored policy.
+		wantPorts int
+	}{
+		{
+			name:       "created",
+			Evan:       newEvan("WipeOut", &samplev1alpha1.NetworkPolicyConfig{}),
+			wantPolicy: true,
+			wantPorts:  1,
+		},
+		{
+			name: "drifted policy is updated",
+			Evan: newEvan("WipeOut", &samplev1alpha1.NetworkPolicyConfig{}),
+			drift: func(policy *networkingv1.NetworkPolicy) {
+				policy.Spec.Ingress[0].Ports = nil
+			},
+			wantPolicy: true,
+			wantPorts:  1,
+		},
+		{
+			name:       "removed with spec.networkPolicy",
+			Evan:       newEvan("WipeOut", nil),
+			drift:      func(policy *networkingv1.NetworkPolicy) {},
+			wantPolicy: false,
+		},
+		{
+			name:       "foreign policy is not removed",
+			Evan:       newEvan("WipeOut", nil),
+			existing:   foreign,
+			wantPolicy: true,
+		},
+		{
+			name:       "foreign policy of a WipeOut Evan is refused",
+			Evan:       newEvan("WipeOut", &samplev1alpha1.NetworkPolicyConfig{}),
+			existing:   foreign,
+			wantErr:    true,
+			wantPolicy: true,
+		},
+		{
+			name: "drifted policy of a Delete Evan is updated",
+			Evan: newEvan("Delete", &samplev1alpha1.NetworkPolicyConfig{}),
+			drift: func(policy *networkingv1.NetworkPolicy) {
+				policy.Spec.Ingress[0].Ports = nil
+			},
+			wantPolicy: true,
+			wantPorts:  1,
+		},
+		{
+			name:       "foreign policy of a Delete Evan is not overwritten",
+			Evan:       newEvan("Delete", &samplev1alpha1.NetworkPolicyConfig{}),
+			existing:   foreign,
+			wantErr:    true,
+			wantPolicy: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			existing := tt.existing
+			if tt.drift != nil {
+				withPolicy := tt.Evan.DeepCopy()
+				withPolicy.Spec.NetworkPolicy = &samplev1alpha1.NetworkPolicyConfig{}
+				existing = newNetworkPolicy(withPolicy)
+				tt.drift(existing)
+			}
+			var objects []runtime.Object
+			if existing != nil {
+				objects = append(objects, existing)
+			}
+			c := &Controller{
+				kubeclientset:         fake.NewSimpleClientset(objects...),
+				networkPoliciesLister: networkinglisters.NewNetworkPolicyLister(newTestIndexer(t, objects...)),
+				recorder:              record.NewFakeRecorder(10),
+				expectations:          newControllerExpectations(),
+			}
+
+			err := c.syncNetworkPolicy(context.Background(), tt.Evan)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("syncNetworkPolicy() error = %v, want error: %v", err, tt.wantErr)
+			}
+
+			policy, err := c.kubeclientset.NetworkingV1().NetworkPolicies("default").Get(context.Background(), "my-book", metav1.GetOptions{})
+			if errors.IsNotFound(err) {
+				if tt.wantPolicy {
+					t.Fatal("syncNetworkPolicy() left no NetworkPolicy")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !tt.wantPolicy {
+				t.Fatal("syncNetworkPolicy() did not remove the NetworkPolicy")
+			}
+			if tt.wantPorts > 0 && (len(policy.Spec.Ingress) != 1 || len(policy.Spec.Ingress[0].Ports) != tt.wantPorts) {
+				t.Errorf("syncNetworkPolicy() ingress = %v, want %d ports", policy.Spec.Ingress, tt.wantPorts)
+			}
+			if tt.existing != nil && len(policy.Spec.Ingress) != 0 {
+				t.Errorf("syncNetworkPolicy() overwrote the foreign policy, ingress = %v", policy.Spec.Ingress)
+			}
+		})
+	}
+}
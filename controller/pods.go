@@ -0,0 +1,158 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	samplev1alpha1 "github.com/evanraisul/k8s-sample-controller/pkg/apis/samplecontroller/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// podStatusSyncDelay is how long pod changes are batched before the Evan
+	// is synced. Pods change far more often than anything else the Evan
+	// watches, so this bounds how often their status is written.
+	podStatusSyncDelay = 5 * time.Second
+
+	reasonCrashLoopBackOff = "CrashLoopBackOff"
+)
+
+// handlePod enqueues the Evan a pod belongs to. All pod changes within
+// podStatusSyncDelay end up in a single sync since the work queue only keeps
+// one pending entry per Evan.
+func (c *Controller) handlePod(obj interface{}) {
+	pod, ok := obj.(*corev1.Pod)
+	if !ok {
+		tombstone, ok := obj.(cache.DeletedFinalStateUnknown)
+		if !ok {
+			utilruntime.HandleError(fmt.Errorf("error decoding object, invalid type"))
+			return
+		}
+		pod, ok = tombstone.Obj.(*corev1.Pod)
+		if !ok {
+			utilruntime.HandleError(fmt.Errorf("error decoding object tombstone, invalid type"))
+			return
+		}
+	}
+	name, ok := pod.Labels[EvanNameLabel]
+	if !ok {
+		return
+	}
+	Evan, err := c.evansLister.Evans(pod.Namespace).Get(name)
+	if err != nil {
+		klog.FromContext(context.Background()).V(4).Info("Ignore pod of unknown Evan", "pod", klog.KObj(pod), "Evan", name)
+		return
+	}
+	c.enqueueEvanAfter(Evan, podStatusSyncDelay)
+}
+
+// podUnhealthyReasons returns the reasons a pod is unhealthy, each reported
+// once per pod.
+func podUnhealthyReasons(pod *corev1.Pod) []string {
+	reasons := map[string]bool{}
+	if pod.Status.Phase == corev1.PodFailed && pod.Status.Reason != "" {
+		reasons[pod.Status.Reason] = true
+	}
+	for _, status := range pod.Status.ContainerStatuses {
+		if status.State.Waiting != nil && status.State.Waiting.Reason != "" && status.State.Waiting.Reason != "ContainerCreating" {
+			reasons[status.State.Waiting.Reason] = true
+		}
+		if terminated := status.LastTerminationState.Terminated; terminated != nil && terminated.Reason == "OOMKilled" {
+			reasons[terminated.Reason] = true
+		}
+		if terminated := status.State.Terminated; terminated != nil && terminated.ExitCode != 0 && terminated.Reason != "" {
+			reasons[terminated.Reason] = true
+		}
+	}
+	var list []string
+	for reason := range reasons {
+		list = append(list, reason)
+	}
+	return list
+}
+
+// isPodReady reports whether the Ready condition of the pod is True.
+func isPodReady(pod *corev1.Pod) bool {
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == corev1.PodReady {
+			return condition.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// syncPodHealth aggregates the pods of the Evan into status.podHealth. It
+// returns a message when pods running the current image are crash looping,
+// which fails the rollout.
+func (c *Controller) syncPodHealth(Evan *samplev1alpha1.Evan, status *samplev1alpha1.EvanStatus) (string, error) {
+	selector := labels.SelectorFromSet(labels.Set{EvanNameLabel: Evan.Name})
+	pods, err := c.podsLister.Pods(Evan.Namespace).List(selector)
+	if err != nil {
+		return "", err
+	}
+
+	health := &samplev1alpha1.PodHealth{}
+	images := map[string]int32{}
+	crashLooping := 0
+	for _, pod := range pods {
+		health.Pods++
+		if isPodReady(pod) {
+			health.ReadyPods++
+		}
+		for _, reason := range podUnhealthyReasons(pod) {
+			if health.Reasons == nil {
+				health.Reasons = map[string]int32{}
+			}
+			health.Reasons[reason]++
+		}
+
+		specImages := map[string]string{}
+		for _, container := range pod.Spec.Containers {
+			images[container.Image]++
+			specImages[container.Name] = container.Image
+		}
+		for _, containerStatus := range pod.Status.ContainerStatuses {
+			if containerStatus.State.Waiting != nil && containerStatus.State.Waiting.Reason == reasonCrashLoopBackOff &&
+				specImages[containerStatus.Name] == Evan.Spec.DeploymentConfig.Image {
+				crashLooping++
+			}
+
+			terminated := containerStatus.LastTerminationState.Terminated
+			if containerStatus.State.Terminated != nil {
+				terminated = containerStatus.State.Terminated
+			}
+			if terminated == nil {
+				continue
+			}
+			if health.LastTermination == nil || health.LastTermination.FinishedAt.Before(&terminated.FinishedAt) {
+				health.LastTermination = &samplev1alpha1.ContainerTermination{
+					Pod:        pod.Name,
+					Container:  containerStatus.Name,
+					Reason:     terminated.Reason,
+					Message:    terminated.Message,
+					ExitCode:   terminated.ExitCode,
+					FinishedAt: terminated.FinishedAt,
+				}
+			}
+		}
+	}
+
+	for image, count := range images {
+		health.Images = append(health.Images, samplev1alpha1.ImageCount{Image: image, Pods: count})
+	}
+	sort.Slice(health.Images, func(i, j int) bool {
+		return health.Images[i].Image < health.Images[j].Image
+	})
+	status.PodHealth = health
+
+	if crashLooping > 0 {
+		return fmt.Sprintf("%d pods running %s are in %s", crashLooping, Evan.Spec.DeploymentConfig.Image, reasonCrashLoopBackOff), nil
+	}
+	return "", nil
+}
@@ -0,0 +1,140 @@
+package controller
+
+import (
+	"slices"
+
+	samplev1alpha1 "github.com/evanraisul/k8s-sample-controller/pkg/apis/samplecontroller/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// servicePorts returns the ports of the Evan with their defaults applied. The
+// single port fields of ServiceConfig are used when no ports list is given.
+func servicePorts(Evan *samplev1alpha1.Evan) []samplev1alpha1.ServicePort {
+	ports := Evan.Spec.ServiceConfig.Ports
+	if len(ports) == 0 {
+		if Evan.Spec.ServiceConfig.Port == 0 {
+			return nil
+		}
+		port := samplev1alpha1.ServicePort{
+			Port:     Evan.Spec.ServiceConfig.Port,
+			NodePort: Evan.Spec.ServiceConfig.NodePort,
+		}
+		if Evan.Spec.ServiceConfig.TargetPort != 0 {
+			port.TargetPort = intstr.FromInt32(Evan.Spec.ServiceConfig.TargetPort)
+		}
+		ports = []samplev1alpha1.ServicePort{port}
+	}
+
+	defaulted := make([]samplev1alpha1.ServicePort, 0, len(ports))
+	for _, port := range ports {
+		port = *port.DeepCopy()
+		if port.Protocol == "" {
+			port.Protocol = corev1.ProtocolTCP
+		}
+		if port.TargetPort.Type == intstr.Int && port.TargetPort.IntVal == 0 {
+			port.TargetPort = intstr.FromInt32(port.Port)
+		}
+		defaulted = append(defaulted, port)
+	}
+	return defaulted
+}
+
+// newContainerPorts returns the container ports that back the Service ports.
+// A named target port becomes a container port of that name listening on the
+// Service port. Container port names must be IANA service names of at most 15
+// characters, so longer Service port names are left off the container port,
+// and a name shared by several Service ports is declared once.
+func newContainerPorts(ports []samplev1alpha1.ServicePort) []corev1.ContainerPort {
+	var containerPorts []corev1.ContainerPort
+	names := map[string]bool{}
+	for _, port := range ports {
+		containerPort := corev1.ContainerPort{
+			Name:          port.Name,
+			ContainerPort: port.TargetPort.IntVal,
+			Protocol:      port.Protocol,
+		}
+		if port.TargetPort.Type == intstr.String {
+			// The API server rejects the Service of an invalid target port
+			// name; one that is declared already is served by the first
+			// Service port that named it.
+			if len(validation.IsValidPortName(port.TargetPort.StrVal)) > 0 || names[port.TargetPort.StrVal] {
+				continue
+			}
+			containerPort.Name = port.TargetPort.StrVal
+			containerPort.ContainerPort = port.Port
+		} else if len(validation.IsValidPortName(containerPort.Name)) > 0 || names[containerPort.Name] {
+			containerPort.Name = ""
+		}
+		if slices.Contains(containerPorts, containerPort) {
+			continue
+		}
+		if containerPort.Name != "" {
+			names[containerPort.Name] = true
+		}
+		containerPorts = append(containerPorts, containerPort)
+	}
+	return containerPorts
+}
+
+// newServicePorts returns the ports of the Service.
+func newServicePorts(ports []samplev1alpha1.ServicePort) []corev1.ServicePort {
+	var servicePorts []corev1.ServicePort
+	for _, port := range ports {
+		servicePorts = append(servicePorts, corev1.ServicePort{
+			Name:        port.Name,
+			Protocol:    port.Protocol,
+			AppProtocol: port.AppProtocol,
+			Port:        port.Port,
+			TargetPort:  port.TargetPort,
+			NodePort:    port.NodePort,
+		})
+	}
+	return servicePorts
+}
+
+// isServicePortsChanged compares every port of the Service with the desired
+// one. A node port that is not requested is allocated by the API server and
+// does not count as drift.
+func isServicePortsChanged(evanPorts []corev1.ServicePort, servicePorts []corev1.ServicePort) bool {
+	if len(evanPorts) != len(servicePorts) {
+		return true
+	}
+	for i, desired := range evanPorts {
+		actual := servicePorts[i]
+		if desired.Name != actual.Name || desired.Protocol != actual.Protocol || desired.Port != actual.Port ||
+			desired.TargetPort != actual.TargetPort {
+			return true
+		}
+		if !equalStringPointers(desired.AppProtocol, actual.AppProtocol) {
+			return true
+		}
+		if desired.NodePort != 0 && desired.NodePort != actual.NodePort {
+			return true
+		}
+	}
+	return false
+}
+
+// isContainerPortsChanged compares every port of the container with the
+// desired one.
+func isContainerPortsChanged(evanPorts []corev1.ContainerPort, containerPorts []corev1.ContainerPort) bool {
+	if len(evanPorts) != len(containerPorts) {
+		return true
+	}
+	for i, desired := range evanPorts {
+		actual := containerPorts[i]
+		if desired.Name != actual.Name || desired.ContainerPort != actual.ContainerPort || desired.Protocol != actual.Protocol {
+			return true
+		}
+	}
+	return false
+}
+
+func equalStringPointers(a, b *string) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
@@ -0,0 +1,234 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+
+	samplev1alpha1 "github.com/evanraisul/k8s-sample-controller/pkg/apis/samplecontroller/v1alpha1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+)
+
+// Reasons of the Progressing and Available conditions of an Evan.
+const (
+	ReasonRolloutInProgress        = "RolloutInProgress"
+	ReasonRolloutComplete          = "RolloutComplete"
+	ReasonProgressDeadlineExceeded = "ProgressDeadlineExceeded"
+	ReasonReplicaFailure           = "ReplicaFailure"
+	ReasonRolloutAborted           = "RolloutAborted"
+	ReasonDeploymentAvailable      = "DeploymentAvailable"
+	ReasonDeploymentUnavailable    = "DeploymentUnavailable"
+	ReasonPreRolloutHookRunning    = "PreRolloutHookRunning"
+	ReasonPostRolloutHookRunning   = "PostRolloutHookRunning"
+	ReasonHookFailed               = "HookFailed"
+)
+
+const (
+	// RolloutFailed is used as part of the Event 'reason' when the rollout of
+	// an Evan fails
+	RolloutFailed = "RolloutFailed"
+	// RollingBackOnFailure is used as part of the Event 'reason' when a
+	// failed rollout is rolled back to the last good revision
+	RollingBackOnFailure = "RollingBackOnFailure"
+
+	// MessageRolloutFailed is the message used for an Event fired when the
+	// rollout of an Evan fails
+	MessageRolloutFailed = "Revision %d failed to roll out: %s"
+	// MessageRollingBackOnFailure is the message used for an Event fired when
+	// a failed rollout is rolled back to the last good revision
+	MessageRollingBackOnFailure = "Rolling back to revision %d because revision %d failed: %s"
+)
+
+// rolloutDeploymentName returns the name of the Deployment that carries the
+// version being rolled out, which depends on the strategy.
+func rolloutDeploymentName(Evan *samplev1alpha1.Evan, deploymentName string, status *samplev1alpha1.EvanStatus) string {
+	switch Evan.Spec.Strategy {
+	case samplev1alpha1.BlueGreenStrategyType:
+		if status.BlueGreen == nil {
+			return generateColorDeploymentName(deploymentName, colorBlue)
+		}
+		if status.BlueGreen.PreviewColor != "" {
+			return generateColorDeploymentName(deploymentName, status.BlueGreen.PreviewColor)
+		}
+		return generateColorDeploymentName(deploymentName, status.BlueGreen.ActiveColor)
+	case samplev1alpha1.CanaryStrategyType:
+		if status.Canary != nil && (status.Canary.Phase == samplev1alpha1.CanaryPhaseProgressing || status.Canary.Phase == samplev1alpha1.CanaryPhasePaused) {
+			return generateCanaryDeploymentName(deploymentName)
+		}
+	}
+	return deploymentName
+}
+
+// rolloutFailure returns the reason and message of a rollout that failed, or
+// an empty reason while it is still healthy. A rollout fails when its
+// Deployment does, when the pods of the new version are crash looping, or
+// when the strategy aborted it. A StatefulSet has no Deployment to fail.
+func rolloutFailure(status *samplev1alpha1.EvanStatus, deployment *appsv1.Deployment, crashLoop string) (string, string) {
+	if crashLoop != "" {
+		return reasonCrashLoopBackOff, crashLoop
+	}
+	if status.Canary != nil && status.Canary.Phase == samplev1alpha1.CanaryPhaseAborted {
+		return ReasonRolloutAborted, fmt.Sprintf("canary of image %s was aborted", status.Canary.CanaryImage)
+	}
+	if status.BlueGreen != nil && status.BlueGreen.AbortedImage != "" {
+		return ReasonRolloutAborted, fmt.Sprintf("preview of image %s was aborted", status.BlueGreen.AbortedImage)
+	}
+	if deployment == nil {
+		return "", ""
+	}
+	for _, condition := range deployment.Status.Conditions {
+		switch {
+		case condition.Type == appsv1.DeploymentProgressing && condition.Status == corev1.ConditionFalse &&
+			condition.Reason == ReasonProgressDeadlineExceeded:
+			return ReasonProgressDeadlineExceeded, condition.Message
+		case condition.Type == appsv1.DeploymentReplicaFailure && condition.Status == corev1.ConditionTrue:
+			return ReasonReplicaFailure, condition.Message
+		}
+	}
+	return "", ""
+}
+
+// syncRolloutProgress tracks the rollout of the current revision to
+// completion, using the crash loop reported by syncPodHealth. It sets the
+// Progressing and Available conditions, runs the post-rollout hook, remembers
+// the last revision that rolled out successfully and, if
+// spec.rollbackOnFailure is set, rolls a failed rollout back to it. It
+// reports whether the Evan was rolled back, in which case the sync stops.
+func (c *Controller) syncRolloutProgress(ctx context.Context, Evan *samplev1alpha1.Evan, deploymentName string, crashLoop string, status *samplev1alpha1.EvanStatus) (bool, error) {
+	var kind, name string
+	var available, rolledOut bool
+	var deployment *appsv1.Deployment
+	if Evan.Spec.WorkloadKind == samplev1alpha1.StatefulSetWorkloadKind {
+		kind, name = "StatefulSet", deploymentName
+		statefulSet, err := c.statefulSetsLister.StatefulSets(Evan.Namespace).Get(name)
+		if errors.IsNotFound(err) {
+			// Just created, the informer will catch up.
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		available = statefulSet.Spec.Replicas == nil || statefulSet.Status.AvailableReplicas >= *statefulSet.Spec.Replicas
+		rolledOut = isStatefulSetRolledOut(statefulSet) && isStatefulSetUpToDate(Evan, statefulSet)
+	} else {
+		kind, name = "Deployment", rolloutDeploymentName(Evan, deploymentName, status)
+		var err error
+		deployment, err = c.deploymentsLister.Deployments(Evan.Namespace).Get(name)
+		if errors.IsNotFound(err) {
+			// Just created, the informer will catch up.
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		for _, condition := range deployment.Status.Conditions {
+			if condition.Type == appsv1.DeploymentAvailable {
+				available = condition.Status == corev1.ConditionTrue
+			}
+		}
+		rolledOut = isDeploymentRolledOut(deployment) && isDeploymentUpToDate(Evan, deployment)
+	}
+
+	if available {
+		setCondition(Evan, status, samplev1alpha1.EvanConditionAvailable, metav1.ConditionTrue, ReasonDeploymentAvailable,
+			fmt.Sprintf("%s %s has minimum availability", kind, name))
+	} else {
+		setCondition(Evan, status, samplev1alpha1.EvanConditionAvailable, metav1.ConditionFalse, ReasonDeploymentUnavailable,
+			fmt.Sprintf("%s %s does not have minimum availability", kind, name))
+	}
+
+	if reason, message := rolloutFailure(status, deployment, crashLoop); reason != "" {
+		return c.failRollout(ctx, Evan, status, reason, message)
+	}
+
+	if rolledOut {
+		if Evan.Spec.Hooks != nil && Evan.Spec.Hooks.PostRollout != nil {
+			run, err := c.runHook(ctx, Evan, status, samplev1alpha1.PostRolloutHook, Evan.Spec.Hooks.PostRollout, Evan.Spec.DeploymentConfig.Image)
+			if err != nil {
+				return false, err
+			}
+			switch run.Phase {
+			case samplev1alpha1.HookPhaseRunning:
+				setCondition(Evan, status, samplev1alpha1.EvanConditionProgressing, metav1.ConditionTrue, ReasonPostRolloutHookRunning,
+					fmt.Sprintf("Waiting for post-rollout hook Job %s of revision %d", run.Job, status.CurrentRevision))
+				return false, nil
+			case samplev1alpha1.HookPhaseFailed:
+				return c.failRollout(ctx, Evan, status, ReasonHookFailed, fmt.Sprintf("post-rollout hook Job %s failed: %s", run.Job, run.Message))
+			}
+		}
+		setCondition(Evan, status, samplev1alpha1.EvanConditionProgressing, metav1.ConditionTrue, ReasonRolloutComplete,
+			fmt.Sprintf("Revision %d has successfully rolled out", status.CurrentRevision))
+		status.LastGoodRevision = status.CurrentRevision
+		return false, nil
+	}
+
+	setCondition(Evan, status, samplev1alpha1.EvanConditionProgressing, metav1.ConditionTrue, ReasonRolloutInProgress,
+		fmt.Sprintf("Revision %d is rolling out", status.CurrentRevision))
+	return false, nil
+}
+
+// failRollout sets the Progressing condition to False for a rollout that
+// failed and, if spec.rollbackOnFailure is set, rolls it back to the last
+// good revision. It reports whether the Evan was rolled back.
+func (c *Controller) failRollout(ctx context.Context, Evan *samplev1alpha1.Evan, status *samplev1alpha1.EvanStatus, reason, message string) (bool, error) {
+	logger := klog.FromContext(ctx)
+
+	failed := meta.IsStatusConditionFalse(status.Conditions, samplev1alpha1.EvanConditionProgressing)
+	setCondition(Evan, status, samplev1alpha1.EvanConditionProgressing, metav1.ConditionFalse, reason,
+		fmt.Sprintf(MessageRolloutFailed, status.CurrentRevision, message))
+	if !failed {
+		c.recorder.Eventf(Evan, corev1.EventTypeWarning, RolloutFailed, MessageRolloutFailed, status.CurrentRevision, message)
+	}
+
+	if Evan.Spec.RollbackOnFailure && status.LastGoodRevision != 0 && status.LastGoodRevision != status.CurrentRevision {
+		logger.Info("Rolling back failed rollout", "revision", status.CurrentRevision, "lastGoodRevision", status.LastGoodRevision, "reason", reason)
+		return c.rollbackToLastGood(ctx, Evan, status, message)
+	}
+	return false, nil
+}
+
+// rollbackToLastGood restores the last good revision into the Evan spec.
+func (c *Controller) rollbackToLastGood(ctx context.Context, Evan *samplev1alpha1.Evan, status *samplev1alpha1.EvanStatus, message string) (bool, error) {
+	revisions, err := c.listRevisions(Evan)
+	if err != nil {
+		return false, err
+	}
+	for _, revision := range revisions {
+		if revision.Revision != status.LastGoodRevision {
+			continue
+		}
+		EvanCopy := Evan.DeepCopy()
+		if err := restoreRevision(EvanCopy, revision); err != nil {
+			return false, err
+		}
+		updated, err := c.sampleclientset.SamplecontrollerV1alpha1().Evans(Evan.Namespace).Update(ctx, EvanCopy, metav1.UpdateOptions{})
+		if err != nil {
+			return false, err
+		}
+		// The status subresource ignores the status sent with the spec.
+		if err := c.updateevan(ctx, updated, status); err != nil {
+			return false, err
+		}
+		c.recorder.Eventf(Evan, corev1.EventTypeWarning, RollingBackOnFailure, MessageRollingBackOnFailure, status.LastGoodRevision, status.CurrentRevision, message)
+		return true, nil
+	}
+	// The last good revision was pruned from the history.
+	c.recorder.Eventf(Evan, corev1.EventTypeWarning, RollbackRevisionNotFound, MessageRollbackRevisionNotFound, fmt.Sprint(status.LastGoodRevision))
+	return false, nil
+}
+
+// setCondition sets a condition on the status, observed at the current
+// generation of the Evan.
+func setCondition(Evan *samplev1alpha1.Evan, status *samplev1alpha1.EvanStatus, conditionType string, conditionStatus metav1.ConditionStatus, reason, message string) {
+	meta.SetStatusCondition(&status.Conditions, metav1.Condition{
+		Type:               conditionType,
+		Status:             conditionStatus,
+		ObservedGeneration: Evan.Generation,
+		Reason:             reason,
+		Message:            message,
+	})
+}
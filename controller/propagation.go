@@ -0,0 +1,157 @@
+package controller
+
+import (
+	"sort"
+	"strings"
+
+	samplev1alpha1 "github.com/evanraisul/k8s-sample-controller/pkg/apis/samplecontroller/v1alpha1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// propagatedLabelsAnnotation and propagatedAnnotationsAnnotation record
+	// the keys copied from the Evan onto a child, so they can be removed from
+	// it once they are no longer propagated.
+	propagatedLabelsAnnotation      = "samplecontroller.evan.com/propagated-labels"
+	propagatedAnnotationsAnnotation = "samplecontroller.evan.com/propagated-annotations"
+	// serviceAnnotationsAnnotation records the keys of serviceConfig.annotations
+	// set on the Service, so they are removed once they are no longer
+	// configured.
+	serviceAnnotationsAnnotation = "samplecontroller.evan.com/service-annotations"
+)
+
+// matchesPropagation reports whether a key is selected by one of the
+// patterns of a PropagationConfig.
+func matchesPropagation(patterns []string, key string) bool {
+	for _, pattern := range patterns {
+		if prefix, ok := strings.CutSuffix(pattern, "*"); ok {
+			if strings.HasPrefix(key, prefix) {
+				return true
+			}
+		} else if key == pattern {
+			return true
+		}
+	}
+	return false
+}
+
+// propagateKeys copies the selected entries of values into target, without
+// overriding the keys the controller sets itself. It returns the copied keys.
+func propagateKeys(patterns []string, values map[string]string, target map[string]string) []string {
+	var keys []string
+	for key, value := range values {
+		if !matchesPropagation(patterns, key) || key == corev1.LastAppliedConfigAnnotation {
+			continue
+		}
+		if _, ok := target[key]; ok {
+			continue
+		}
+		target[key] = value
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// propagateMetadata copies the labels and annotations selected by
+// spec.propagation onto the metadata of a child, and records their keys.
+func propagateMetadata(Evan *samplev1alpha1.Evan, objectMeta *metav1.ObjectMeta) {
+	if Evan.Spec.Propagation == nil {
+		return
+	}
+	if objectMeta.Labels == nil {
+		objectMeta.Labels = map[string]string{}
+	}
+	if objectMeta.Annotations == nil {
+		objectMeta.Annotations = map[string]string{}
+	}
+	labelKeys := propagateKeys(Evan.Spec.Propagation.Labels, Evan.Labels, objectMeta.Labels)
+	annotationKeys := propagateKeys(Evan.Spec.Propagation.Annotations, Evan.Annotations, objectMeta.Annotations)
+	if len(labelKeys) > 0 {
+		objectMeta.Annotations[propagatedLabelsAnnotation] = strings.Join(labelKeys, ",")
+	}
+	if len(annotationKeys) > 0 {
+		objectMeta.Annotations[propagatedAnnotationsAnnotation] = strings.Join(annotationKeys, ",")
+	}
+}
+
+// propagatedKeys returns the keys recorded in an annotation of a child.
+func propagatedKeys(objectMeta metav1.ObjectMeta, recordAnnotation string) map[string]bool {
+	keys := map[string]bool{}
+	if record := objectMeta.Annotations[recordAnnotation]; record != "" {
+		for _, key := range strings.Split(record, ",") {
+			keys[key] = true
+		}
+	}
+	return keys
+}
+
+// keepForeignMetadata adds the labels and annotations that others set on the
+// live child to its desired metadata. Keys the Evan propagated or configured
+// earlier are dropped, so an update removes the stale ones.
+func keepForeignMetadata(desired *metav1.ObjectMeta, current metav1.ObjectMeta) {
+	staleLabels := propagatedKeys(current, propagatedLabelsAnnotation)
+	staleAnnotations := propagatedKeys(current, propagatedAnnotationsAnnotation)
+	for key := range propagatedKeys(current, serviceAnnotationsAnnotation) {
+		staleAnnotations[key] = true
+	}
+	staleAnnotations[propagatedLabelsAnnotation] = true
+	staleAnnotations[propagatedAnnotationsAnnotation] = true
+	staleAnnotations[serviceAnnotationsAnnotation] = true
+
+	for key, value := range current.Labels {
+		if _, ok := desired.Labels[key]; ok || staleLabels[key] {
+			continue
+		}
+		if desired.Labels == nil {
+			desired.Labels = map[string]string{}
+		}
+		desired.Labels[key] = value
+	}
+	for key, value := range current.Annotations {
+		if _, ok := desired.Annotations[key]; ok || staleAnnotations[key] {
+			continue
+		}
+		if desired.Annotations == nil {
+			desired.Annotations = map[string]string{}
+		}
+		desired.Annotations[key] = value
+	}
+}
+
+// isPropagationChanged reports whether the propagated labels or annotations
+// of a child drifted from the desired ones, or a key is no longer propagated.
+func isPropagationChanged(desired metav1.ObjectMeta, current metav1.ObjectMeta) bool {
+	for _, record := range []string{propagatedLabelsAnnotation, propagatedAnnotationsAnnotation} {
+		if desired.Annotations[record] != current.Annotations[record] {
+			return true
+		}
+	}
+	for key := range propagatedKeys(desired, propagatedLabelsAnnotation) {
+		if current.Labels[key] != desired.Labels[key] {
+			return true
+		}
+	}
+	for key := range propagatedKeys(desired, propagatedAnnotationsAnnotation) {
+		if current.Annotations[key] != desired.Annotations[key] {
+			return true
+		}
+	}
+	return false
+}
+
+// keepForeignDeploymentMetadata keeps the labels and annotations others set
+// on the live Deployment and its pod template in the desired one.
+func keepForeignDeploymentMetadata(desired *appsv1.Deployment, deployment *appsv1.Deployment) {
+	keepForeignMetadata(&desired.ObjectMeta, deployment.ObjectMeta)
+	keepForeignMetadata(&desired.Spec.Template.ObjectMeta, deployment.Spec.Template.ObjectMeta)
+}
+
+// isDeploymentPropagationChanged reports whether the propagated metadata of
+// the Deployment or its pod template drifted from the desired one.
+func isDeploymentPropagationChanged(desired *appsv1.Deployment, deployment *appsv1.Deployment) bool {
+	return isPropagationChanged(desired.ObjectMeta, deployment.ObjectMeta) ||
+		isPropagationChanged(desired.Spec.Template.ObjectMeta, deployment.Spec.Template.ObjectMeta)
+}
@@ -0,0 +1,202 @@
+package controller
+
+import (
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestMatchesPropagation(t *testing.T) {
+	tests := []struct {
+		name     string
+		patterns []string
+		key      string
+		want     bool
+	}{
+		{name: "exact key", patterns: []string{"team"}, key: "team", want: true},
+		{name: "other key", patterns: []string{"team"}, key: "teams", want: false},
+		{name: "prefix", patterns: []string{"example.com/*"}, key: "example.com/owner", want: true},
+		{name: "prefix itself", patterns: []string{"example.com/*"}, key: "example.com/", want: true},
+		{name: "outside prefix", patterns: []string{"example.com/*"}, key: "example.org/owner", want: false},
+		{name: "star matches every key", patterns: []string{"*"}, key: "anything", want: true},
+		{name: "no patterns", patterns: nil, key: "team", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := matchesPropagation(tt.patterns, tt.key); got != tt.want {
+				t.Errorf("matchesPropagation(%v, %q) = %v, want %v", tt.patterns, tt.key, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPropagateKeys(t *testing.T) {
+	tests := []struct {
+		name       string
+		patterns   []string
+		values     map[string]string
+		target     map[string]string
+		wantKeys   []string
+		wantTarget map[string]string
+	}{
+		{
+			name:       "selected keys",
+			patterns:   []string{"team", "example.com/*"},
+			values:     map[string]string{"team": "books", "example.com/owner": "evan", "tier": "web"},
+			target:     map[string]string{},
+			wantKeys:   []string{"example.com/owner", "team"},
+			wantTarget: map[string]string{"team": "books", "example.com/owner": "evan"},
+		},
+		{
+			name:       "controller-owned keys are not overridden",
+			patterns:   []string{"*"},
+			values:     map[string]string{EvanNameLabel: "other", "team": "books"},
+			target:     map[string]string{EvanNameLabel: "my-book"},
+			wantKeys:   []string{"team"},
+			wantTarget: map[string]string{EvanNameLabel: "my-book", "team": "books"},
+		},
+		{
+			name:       "last applied configuration is never copied",
+			patterns:   []string{"*"},
+			values:     map[string]string{corev1.LastAppliedConfigAnnotation: "{}"},
+			target:     map[string]string{},
+			wantKeys:   nil,
+			wantTarget: map[string]string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keys := propagateKeys(tt.patterns, tt.values, tt.target)
+			if !reflect.DeepEqual(keys, tt.wantKeys) {
+				t.Errorf("propagateKeys() = %v, want %v", keys, tt.wantKeys)
+			}
+			if !reflect.DeepEqual(tt.target, tt.wantTarget) {
+				t.Errorf("propagateKeys() target = %v, want %v", tt.target, tt.wantTarget)
+			}
+		})
+	}
+}
+
+func TestKeepForeignMetadata(t *testing.T) {
+	tests := []struct {
+		name    string
+		desired metav1.ObjectMeta
+		current metav1.ObjectMeta
+		want    metav1.ObjectMeta
+	}{
+		{
+			name:    "foreign keys are kept",
+			desired: metav1.ObjectMeta{Labels: map[string]string{"app": "my-book"}},
+			current: metav1.ObjectMeta{
+				Labels:      map[string]string{"app": "my-book", "istio": "sidecar"},
+				Annotations: map[string]string{"deployment.kubernetes.io/revision": "3"},
+			},
+			want: metav1.ObjectMeta{
+				Labels:      map[string]string{"app": "my-book", "istio": "sidecar"},
+				Annotations: map[string]string{"deployment.kubernetes.io/revision": "3"},
+			},
+		},
+		{
+			name:    "desired values win",
+			desired: metav1.ObjectMeta{Labels: map[string]string{"team": "books"}},
+			current: metav1.ObjectMeta{Labels: map[string]string{"team": "films"}},
+			want:    metav1.ObjectMeta{Labels: map[string]string{"team": "books"}},
+		},
+		{
+			name:    "stale propagated keys are removed",
+			desired: metav1.ObjectMeta{Labels: map[string]string{"app": "my-book"}},
+			current: metav1.ObjectMeta{
+				Labels: map[string]string{"app": "my-book", "team": "books"},
+				Annotations: map[string]string{
+					propagatedLabelsAnnotation:      "team",
+					propagatedAnnotationsAnnotation: "example.com/owner",
+					"example.com/owner":             "evan",
+				},
+			},
+			want: metav1.ObjectMeta{Labels: map[string]string{"app": "my-book"}},
+		},
+		{
+			name: "stale Service annotations are removed",
+			desired: metav1.ObjectMeta{Annotations: map[string]string{
+				serviceAnnotationsAnnotation: "a",
+				"a":                          "1",
+			}},
+			current: metav1.ObjectMeta{Annotations: map[string]string{
+				serviceAnnotationsAnnotation: "a,service.beta.kubernetes.io/aws-load-balancer-internal",
+				"a":                          "1",
+				"service.beta.kubernetes.io/aws-load-balancer-internal": "true",
+				"foreign": "kept",
+			}},
+			want: metav1.ObjectMeta{Annotations: map[string]string{
+				serviceAnnotationsAnnotation: "a",
+				"a":                          "1",
+				"foreign":                    "kept",
+			}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			desired := *tt.desired.DeepCopy()
+			keepForeignMetadata(&desired, tt.current)
+			if !reflect.DeepEqual(desired.Labels, tt.want.Labels) {
+				t.Errorf("keepForeignMetadata() labels = %v, want %v", desired.Labels, tt.want.Labels)
+			}
+			if !reflect.DeepEqual(desired.Annotations, tt.want.Annotations) {
+				t.Errorf("keepForeignMetadata() annotations = %v, want %v", desired.Annotations, tt.want.Annotations)
+			}
+		})
+	}
+}
+
+func TestIsPropagationChanged(t *testing.T) {
+	propagated := metav1.ObjectMeta{
+		Labels:      map[string]string{"team": "books"},
+		Annotations: map[string]string{propagatedLabelsAnnotation: "team"},
+	}
+	tests := []struct {
+		name    string
+		desired metav1.ObjectMeta
+		current metav1.ObjectMeta
+		want    bool
+	}{
+		{
+			name:    "in sync",
+			desired: propagated,
+			current: propagated,
+			want:    false,
+		},
+		{
+			name:    "value drifted",
+			desired: propagated,
+			current: metav1.ObjectMeta{
+				Labels:      map[string]string{"team": "films"},
+				Annotations: map[string]string{propagatedLabelsAnnotation: "team"},
+			},
+			want: true,
+		},
+		{
+			name:    "key no longer propagated",
+			desired: metav1.ObjectMeta{},
+			current: propagated,
+			want:    true,
+		},
+		{
+			name:    "foreign keys do not count",
+			desired: propagated,
+			current: metav1.ObjectMeta{
+				Labels:      map[string]string{"team": "books", "istio": "sidecar"},
+				Annotations: map[string]string{propagatedLabelsAnnotation: "team", "foreign": "kept"},
+			},
+			want: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isPropagationChanged(tt.desired, tt.current); got != tt.want {
+				t.Errorf("isPropagationChanged() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
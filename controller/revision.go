@@ -0,0 +1,250 @@
+package controller
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"strconv"
+
+	samplev1alpha1 "github.com/evanraisul/k8s-sample-controller/pkg/apis/samplecontroller/v1alpha1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/rand"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// changeCauseAnnotation is copied from the Evan onto the revision it
+	// creates, the same way kubectl records it for Deployments.
+	changeCauseAnnotation = "kubernetes.io/change-cause"
+	// rollbackToAnnotation requests a rollback like spec.rollbackTo does.
+	rollbackToAnnotation = "samplecontroller.evan.com/rollback-to"
+
+	defaultRevisionHistoryLimit = 10
+)
+
+const (
+	// RolledBack is used as part of the Event 'reason' when an Evan is rolled
+	// back to a previous revision
+	RolledBack = "RolledBack"
+	// RollbackRevisionNotFound is used as part of the Event 'reason' when the
+	// revision to roll back to does not exist
+	RollbackRevisionNotFound = "RollbackRevisionNotFound"
+
+	// MessageRolledBack is the message used for an Event fired when an Evan
+	// is rolled back to a previous revision
+	MessageRolledBack = "Rolled back to revision %d"
+	// MessageRollbackRevisionNotFound is the message used for an Event fired
+	// when the revision to roll back to does not exist
+	MessageRollbackRevisionNotFound = "Unable to find revision %s to roll back to"
+)
+
+// revisionData is the part of the Evan spec recorded in a ControllerRevision.
+type revisionData struct {
+	DeploymentConfig samplev1alpha1.DeploymentConfig `json:"deploymentConfig"`
+	ServiceConfig    samplev1alpha1.ServiceConfig    `json:"serviceConfig"`
+}
+
+// revisionHash returns a short, stable hash of the revision data, used to
+// name the ControllerRevision.
+func revisionHash(data []byte) string {
+	hasher := fnv.New32a()
+	hasher.Write(data)
+	return rand.SafeEncodeString(fmt.Sprint(hasher.Sum32()))
+}
+
+// listRevisions returns the ControllerRevisions of the Evan, oldest first.
+func (c *Controller) listRevisions(Evan *samplev1alpha1.Evan) ([]*appsv1.ControllerRevision, error) {
+	selector := labels.SelectorFromSet(labels.Set{EvanNameLabel: Evan.Name})
+	all, err := c.controllerRevisionsLister.ControllerRevisions(Evan.Namespace).List(selector)
+	if err != nil {
+		return nil, err
+	}
+	var revisions []*appsv1.ControllerRevision
+	for _, revision := range all {
+		if metav1.IsControlledBy(revision, Evan) {
+			revisions = append(revisions, revision)
+		}
+	}
+	sort.Slice(revisions, func(i, j int) bool {
+		return revisions[i].Revision < revisions[j].Revision
+	})
+	return revisions, nil
+}
+
+// syncRevisions records the current deploymentConfig and serviceConfig of the
+// Evan as a ControllerRevision and prunes the history down to
+// spec.revisionHistoryLimit. A spec that matches an older revision, as after
+// a rollback, moves that revision to the top instead of creating a new one.
+func (c *Controller) syncRevisions(ctx context.Context, Evan *samplev1alpha1.Evan, status *samplev1alpha1.EvanStatus) error {
+	logger := klog.FromContext(ctx)
+
+	data, err := json.Marshal(revisionData{
+		DeploymentConfig: Evan.Spec.DeploymentConfig,
+		ServiceConfig:    Evan.Spec.ServiceConfig,
+	})
+	if err != nil {
+		return err
+	}
+
+	revisions, err := c.listRevisions(Evan)
+	if err != nil {
+		return err
+	}
+
+	var latest int64
+	var current *appsv1.ControllerRevision
+	for _, revision := range revisions {
+		if bytes.Equal(revision.Data.Raw, data) {
+			current = revision
+		}
+		latest = revision.Revision
+	}
+
+	switch {
+	case current == nil:
+		revision := &appsv1.ControllerRevision{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      fmt.Sprintf("%s-%s", Evan.Name, revisionHash(data)),
+				Namespace: Evan.Namespace,
+				Labels:    map[string]string{EvanNameLabel: Evan.Name},
+				OwnerReferences: []metav1.OwnerReference{
+					*metav1.NewControllerRef(Evan, samplev1alpha1.SchemeGroupVersion.WithKind("Evan")),
+				},
+			},
+			Data:     runtime.RawExtension{Raw: data},
+			Revision: latest + 1,
+		}
+		if changeCause, ok := Evan.Annotations[changeCauseAnnotation]; ok {
+			revision.Annotations = map[string]string{changeCauseAnnotation: changeCause}
+		}
+		// The Evan is only synced once the informer observed the revisions
+		// it created, so a revision that already exists has different data.
+		current, err = c.createControllerRevision(ctx, Evan, revision)
+		if err != nil {
+			return err
+		}
+		logger.V(4).Info("Created revision", "revision", current.Revision, "controllerRevision", klog.KObj(current))
+		revisions = append(revisions, current)
+	case current.Revision != latest:
+		revision := current.DeepCopy()
+		revision.Revision = latest + 1
+		if changeCause, ok := Evan.Annotations[changeCauseAnnotation]; ok {
+			if revision.Annotations == nil {
+				revision.Annotations = map[string]string{}
+			}
+			revision.Annotations[changeCauseAnnotation] = changeCause
+		}
+		current, err = c.kubeclientset.AppsV1().ControllerRevisions(Evan.Namespace).Update(ctx, revision, metav1.UpdateOptions{})
+		if err != nil {
+			return err
+		}
+		logger.V(4).Info("Reused revision", "revision", current.Revision, "controllerRevision", klog.KObj(current))
+	}
+	status.CurrentRevision = current.Revision
+
+	limit := int32(defaultRevisionHistoryLimit)
+	if Evan.Spec.RevisionHistoryLimit != nil {
+		limit = *Evan.Spec.RevisionHistoryLimit
+	}
+	// The limit counts the old revisions, the current one is always kept.
+	var old []*appsv1.ControllerRevision
+	for _, revision := range revisions {
+		if revision.Name != current.Name {
+			old = append(old, revision)
+		}
+	}
+	for i := 0; i < len(old)-int(limit); i++ {
+		err := c.deleteControllerRevision(ctx, Evan, old[i].Name)
+		if err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// rollback restores the deploymentConfig and serviceConfig of the revision
+// requested through spec.rollbackTo or the rollback-to annotation, and clears
+// the request. It reports whether the Evan was updated, in which case the
+// sync stops and continues with the updated Evan.
+func (c *Controller) rollback(ctx context.Context, Evan *samplev1alpha1.Evan) (bool, error) {
+	var target string
+	switch {
+	case Evan.Spec.RollbackTo != nil:
+		target = strconv.FormatInt(Evan.Spec.RollbackTo.Revision, 10)
+	case Evan.Annotations[rollbackToAnnotation] != "":
+		target = Evan.Annotations[rollbackToAnnotation]
+	default:
+		return false, nil
+	}
+
+	EvanCopy := Evan.DeepCopy()
+	EvanCopy.Spec.RollbackTo = nil
+	delete(EvanCopy.Annotations, rollbackToAnnotation)
+
+	revision, err := c.findRollbackRevision(Evan, target)
+	if err != nil {
+		return false, err
+	}
+	if revision == nil {
+		c.recorder.Eventf(Evan, corev1.EventTypeWarning, RollbackRevisionNotFound, MessageRollbackRevisionNotFound, target)
+	} else if err := restoreRevision(EvanCopy, revision); err != nil {
+		return false, err
+	}
+
+	if _, err := c.sampleclientset.SamplecontrollerV1alpha1().Evans(Evan.Namespace).Update(ctx, EvanCopy, metav1.UpdateOptions{}); err != nil {
+		return false, err
+	}
+	if revision != nil {
+		c.recorder.Eventf(Evan, corev1.EventTypeNormal, RolledBack, MessageRolledBack, revision.Revision)
+	}
+	return true, nil
+}
+
+// restoreRevision copies the deploymentConfig and serviceConfig recorded in
+// the revision into the Evan.
+func restoreRevision(Evan *samplev1alpha1.Evan, revision *appsv1.ControllerRevision) error {
+	var data revisionData
+	if err := json.Unmarshal(revision.Data.Raw, &data); err != nil {
+		return err
+	}
+	Evan.Spec.DeploymentConfig = data.DeploymentConfig
+	Evan.Spec.ServiceConfig = data.ServiceConfig
+	if Evan.Annotations == nil {
+		Evan.Annotations = map[string]string{}
+	}
+	Evan.Annotations[changeCauseAnnotation] = fmt.Sprintf("rollback to revision %d", revision.Revision)
+	return nil
+}
+
+// findRollbackRevision returns the revision with the given number, or the one
+// before the latest for "0". It returns nil if there is no such revision.
+func (c *Controller) findRollbackRevision(Evan *samplev1alpha1.Evan, target string) (*appsv1.ControllerRevision, error) {
+	number, err := strconv.ParseInt(target, 10, 64)
+	if err != nil {
+		return nil, nil
+	}
+	revisions, err := c.listRevisions(Evan)
+	if err != nil {
+		return nil, err
+	}
+	if number == 0 {
+		if len(revisions) < 2 {
+			return nil, nil
+		}
+		return revisions[len(revisions)-2], nil
+	}
+	for _, revision := range revisions {
+		if revision.Revision == number {
+			return revision, nil
+		}
+	}
+	return nil, nil
+}
@@ -0,0 +1,237 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	samplev1alpha1 "github.com/evanraisul/k8s-sample-controller/pkg/apis/samplecontroller/v1alpha1"
+	samplefake "github.com/evanraisul/k8s-sample-controller/pkg/generated/clientset/versioned/fake"
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	appslisters "k8s.io/client-go/listers/apps/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+)
+
+// newRevisionEvan returns an Evan running the image.
+func newRevisionEvan(image string) *samplev1alpha1.Evan {
+	return &samplev1alpha1.Evan{
+		ObjectMeta: metav1.ObjectMeta{Name: "my-book", Namespace: "default", UID: "uid-my-book"},
+		Spec: samplev1alpha1.EvanSpec{
+			DeploymentConfig: samplev1alpha1.DeploymentConfig{Image: image},
+			ServiceConfig:    samplev1alpha1.ServiceConfig{Port: 80},
+		},
+	}
+}
+
+// newTestRevision returns the revision of the Evan that recorded the image.
+func newTestRevision(t *testing.T, Evan *samplev1alpha1.Evan, number int64, image string) *appsv1.ControllerRevision {
+	spec := newRevisionEvan(image).Spec
+	data, err := json.Marshal(revisionData{DeploymentConfig: spec.DeploymentConfig, ServiceConfig: spec.ServiceConfig})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &appsv1.ControllerRevision{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      fmt.Sprintf("%s-%s", Evan.Name, revisionHash(data)),
+			Namespace: Evan.Namespace,
+			Labels:    map[string]string{EvanNameLabel: Evan.Name},
+			OwnerReferences: []metav1.OwnerReference{
+				*metav1.NewControllerRef(Evan, samplev1alpha1.SchemeGroupVersion.WithKind("Evan")),
+			},
+		},
+		Data:     runtime.RawExtension{Raw: data},
+		Revision: number,
+	}
+}
+
+// newRevisionController returns a Controller whose clients and revision
+// lister start out with the Evan and its revisions.
+func newRevisionController(t *testing.T, Evan *samplev1alpha1.Evan, revisions ...*appsv1.ControllerRevision) *Controller {
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	var objects []runtime.Object
+	for _, revision := range revisions {
+		if err := indexer.Add(revision); err != nil {
+			t.Fatal(err)
+		}
+		objects = append(objects, revision)
+	}
+	return &Controller{
+		kubeclientset:             fake.NewSimpleClientset(objects...),
+		sampleclientset:           samplefake.NewSimpleClientset(Evan),
+		controllerRevisionsLister: appslisters.NewControllerRevisionLister(indexer),
+		recorder:                  record.NewFakeRecorder(10),
+		expectations:              newControllerExpectations(),
+	}
+}
+
+func TestSyncRevisions(t *testing.T) {
+	limit := int32(1)
+	tests := []struct {
+		name string
+		// image is the image of the Evan, the revisions record images
+		// 1..n in order.
+		image     string
+		revisions []string
+		limit     *int32
+		// want is the images of the revisions left, by revision number.
+		want        map[int64]string
+		wantCurrent int64
+	}{
+		{
+			name:        "first revision",
+			image:       "book:v1",
+			want:        map[int64]string{1: "book:v1"},
+			wantCurrent: 1,
+		},
+		{
+			name:        "unchanged spec",
+			image:       "book:v2",
+			revisions:   []string{"book:v1", "book:v2"},
+			want:        map[int64]string{1: "book:v1", 2: "book:v2"},
+			wantCurrent: 2,
+		},
+		{
+			name:        "new spec",
+			image:       "book:v3",
+			revisions:   []string{"book:v1", "book:v2"},
+			want:        map[int64]string{1: "book:v1", 2: "book:v2", 3: "book:v3"},
+			wantCurrent: 3,
+		},
+		{
+			name:        "spec of an older revision moves it to the top",
+			image:       "book:v1",
+			revisions:   []string{"book:v1", "book:v2"},
+			want:        map[int64]string{2: "book:v2", 3: "book:v1"},
+			wantCurrent: 3,
+		},
+		{
+			name:        "history is pruned to the limit",
+			image:       "book:v3",
+			revisions:   []string{"book:v1", "book:v2"},
+			limit:       &limit,
+			want:        map[int64]string{2: "book:v2", 3: "book:v3"},
+			wantCurrent: 3,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			Evan := newRevisionEvan(tt.image)
+			Evan.Spec.RevisionHistoryLimit = tt.limit
+			var revisions []*appsv1.ControllerRevision
+			for i, image := range tt.revisions {
+				revisions = append(revisions, newTestRevision(t, Evan, int64(i+1), image))
+			}
+			c := newRevisionController(t, Evan, revisions...)
+
+			status := &samplev1alpha1.EvanStatus{}
+			if err := c.syncRevisions(context.Background(), Evan, status); err != nil {
+				t.Fatalf("syncRevisions() error = %v", err)
+			}
+			if status.CurrentRevision != tt.wantCurrent {
+				t.Errorf("syncRevisions() current revision = %d, want %d", status.CurrentRevision, tt.wantCurrent)
+			}
+
+			list, err := c.kubeclientset.AppsV1().ControllerRevisions(Evan.Namespace).List(context.Background(), metav1.ListOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := map[int64]string{}
+			for _, revision := range list.Items {
+				var data revisionData
+				if err := json.Unmarshal(revision.Data.Raw, &data); err != nil {
+					t.Fatal(err)
+				}
+				got[revision.Revision] = data.DeploymentConfig.Image
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("syncRevisions() left revisions %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRollback(t *testing.T) {
+	tests := []struct {
+		name        string
+		rollbackTo  *samplev1alpha1.RollbackConfig
+		annotation  string
+		wantUpdated bool
+		wantImage   string
+		wantEvent   string
+	}{
+		{
+			name:        "no rollback requested",
+			wantUpdated: false,
+			wantImage:   "book:v3",
+		},
+		{
+			name:        "rollback to a revision",
+			rollbackTo:  &samplev1alpha1.RollbackConfig{Revision: 1},
+			wantUpdated: true,
+			wantImage:   "book:v1",
+			wantEvent:   "Normal RolledBack Rolled back to revision 1",
+		},
+		{
+			name:        "rollback to the previous revision",
+			annotation:  "0",
+			wantUpdated: true,
+			wantImage:   "book:v2",
+			wantEvent:   "Normal RolledBack Rolled back to revision 2",
+		},
+		{
+			name:        "unknown revision",
+			rollbackTo:  &samplev1alpha1.RollbackConfig{Revision: 7},
+			wantUpdated: true,
+			wantImage:   "book:v3",
+			wantEvent:   "Warning RollbackRevisionNotFound Unable to find revision 7 to roll back to",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			Evan := newRevisionEvan("book:v3")
+			Evan.Spec.RollbackTo = tt.rollbackTo
+			if tt.annotation != "" {
+				Evan.Annotations = map[string]string{rollbackToAnnotation: tt.annotation}
+			}
+			c := newRevisionController(t, Evan,
+				newTestRevision(t, Evan, 1, "book:v1"),
+				newTestRevision(t, Evan, 2, "book:v2"),
+				newTestRevision(t, Evan, 3, "book:v3"),
+			)
+
+			updated, err := c.rollback(context.Background(), Evan)
+			if err != nil {
+				t.Fatalf("rollback() error = %v", err)
+			}
+			if updated != tt.wantUpdated {
+				t.Errorf("rollback() = %v, want %v", updated, tt.wantUpdated)
+			}
+
+			got, err := c.sampleclientset.SamplecontrollerV1alpha1().Evans(Evan.Namespace).Get(context.Background(), Evan.Name, metav1.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Spec.DeploymentConfig.Image != tt.wantImage {
+				t.Errorf("rollback() image = %s, want %s", got.Spec.DeploymentConfig.Image, tt.wantImage)
+			}
+			if got.Spec.RollbackTo != nil || got.Annotations[rollbackToAnnotation] != "" {
+				t.Errorf("rollback() left the request on the Evan")
+			}
+
+			events := c.recorder.(*record.FakeRecorder).Events
+			var event string
+			select {
+			case event = <-events:
+			default:
+			}
+			if event != tt.wantEvent {
+				t.Errorf("rollback() event = %q, want %q", event, tt.wantEvent)
+			}
+		})
+	}
+}
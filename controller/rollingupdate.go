@@ -0,0 +1,74 @@
+package controller
+
+import (
+	samplev1alpha1 "github.com/evanraisul/k8s-sample-controller/pkg/apis/samplecontroller/v1alpha1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+)
+
+// applyDeploymentOptions sets the update strategy and the timing fields of
+// the DeploymentConfig on the Deployment. Unset fields are left to the API
+// server defaults. An exclusive spec.storage claim forces the Recreate
+// strategy.
+func applyDeploymentOptions(Evan *samplev1alpha1.Evan, deployment *appsv1.Deployment) {
+	config := Evan.Spec.DeploymentConfig
+
+	deployment.Spec.Strategy = *config.UpdateStrategy.DeepCopy()
+	if deployment.Spec.Strategy.Type == "" {
+		deployment.Spec.Strategy.Type = appsv1.RollingUpdateDeploymentStrategyType
+	}
+	// A new pod cannot attach an exclusive claim before the old one released
+	// it.
+	if isStorageExclusive(Evan) {
+		deployment.Spec.Strategy.Type = appsv1.RecreateDeploymentStrategyType
+	}
+	// The API server rejects rolling update parameters on a Recreate
+	// Deployment.
+	if deployment.Spec.Strategy.Type == appsv1.RecreateDeploymentStrategyType {
+		deployment.Spec.Strategy.RollingUpdate = nil
+	}
+	deployment.Spec.MinReadySeconds = config.MinReadySeconds
+	deployment.Spec.ProgressDeadlineSeconds = config.ProgressDeadlineSeconds
+	deployment.Spec.RevisionHistoryLimit = config.RevisionHistoryLimit
+	deployment.Spec.Template.Spec.TerminationGracePeriodSeconds = config.TerminationGracePeriodSeconds
+}
+
+// isDeploymentOptionsChanged reports whether the update strategy or a timing
+// field of the Deployment drifted from the desired one. Fields the API server
+// defaults only count when the Evan sets them.
+func isDeploymentOptionsChanged(desired *appsv1.Deployment, deployment *appsv1.Deployment) bool {
+	spec, current := desired.Spec, deployment.Spec
+	if spec.Strategy.Type != current.Strategy.Type {
+		return true
+	}
+	if desiredUpdate := spec.Strategy.RollingUpdate; desiredUpdate != nil {
+		currentUpdate := current.Strategy.RollingUpdate
+		if currentUpdate == nil {
+			return true
+		}
+		if desiredUpdate.MaxSurge != nil && !equality.Semantic.DeepEqual(desiredUpdate.MaxSurge, currentUpdate.MaxSurge) {
+			return true
+		}
+		if desiredUpdate.MaxUnavailable != nil && !equality.Semantic.DeepEqual(desiredUpdate.MaxUnavailable, currentUpdate.MaxUnavailable) {
+			return true
+		}
+	}
+	if spec.MinReadySeconds != current.MinReadySeconds {
+		return true
+	}
+	if spec.ProgressDeadlineSeconds != nil && !equality.Semantic.DeepEqual(spec.ProgressDeadlineSeconds, current.ProgressDeadlineSeconds) {
+		return true
+	}
+	if spec.RevisionHistoryLimit != nil && !equality.Semantic.DeepEqual(spec.RevisionHistoryLimit, current.RevisionHistoryLimit) {
+		return true
+	}
+	return isTerminationGracePeriodChanged(spec.Template.Spec, current.Template.Spec)
+}
+
+// isTerminationGracePeriodChanged reports whether the termination grace
+// period of the pod drifted from the one the Evan sets.
+func isTerminationGracePeriodChanged(evanPodSpec, podSpec corev1.PodSpec) bool {
+	return evanPodSpec.TerminationGracePeriodSeconds != nil &&
+		!equality.Semantic.DeepEqual(evanPodSpec.TerminationGracePeriodSeconds, podSpec.TerminationGracePeriodSeconds)
+}
@@ -0,0 +1,146 @@
+package controller
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"strings"
+
+	samplev1alpha1 "github.com/evanraisul/k8s-sample-controller/pkg/apis/samplecontroller/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// ServiceRecreated is used as part of the Event 'reason' when a Service
+	// is deleted and created again because an immutable field changed
+	ServiceRecreated = "ServiceRecreated"
+
+	// MessageServiceRecreated is the message used for an Event fired when a
+	// Service is deleted and created again because an immutable field changed
+	MessageServiceRecreated = "Service %s recreated because its %s changed"
+)
+
+// applyServiceOptions sets the optional fields of the ServiceConfig on the
+// Service. Fields that the API server only accepts for some Service types are
+// left empty for the others, so that changing the type clears them. The keys
+// of the annotations are recorded on the Service.
+func applyServiceOptions(Evan *samplev1alpha1.Evan, service *corev1.Service) {
+	config := Evan.Spec.ServiceConfig
+
+	if len(config.Annotations) > 0 {
+		service.Annotations = map[string]string{}
+		keys := make([]string, 0, len(config.Annotations))
+		for key, value := range config.Annotations {
+			service.Annotations[key] = value
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		service.Annotations[serviceAnnotationsAnnotation] = strings.Join(keys, ",")
+	}
+
+	if service.Spec.Type == "" {
+		service.Spec.Type = corev1.ServiceTypeClusterIP
+	}
+	if config.Headless {
+		service.Spec.ClusterIP = corev1.ClusterIPNone
+	}
+	external := service.Spec.Type == corev1.ServiceTypeNodePort || service.Spec.Type == corev1.ServiceTypeLoadBalancer
+	if service.Spec.Type == corev1.ServiceTypeLoadBalancer {
+		service.Spec.LoadBalancerSourceRanges = config.LoadBalancerSourceRanges
+	}
+	if external {
+		service.Spec.ExternalTrafficPolicy = corev1.ServiceExternalTrafficPolicyCluster
+		if config.ExternalTrafficPolicy != "" {
+			service.Spec.ExternalTrafficPolicy = config.ExternalTrafficPolicy
+		}
+	}
+	service.Spec.InternalTrafficPolicy = config.InternalTrafficPolicy
+
+	service.Spec.SessionAffinity = corev1.ServiceAffinityNone
+	if config.SessionAffinity != "" {
+		service.Spec.SessionAffinity = config.SessionAffinity
+	}
+	if service.Spec.SessionAffinity == corev1.ServiceAffinityClientIP {
+		service.Spec.SessionAffinityConfig = config.SessionAffinityConfig
+	}
+
+	service.Spec.IPFamilies = config.IPFamilies
+	service.Spec.IPFamilyPolicy = config.IPFamilyPolicy
+	service.Spec.PublishNotReadyAddresses = config.PublishNotReadyAddresses
+}
+
+// isServiceOptionsChanged reports whether an optional field of the Service
+// drifted from the desired one. Fields the API server defaults or allocates
+// only count when the Evan sets them. Annotations removed from the
+// ServiceConfig count too.
+func isServiceOptionsChanged(desired *corev1.Service, service *corev1.Service) bool {
+	if desired.Annotations[serviceAnnotationsAnnotation] != service.Annotations[serviceAnnotationsAnnotation] {
+		return true
+	}
+	for key, value := range desired.Annotations {
+		if current, ok := service.Annotations[key]; !ok || current != value {
+			return true
+		}
+	}
+	spec, current := desired.Spec, service.Spec
+	if spec.Type != current.Type {
+		return true
+	}
+	if len(spec.LoadBalancerSourceRanges) != 0 || len(current.LoadBalancerSourceRanges) != 0 {
+		if !reflect.DeepEqual(spec.LoadBalancerSourceRanges, current.LoadBalancerSourceRanges) {
+			return true
+		}
+	}
+	if spec.ExternalTrafficPolicy != "" && spec.ExternalTrafficPolicy != current.ExternalTrafficPolicy {
+		return true
+	}
+	if spec.InternalTrafficPolicy != nil && !reflect.DeepEqual(spec.InternalTrafficPolicy, current.InternalTrafficPolicy) {
+		return true
+	}
+	if spec.SessionAffinity != current.SessionAffinity {
+		return true
+	}
+	if spec.SessionAffinityConfig != nil && !reflect.DeepEqual(spec.SessionAffinityConfig, current.SessionAffinityConfig) {
+		return true
+	}
+	if spec.IPFamilyPolicy != nil && !reflect.DeepEqual(spec.IPFamilyPolicy, current.IPFamilyPolicy) {
+		return true
+	}
+	if len(spec.IPFamilies) != 0 && !reflect.DeepEqual(spec.IPFamilies, current.IPFamilies) {
+		return true
+	}
+	return spec.PublishNotReadyAddresses != current.PublishNotReadyAddresses
+}
+
+// serviceRecreateReason returns the immutable field that differs between the
+// desired and the live Service, or an empty string if the Service can be
+// updated in place.
+func serviceRecreateReason(desired *corev1.Service, service *corev1.Service) string {
+	if (desired.Spec.ClusterIP == corev1.ClusterIPNone) != (service.Spec.ClusterIP == corev1.ClusterIPNone) {
+		return "clusterIP"
+	}
+	if len(desired.Spec.IPFamilies) != 0 && len(service.Spec.IPFamilies) != 0 &&
+		desired.Spec.IPFamilies[0] != service.Spec.IPFamilies[0] {
+		return "primary IP family"
+	}
+	return ""
+}
+
+// recreateService deletes the live Service and creates the desired one in its
+// place.
+func (c *Controller) recreateService(ctx context.Context, Evan *samplev1alpha1.Evan, desired *corev1.Service, service *corev1.Service, reason string) (*corev1.Service, error) {
+	err := c.deleteService(ctx, Evan, service.Name, metav1.DeleteOptions{
+		Preconditions: &metav1.Preconditions{UID: &service.UID},
+	})
+	if err != nil && !errors.IsNotFound(err) {
+		return nil, err
+	}
+	service, err = c.createService(ctx, Evan, desired)
+	if err != nil {
+		return nil, err
+	}
+	c.recorder.Eventf(Evan, corev1.EventTypeNormal, ServiceRecreated, MessageServiceRecreated, service.Name, reason)
+	return service, nil
+}
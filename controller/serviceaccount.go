@@ -0,0 +1,311 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	samplev1alpha1 "github.com/evanraisul/k8s-sample-controller/pkg/apis/samplecontroller/v1alpha1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// PermissionEscalationDenied is used as part of the Event 'reason' when
+	// an Evan asks for permissions the controller does not hold
+	PermissionEscalationDenied = "PermissionEscalationDenied"
+
+	// MessagePermissionEscalationDenied is the message used for an Event
+	// fired when an Evan asks for permissions the controller does not hold
+	MessagePermissionEscalationDenied = "Refusing to grant %s to ServiceAccount %s: the controller does not hold it"
+)
+
+// serviceAccountName returns the name of the ServiceAccount the pods of the
+// Evan run as, or an empty string for the default one.
+func serviceAccountName(Evan *samplev1alpha1.Evan) string {
+	if Evan.Spec.ServiceAccount == nil {
+		return ""
+	}
+	if Evan.Spec.ServiceAccount.Name != "" {
+		return Evan.Spec.ServiceAccount.Name
+	}
+	return Evan.Name
+}
+
+// isServiceAccountChanged reports whether the pod template runs as a
+// different ServiceAccount, or mounts its token differently, than desired.
+func isServiceAccountChanged(evanPodSpec corev1.PodSpec, podSpec corev1.PodSpec) bool {
+	if evanPodSpec.ServiceAccountName != podSpec.ServiceAccountName {
+		return true
+	}
+	return !equality.Semantic.DeepEqual(evanPodSpec.AutomountServiceAccountToken, podSpec.AutomountServiceAccountToken)
+}
+
+// serviceAccountObjectMeta returns the metadata shared by the ServiceAccount,
+// Role and RoleBinding of an Evan. They are always owned by the Evan.
+func serviceAccountObjectMeta(Evan *samplev1alpha1.Evan) metav1.ObjectMeta {
+	return metav1.ObjectMeta{
+		Name:      serviceAccountName(Evan),
+		Namespace: Evan.Namespace,
+		Labels:    map[string]string{EvanNameLabel: Evan.Name},
+		OwnerReferences: []metav1.OwnerReference{
+			*metav1.NewControllerRef(Evan, samplev1alpha1.SchemeGroupVersion.WithKind("Evan")),
+		},
+	}
+}
+
+// syncServiceAccount creates the ServiceAccount of the Evan and, if rules are
+// given, the Role and RoleBinding granting them. Rules the controller does not
+// hold itself are refused with a warning event. The ones no longer desired,
+// after spec.serviceAccount was removed or renamed, are deleted.
+func (c *Controller) syncServiceAccount(ctx context.Context, Evan *samplev1alpha1.Evan) error {
+	config := Evan.Spec.ServiceAccount
+	if config == nil {
+		return c.deleteStaleServiceAccounts(ctx, Evan, "", "")
+	}
+	name := serviceAccountName(Evan)
+
+	serviceAccount, err := c.serviceAccountsLister.ServiceAccounts(Evan.Namespace).Get(name)
+	if errors.IsNotFound(err) {
+		err = c.createServiceAccount(ctx, Evan, &corev1.ServiceAccount{
+			ObjectMeta: serviceAccountObjectMeta(Evan),
+		})
+		if err != nil {
+			return c.rbacCreationError(Evan, name, err)
+		}
+	} else if err != nil {
+		return err
+	} else if !metav1.IsControlledBy(serviceAccount, Evan) {
+		return c.rbacCreationError(Evan, name, errors.NewAlreadyExists(corev1.Resource("serviceaccounts"), name))
+	}
+
+	if len(config.Rules) == 0 {
+		return c.deleteStaleServiceAccounts(ctx, Evan, name, "")
+	}
+	if err := c.deleteStaleServiceAccounts(ctx, Evan, name, name); err != nil {
+		return err
+	}
+	return c.syncServiceAccountRole(ctx, Evan, name, config.Rules)
+}
+
+// syncServiceAccountRole creates or updates the Role with the given rules and
+// binds it to the ServiceAccount.
+func (c *Controller) syncServiceAccountRole(ctx context.Context, Evan *samplev1alpha1.Evan, name string, rules []rbacv1.PolicyRule) error {
+	logger := klog.FromContext(ctx)
+
+	role, err := c.rolesLister.Roles(Evan.Namespace).Get(name)
+	exists := err == nil
+	if err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	if exists && !metav1.IsControlledBy(role, Evan) {
+		return c.rbacCreationError(Evan, name, errors.NewAlreadyExists(rbacv1.Resource("roles"), name))
+	}
+	if !exists || !equality.Semantic.DeepEqual(role.Rules, rules) {
+		// The rules are only reviewed again once they change or the review
+		// expires, refused ones too.
+		data, err := json.Marshal(rules)
+		if err != nil {
+			return err
+		}
+		spec := revisionHash(data)
+		denied, ok := c.accessReviews.lookup(evanKey(Evan), "role", spec)
+		if !ok {
+			if denied, err = c.deniedPermission(ctx, Evan.Namespace, rules); err != nil {
+				return err
+			}
+			c.accessReviews.record(evanKey(Evan), "role", spec, denied)
+			if denied != "" {
+				c.recorder.Eventf(Evan, corev1.EventTypeWarning, PermissionEscalationDenied, MessagePermissionEscalationDenied, denied, name)
+			}
+		}
+		if denied != "" {
+			c.enqueueEvanAfter(Evan, accessReviewTTL)
+			return nil
+		}
+
+		if !exists {
+			err = c.createRole(ctx, Evan, &rbacv1.Role{
+				ObjectMeta: serviceAccountObjectMeta(Evan),
+				Rules:      rules,
+			})
+			if err != nil {
+				return c.rbacCreationError(Evan, name, err)
+			}
+		} else {
+			logger.V(4).Info("Update Role resource", "role", klog.KObj(role))
+			roleCopy := role.DeepCopy()
+			roleCopy.Rules = rules
+			if _, err := c.kubeclientset.RbacV1().Roles(Evan.Namespace).Update(ctx, roleCopy, metav1.UpdateOptions{}); err != nil {
+				return err
+			}
+		}
+	}
+
+	roleBinding := &rbacv1.RoleBinding{
+		ObjectMeta: serviceAccountObjectMeta(Evan),
+		RoleRef: rbacv1.RoleRef{
+			APIGroup: rbacv1.GroupName,
+			Kind:     "Role",
+			Name:     name,
+		},
+		Subjects: []rbacv1.Subject{
+			{
+				Kind:      rbacv1.ServiceAccountKind,
+				Name:      name,
+				Namespace: Evan.Namespace,
+			},
+		},
+	}
+	current, err := c.roleBindingsLister.RoleBindings(Evan.Namespace).Get(name)
+	if errors.IsNotFound(err) {
+		return c.rbacCreationError(Evan, name, c.createRoleBinding(ctx, Evan, roleBinding))
+	}
+	if err != nil {
+		return err
+	}
+	if !metav1.IsControlledBy(current, Evan) {
+		return c.rbacCreationError(Evan, name, errors.NewAlreadyExists(rbacv1.Resource("rolebindings"), name))
+	}
+	if current.RoleRef != roleBinding.RoleRef {
+		// The role of a binding cannot be changed.
+		if err := c.deleteRoleBinding(ctx, Evan, name); err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+		return c.rbacCreationError(Evan, name, c.createRoleBinding(ctx, Evan, roleBinding))
+	}
+	if !equality.Semantic.DeepEqual(current.Subjects, roleBinding.Subjects) {
+		logger.V(4).Info("Update RoleBinding resource", "roleBinding", klog.KObj(current))
+		bindingCopy := current.DeepCopy()
+		bindingCopy.Subjects = roleBinding.Subjects
+		_, err = c.kubeclientset.RbacV1().RoleBindings(Evan.Namespace).Update(ctx, bindingCopy, metav1.UpdateOptions{})
+		return err
+	}
+	return nil
+}
+
+// deleteStaleServiceAccounts removes the ServiceAccounts, Roles and
+// RoleBindings of the Evan other than the desired ones, such as the ones left
+// behind by renaming or removing spec.serviceAccount or its rules. An empty
+// name keeps none of them.
+func (c *Controller) deleteStaleServiceAccounts(ctx context.Context, Evan *samplev1alpha1.Evan, serviceAccount, role string) error {
+	selector := labels.SelectorFromSet(labels.Set{EvanNameLabel: Evan.Name})
+
+	roleBindings, err := c.roleBindingsLister.RoleBindings(Evan.Namespace).List(selector)
+	if err != nil {
+		return err
+	}
+	for _, roleBinding := range roleBindings {
+		if roleBinding.Name == role || !isEvanChild(Evan, roleBinding) {
+			continue
+		}
+		if err := c.deleteRoleBinding(ctx, Evan, roleBinding.Name); err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+	}
+
+	roles, err := c.rolesLister.Roles(Evan.Namespace).List(selector)
+	if err != nil {
+		return err
+	}
+	for _, current := range roles {
+		if current.Name == role || !isEvanChild(Evan, current) {
+			continue
+		}
+		if err := c.deleteRole(ctx, Evan, current.Name); err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+	}
+
+	serviceAccounts, err := c.serviceAccountsLister.ServiceAccounts(Evan.Namespace).List(selector)
+	if err != nil {
+		return err
+	}
+	for _, current := range serviceAccounts {
+		if current.Name == serviceAccount || !isEvanChild(Evan, current) {
+			continue
+		}
+		if err := c.deleteServiceAccount(ctx, Evan, current.Name); err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// rbacCreationError records a warning event when a ServiceAccount, Role or
+// RoleBinding of the Evan already exists without being controlled by it.
+// Ones created by someone else are not labeled, so they are missing from the
+// listers and only show up as a failed creation.
+func (c *Controller) rbacCreationError(Evan *samplev1alpha1.Evan, name string, err error) error {
+	if !errors.IsAlreadyExists(err) {
+		return err
+	}
+	msg := fmt.Sprintf(MessageResourceExists, name)
+	c.recorder.Event(Evan, corev1.EventTypeWarning, ErrResourceExists, msg)
+	return fmt.Errorf("%s", msg)
+}
+
+// deniedPermission asks the API server, through SelfSubjectAccessReviews,
+// whether the controller itself holds every permission of the rules. It
+// returns the first permission that is not held, or an empty string.
+func (c *Controller) deniedPermission(ctx context.Context, namespace string, rules []rbacv1.PolicyRule) (string, error) {
+	for _, rule := range rules {
+		if len(rule.NonResourceURLs) > 0 {
+			return fmt.Sprintf("non-resource URLs %v", rule.NonResourceURLs), nil
+		}
+		resourceNames := rule.ResourceNames
+		if len(resourceNames) == 0 {
+			resourceNames = []string{""}
+		}
+		for _, verb := range rule.Verbs {
+			for _, group := range rule.APIGroups {
+				for _, resource := range rule.Resources {
+					resource, subresource, _ := strings.Cut(resource, "/")
+					for _, resourceName := range resourceNames {
+						attributes := &authorizationv1.ResourceAttributes{
+							Namespace:   namespace,
+							Verb:        verb,
+							Group:       group,
+							Resource:    resource,
+							Subresource: subresource,
+							Name:        resourceName,
+						}
+						review, err := c.kubeclientset.AuthorizationV1().SelfSubjectAccessReviews().Create(ctx, &authorizationv1.SelfSubjectAccessReview{
+							Spec: authorizationv1.SelfSubjectAccessReviewSpec{ResourceAttributes: attributes},
+						}, metav1.CreateOptions{})
+						if err != nil {
+							return "", err
+						}
+						if !review.Status.Allowed {
+							return describePermission(attributes), nil
+						}
+					}
+				}
+			}
+		}
+	}
+	return "", nil
+}
+
+// describePermission formats a permission for events, e.g. "get on
+// apps/deployments/my-book".
+func describePermission(attributes *authorizationv1.ResourceAttributes) string {
+	resource := attributes.Resource
+	if attributes.Group != "" {
+		resource = attributes.Group + "/" + resource
+	}
+	if attributes.Subresource != "" {
+		resource += "/" + attributes.Subresource
+	}
+	if attributes.Name != "" {
+		resource += "/" + attributes.Name
+	}
+	return attributes.Verb + " on " + resource
+}
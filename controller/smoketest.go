@@ -0,0 +1,320 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	samplev1alpha1 "github.com/evanraisul/k8s-sample-controller/pkg/apis/samplecontroller/v1alpha1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// smokeTestLabel marks the Jobs of smoke tests.
+	smokeTestLabel = "samplecontroller.evan.com/smoke-test"
+
+	defaultSmokeTestAttempts = 3
+	// smokeTestBackoff is the wait before the first retry of a failed smoke
+	// test. It doubles with every further retry.
+	smokeTestBackoff = 10 * time.Second
+	// maxSmokeTestOutput bounds the output kept in the status.
+	maxSmokeTestOutput = 1024
+)
+
+// Reasons of the Ready condition of an Evan.
+const (
+	ReasonRolloutNotComplete = "RolloutNotComplete"
+	ReasonSmokeTestRunning   = "SmokeTestRunning"
+	ReasonSmokeTestRetrying  = "SmokeTestRetrying"
+	ReasonSmokeTestFailed    = "SmokeTestFailed"
+	ReasonSmokeTestSucceeded = "SmokeTestSucceeded"
+	ReasonReady              = "Ready"
+)
+
+const (
+	// SmokeTestSucceeded is used as part of the Event 'reason' when the smoke
+	// test of a revision succeeds
+	SmokeTestSucceeded = "SmokeTestSucceeded"
+	// SmokeTestFailed is used as part of the Event 'reason' when the smoke
+	// test of a revision fails all its attempts
+	SmokeTestFailed = "SmokeTestFailed"
+
+	// MessageSmokeTestSucceeded is the message used for an Event fired when
+	// the smoke test of a revision succeeds
+	MessageSmokeTestSucceeded = "Smoke test of revision %d succeeded"
+	// MessageSmokeTestFailed is the message used for an Event fired when the
+	// smoke test of a revision fails all its attempts
+	MessageSmokeTestFailed = "Smoke test of revision %d failed after %d attempts: %s"
+)
+
+// generateSmokeTestJobName returns the name of the Job of an attempt of the
+// smoke test of a revision.
+func generateSmokeTestJobName(evanName string, revision int64, attempt int32) string {
+	return generateJobName(evanName, fmt.Sprintf("smoke-%d-%d", revision, attempt))
+}
+
+// smokeTestEnv returns the environment that points the smoke test at the
+// first port of the Service.
+func smokeTestEnv(Evan *samplev1alpha1.Evan, serviceName string) []corev1.EnvVar {
+	host := fmt.Sprintf("%s.%s.svc", serviceName, Evan.Namespace)
+	ports := newServicePorts(servicePorts(Evan))
+	if len(ports) == 0 {
+		return nil
+	}
+	url := serviceURL(host, ports[0])
+	if url == "" {
+		url = fmt.Sprintf("http://%s", net.JoinHostPort(host, strconv.Itoa(int(ports[0].Port))))
+	}
+	return []corev1.EnvVar{
+		{Name: "SERVICE_HOST", Value: host},
+		{Name: "SERVICE_PORT", Value: strconv.Itoa(int(ports[0].Port))},
+		{Name: "SERVICE_URL", Value: url},
+	}
+}
+
+// newSmokeTestJob creates the Job of an attempt of the smoke test. A failing
+// container reports the tail of its logs as termination message, which ends
+// up in the status.
+func newSmokeTestJob(Evan *samplev1alpha1.Evan, serviceName string, name string) *batchv1.Job {
+	test := Evan.Spec.SmokeTest
+	backoffLimit := int32(0)
+	job := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: Evan.Namespace,
+			Labels: map[string]string{
+				EvanNameLabel:  Evan.Name,
+				smokeTestLabel: "true",
+			},
+			OwnerReferences: []metav1.OwnerReference{
+				*metav1.NewControllerRef(Evan, samplev1alpha1.SchemeGroupVersion.WithKind("Evan")),
+			},
+		},
+		Spec: batchv1.JobSpec{
+			BackoffLimit:          &backoffLimit,
+			ActiveDeadlineSeconds: test.ActiveDeadlineSeconds,
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: map[string]string{smokeTestLabel: "true"},
+				},
+				Spec: corev1.PodSpec{
+					RestartPolicy:      corev1.RestartPolicyNever,
+					ServiceAccountName: serviceAccountName(Evan),
+					Containers: []corev1.Container{
+						{
+							Name:                     "smoke-test",
+							Image:                    test.Image,
+							Command:                  test.Command,
+							Args:                     test.Args,
+							Env:                      append(smokeTestEnv(Evan, serviceName), test.Env...),
+							TerminationMessagePolicy: corev1.TerminationMessageFallbackToLogsOnError,
+						},
+					},
+				},
+			},
+		},
+	}
+	hardenPodSpec(Evan, &job.Spec.Template.Spec)
+	return job
+}
+
+// isRolloutComplete reports whether the current revision is rolled out and
+// available.
+func isRolloutComplete(status *samplev1alpha1.EvanStatus) bool {
+	progressing := meta.FindStatusCondition(status.Conditions, samplev1alpha1.EvanConditionProgressing)
+	return progressing != nil && progressing.Reason == ReasonRolloutComplete &&
+		meta.IsStatusConditionTrue(status.Conditions, samplev1alpha1.EvanConditionAvailable)
+}
+
+// syncSmokeTest runs the smoke test of the current revision once it is rolled
+// out and sets the Ready condition from its outcome. Failed attempts are
+// retried with an exponential backoff until spec.smokeTest.attempts is used
+// up.
+func (c *Controller) syncSmokeTest(ctx context.Context, Evan *samplev1alpha1.Evan, serviceName string, status *samplev1alpha1.EvanStatus) error {
+	logger := klog.FromContext(ctx)
+
+	if !isRolloutComplete(status) {
+		setCondition(Evan, status, samplev1alpha1.EvanConditionReady, metav1.ConditionFalse, ReasonRolloutNotComplete,
+			fmt.Sprintf("Revision %d is not rolled out yet", status.CurrentRevision))
+		return nil
+	}
+	test := Evan.Spec.SmokeTest
+	if test == nil {
+		status.SmokeTest = nil
+		setCondition(Evan, status, samplev1alpha1.EvanConditionReady, metav1.ConditionTrue, ReasonReady,
+			fmt.Sprintf("Revision %d is rolled out", status.CurrentRevision))
+		return nil
+	}
+
+	if status.SmokeTest == nil || status.SmokeTest.Revision != status.CurrentRevision {
+		status.SmokeTest = &samplev1alpha1.SmokeTestStatus{
+			Revision: status.CurrentRevision,
+			Phase:    samplev1alpha1.HookPhaseRunning,
+		}
+	}
+	smoke := status.SmokeTest
+	if err := c.pruneSmokeTestJobs(ctx, Evan, smoke.Job); err != nil {
+		return err
+	}
+
+	attempts := int32(defaultSmokeTestAttempts)
+	if test.Attempts != nil {
+		attempts = *test.Attempts
+	}
+
+	switch smoke.Phase {
+	case samplev1alpha1.HookPhaseSucceeded:
+		setCondition(Evan, status, samplev1alpha1.EvanConditionReady, metav1.ConditionTrue, ReasonSmokeTestSucceeded,
+			fmt.Sprintf(MessageSmokeTestSucceeded, smoke.Revision))
+		return nil
+	case samplev1alpha1.HookPhaseFailed:
+		setCondition(Evan, status, samplev1alpha1.EvanConditionReady, metav1.ConditionFalse, ReasonSmokeTestFailed,
+			fmt.Sprintf(MessageSmokeTestFailed, smoke.Revision, smoke.Attempts, smoke.Output))
+		return nil
+	}
+
+	var job *batchv1.Job
+	if smoke.Job != "" {
+		var err error
+		job, err = c.jobsLister.Jobs(Evan.Namespace).Get(smoke.Job)
+		if errors.IsNotFound(err) {
+			job, err = nil, nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	if smoke.Job == "" || job == nil || isJobFinished(job) && smoke.NextAttemptAt != nil {
+		if job != nil || smoke.Job == "" {
+			// Start the first attempt, or retry once the backoff has passed.
+			// An attempt whose Job was deleted is run again instead.
+			if smoke.NextAttemptAt != nil {
+				if wait := time.Until(smoke.NextAttemptAt.Time); wait > 0 {
+					c.enqueueEvanAfter(Evan, wait)
+					return nil
+				}
+			}
+			smoke.Attempts++
+			smoke.NextAttemptAt = nil
+			smoke.Job = generateSmokeTestJobName(Evan.Name, smoke.Revision, smoke.Attempts)
+		}
+		logger.V(4).Info("Create smoke test Job", "job", smoke.Job, "attempt", smoke.Attempts)
+		err := c.createJob(ctx, Evan, newSmokeTestJob(Evan, serviceName, smoke.Job))
+		if err != nil && !errors.IsAlreadyExists(err) {
+			return err
+		}
+		setCondition(Evan, status, samplev1alpha1.EvanConditionReady, metav1.ConditionFalse, ReasonSmokeTestRunning,
+			fmt.Sprintf("Smoke test Job %s of revision %d is running, attempt %d of %d", smoke.Job, smoke.Revision, smoke.Attempts, attempts))
+		return nil
+	}
+
+	for _, condition := range job.Status.Conditions {
+		if condition.Status != corev1.ConditionTrue {
+			continue
+		}
+		switch condition.Type {
+		case batchv1.JobComplete:
+			smoke.Phase = samplev1alpha1.HookPhaseSucceeded
+			smoke.Output = ""
+			c.recorder.Eventf(Evan, corev1.EventTypeNormal, SmokeTestSucceeded, MessageSmokeTestSucceeded, smoke.Revision)
+			setCondition(Evan, status, samplev1alpha1.EvanConditionReady, metav1.ConditionTrue, ReasonSmokeTestSucceeded,
+				fmt.Sprintf(MessageSmokeTestSucceeded, smoke.Revision))
+			return nil
+		case batchv1.JobFailed:
+			output, err := c.smokeTestOutput(ctx, job)
+			if err != nil {
+				return err
+			}
+			if output == "" {
+				output = condition.Message
+			}
+			smoke.Output = output
+			if smoke.Attempts >= attempts {
+				smoke.Phase = samplev1alpha1.HookPhaseFailed
+				c.recorder.Eventf(Evan, corev1.EventTypeWarning, SmokeTestFailed, MessageSmokeTestFailed, smoke.Revision, smoke.Attempts, output)
+				setCondition(Evan, status, samplev1alpha1.EvanConditionReady, metav1.ConditionFalse, ReasonSmokeTestFailed,
+					fmt.Sprintf(MessageSmokeTestFailed, smoke.Revision, smoke.Attempts, output))
+				return nil
+			}
+			if smoke.NextAttemptAt == nil {
+				backoff := smokeTestBackoff << (smoke.Attempts - 1)
+				smoke.NextAttemptAt = &metav1.Time{Time: time.Now().Add(backoff)}
+			}
+			c.enqueueEvanAfter(Evan, time.Until(smoke.NextAttemptAt.Time))
+			setCondition(Evan, status, samplev1alpha1.EvanConditionReady, metav1.ConditionFalse, ReasonSmokeTestRetrying,
+				fmt.Sprintf("Attempt %d of %d of the smoke test of revision %d failed, retrying at %s: %s",
+					smoke.Attempts, attempts, smoke.Revision, smoke.NextAttemptAt.UTC().Format(time.RFC3339), output))
+			return nil
+		}
+	}
+
+	setCondition(Evan, status, samplev1alpha1.EvanConditionReady, metav1.ConditionFalse, ReasonSmokeTestRunning,
+		fmt.Sprintf("Smoke test Job %s of revision %d is running, attempt %d of %d", smoke.Job, smoke.Revision, smoke.Attempts, attempts))
+	return nil
+}
+
+// isJobFinished reports whether the Job completed or failed.
+func isJobFinished(job *batchv1.Job) bool {
+	for _, condition := range job.Status.Conditions {
+		if (condition.Type == batchv1.JobComplete || condition.Type == batchv1.JobFailed) && condition.Status == corev1.ConditionTrue {
+			return true
+		}
+	}
+	return false
+}
+
+// smokeTestOutput returns the termination message of the failed smoke test
+// container. The pods of the Job are not cached, so they are listed from the
+// API server, which only happens once per failed attempt.
+func (c *Controller) smokeTestOutput(ctx context.Context, job *batchv1.Job) (string, error) {
+	selector, err := metav1.LabelSelectorAsSelector(job.Spec.Selector)
+	if err != nil {
+		return "", err
+	}
+	pods, err := c.kubeclientset.CoreV1().Pods(job.Namespace).List(ctx, metav1.ListOptions{LabelSelector: selector.String()})
+	if err != nil {
+		return "", err
+	}
+	for _, pod := range pods.Items {
+		for _, containerStatus := range pod.Status.ContainerStatuses {
+			if terminated := containerStatus.State.Terminated; terminated != nil && terminated.ExitCode != 0 {
+				output := terminated.Message
+				if len(output) > maxSmokeTestOutput {
+					output = output[len(output)-maxSmokeTestOutput:]
+				}
+				return output, nil
+			}
+		}
+	}
+	return "", nil
+}
+
+// pruneSmokeTestJobs deletes the smoke test Jobs of the Evan other than the
+// current one.
+func (c *Controller) pruneSmokeTestJobs(ctx context.Context, Evan *samplev1alpha1.Evan, current string) error {
+	selector := labels.SelectorFromSet(labels.Set{EvanNameLabel: Evan.Name, smokeTestLabel: "true"})
+	jobs, err := c.jobsLister.Jobs(Evan.Namespace).List(selector)
+	if err != nil {
+		return err
+	}
+	background := metav1.DeletePropagationBackground
+	for _, job := range jobs {
+		if job.Name == current || !metav1.IsControlledBy(job, Evan) {
+			continue
+		}
+		err := c.deleteJob(ctx, Evan, job.Name, metav1.DeleteOptions{PropagationPolicy: &background})
+		if err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+	}
+	return nil
+}
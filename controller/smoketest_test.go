@@ -0,0 +1,34 @@
+package controller
+
+import (
+	"strings"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+func TestGenerateSmokeTestJobName(t *testing.T) {
+	tests := []struct {
+		name     string
+		evanName string
+		want     string
+	}{
+		{name: "short Evan name", evanName: "my-book", want: "my-book-smoke-12-3"},
+		{name: "long Evan name", evanName: strings.Repeat("a", 60)},
+		{name: "longest Evan name", evanName: strings.Repeat("b", 253)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := generateSmokeTestJobName(tt.evanName, 12, 3)
+			if tt.want != "" && got != tt.want {
+				t.Errorf("generateSmokeTestJobName() = %s, want %s", got, tt.want)
+			}
+			if errs := validation.IsValidLabelValue(got); len(errs) > 0 {
+				t.Errorf("generateSmokeTestJobName() = %s, not a valid job-name label: %v", got, errs)
+			}
+			if !strings.HasSuffix(got, "-smoke-12-3") {
+				t.Errorf("generateSmokeTestJobName() = %s, lost the attempt suffix", got)
+			}
+		})
+	}
+}
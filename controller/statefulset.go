@@ -0,0 +1,359 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+
+	samplev1alpha1 "github.com/evanraisul/k8s-sample-controller/pkg/apis/samplecontroller/v1alpha1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// StatefulSetRecreated is used as part of the Event 'reason' when a
+	// StatefulSet is recreated because an immutable field changed
+	StatefulSetRecreated = "StatefulSetRecreated"
+	// StrategyNotSupported is used as part of the Event 'reason' when the
+	// strategy of an Evan does not apply to its workload kind
+	StrategyNotSupported = "StrategyNotSupported"
+
+	// MessageStatefulSetRecreated is the message used for an Event fired when
+	// a StatefulSet is recreated because an immutable field changed
+	MessageStatefulSetRecreated = "StatefulSet %s recreated because its %s changed, its pods and claims are kept"
+	// MessageStrategyNotSupported is the message used for an Event fired when
+	// the strategy of an Evan does not apply to its workload kind
+	MessageStrategyNotSupported = "Strategy %s is not supported for workload kind %s, using RollingUpdate"
+)
+
+// generateGoverningServiceName returns the name of the headless Service that
+// governs the StatefulSet of an Evan.
+func generateGoverningServiceName(serviceName string) string {
+	return fmt.Sprintf("%s-headless", serviceName)
+}
+
+// newVolumeClaim creates a PersistentVolumeClaim from a VolumeClaim of the
+// Evan.
+func newVolumeClaim(Evan *samplev1alpha1.Evan, claim samplev1alpha1.VolumeClaim, name string) corev1.PersistentVolumeClaim {
+	accessModes := claim.AccessModes
+	if len(accessModes) == 0 {
+		accessModes = []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOnce}
+	}
+	return corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: Evan.Namespace,
+			Labels:    map[string]string{EvanNameLabel: Evan.Name},
+		},
+		Spec: corev1.PersistentVolumeClaimSpec{
+			AccessModes:      accessModes,
+			StorageClassName: claim.StorageClassName,
+			Resources: corev1.VolumeResourceRequirements{
+				Requests: corev1.ResourceList{corev1.ResourceStorage: claim.Size},
+			},
+		},
+	}
+}
+
+// volumeMounts mounts each claim at its mount path.
+func volumeMounts(claims []samplev1alpha1.VolumeClaim) []corev1.VolumeMount {
+	var mounts []corev1.VolumeMount
+	for _, claim := range claims {
+		mounts = append(mounts, corev1.VolumeMount{
+			Name:      claim.Name,
+			MountPath: claim.MountPath,
+		})
+	}
+	return mounts
+}
+
+// newStatefulSet creates the StatefulSet of an Evan. It runs the same pod
+// template as newDeployment, with the volume claim templates mounted. Its
+// claims are deleted with it only for a WipeOut Evan.
+func newStatefulSet(Evan *samplev1alpha1.Evan, name string, serviceName string) *appsv1.StatefulSet {
+	labels := evanLabels()
+	template := newDeployment(Evan, name, labels).Spec.Template
+
+	config := Evan.Spec.StatefulSet
+	if config == nil {
+		config = &samplev1alpha1.StatefulSetConfig{}
+	}
+	var claimTemplates []corev1.PersistentVolumeClaim
+	for _, claim := range config.VolumeClaimTemplates {
+		claimTemplates = append(claimTemplates, newVolumeClaim(Evan, claim, claim.Name))
+	}
+	template.Spec.Containers[0].VolumeMounts = append(template.Spec.Containers[0].VolumeMounts, volumeMounts(config.VolumeClaimTemplates)...)
+
+	podManagementPolicy := config.PodManagementPolicy
+	if podManagementPolicy == "" {
+		podManagementPolicy = appsv1.OrderedReadyPodManagement
+	}
+	updateStrategy := config.UpdateStrategy
+	if updateStrategy.Type == "" {
+		updateStrategy.Type = appsv1.RollingUpdateStatefulSetStrategyType
+	}
+
+	retention := &appsv1.StatefulSetPersistentVolumeClaimRetentionPolicy{
+		WhenDeleted: appsv1.RetainPersistentVolumeClaimRetentionPolicyType,
+		WhenScaled:  appsv1.RetainPersistentVolumeClaimRetentionPolicyType,
+	}
+	if Evan.Spec.DeletionPolicy == "WipeOut" {
+		retention.WhenDeleted = appsv1.DeletePersistentVolumeClaimRetentionPolicyType
+	}
+
+	// The StatefulSet refers back to its Evan, next to the selector labels.
+	statefulSetLabels := evanLabels()
+	statefulSetLabels[EvanNameLabel] = Evan.Name
+
+	return &appsv1.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            name,
+			Namespace:       Evan.Namespace,
+			Labels:          statefulSetLabels,
+			OwnerReferences: ownerReferences(Evan),
+		},
+		Spec: appsv1.StatefulSetSpec{
+			Replicas: Evan.Spec.DeploymentConfig.Replicas,
+			Selector: &metav1.LabelSelector{
+				MatchLabels: labels,
+			},
+			Template:                             template,
+			VolumeClaimTemplates:                 claimTemplates,
+			ServiceName:                          serviceName,
+			PodManagementPolicy:                  podManagementPolicy,
+			UpdateStrategy:                       updateStrategy,
+			PersistentVolumeClaimRetentionPolicy: retention,
+		},
+	}
+}
+
+// newGoverningService creates the headless Service that gives the pods of the
+// StatefulSet their stable network identity.
+func newGoverningService(Evan *samplev1alpha1.Evan, serviceName string) *corev1.Service {
+	ports := newServicePorts(servicePorts(Evan))
+	for i := range ports {
+		ports[i].NodePort = 0
+	}
+	labels := evanLabels()
+	labels[EvanNameLabel] = Evan.Name
+	return &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            serviceName,
+			Namespace:       Evan.Namespace,
+			Labels:          labels,
+			OwnerReferences: ownerReferences(Evan),
+		},
+		Spec: corev1.ServiceSpec{
+			ClusterIP:                corev1.ClusterIPNone,
+			Selector:                 evanLabels(),
+			Ports:                    ports,
+			PublishNotReadyAddresses: true,
+		},
+	}
+}
+
+// statefulSetRecreateReason returns the immutable field that differs between
+// the desired and the live StatefulSet, or an empty string if it can be
+// updated in place.
+func statefulSetRecreateReason(desired *appsv1.StatefulSet, statefulSet *appsv1.StatefulSet) string {
+	if desired.Spec.ServiceName != statefulSet.Spec.ServiceName {
+		return "serviceName"
+	}
+	if desired.Spec.PodManagementPolicy != statefulSet.Spec.PodManagementPolicy {
+		return "podManagementPolicy"
+	}
+	if len(desired.Spec.VolumeClaimTemplates) != len(statefulSet.Spec.VolumeClaimTemplates) {
+		return "volumeClaimTemplates"
+	}
+	for i, claim := range desired.Spec.VolumeClaimTemplates {
+		current := statefulSet.Spec.VolumeClaimTemplates[i]
+		if claim.Name != current.Name ||
+			!equality.Semantic.DeepEqual(claim.Spec.AccessModes, current.Spec.AccessModes) ||
+			!equality.Semantic.DeepEqual(claim.Spec.Resources.Requests, current.Spec.Resources.Requests) ||
+			(claim.Spec.StorageClassName != nil && !equality.Semantic.DeepEqual(claim.Spec.StorageClassName, current.Spec.StorageClassName)) {
+			return "volumeClaimTemplates"
+		}
+	}
+	return ""
+}
+
+// isStatefulSetUpToDate reports whether the StatefulSet already runs the
+// replicas and pod template of the Evan spec.
+func isStatefulSetUpToDate(Evan *samplev1alpha1.Evan, statefulSet *appsv1.StatefulSet) bool {
+	desired := newStatefulSet(Evan, statefulSet.Name, statefulSet.Spec.ServiceName)
+	return !isStatefulSetChanged(desired, statefulSet)
+}
+
+// isStatefulSetChanged compares the mutable fields of the StatefulSet with
+// the desired ones.
+func isStatefulSetChanged(desired *appsv1.StatefulSet, statefulSet *appsv1.StatefulSet) bool {
+	if desired.Spec.Replicas != nil && statefulSet.Spec.Replicas != nil &&
+		isReplicasChanged(*desired.Spec.Replicas, *statefulSet.Spec.Replicas) {
+		return true
+	}
+	desiredPod, pod := desired.Spec.Template.Spec, statefulSet.Spec.Template.Spec
+	if isDeploymentImageChanged(desiredPod.Containers[0].Image, pod.Containers[0].Image) ||
+		isContainerPortsChanged(desiredPod.Containers[0].Ports, pod.Containers[0].Ports) ||
+		isServiceAccountChanged(desiredPod, pod) ||
+		isVolumesChanged(desiredPod, pod) ||
+		isTerminationGracePeriodChanged(desiredPod, pod) ||
+		isPodHardeningChanged(desiredPod, pod) {
+		return true
+	}
+	if isPodEvanLabelChanged(desired.Spec.Template.Labels[EvanNameLabel], statefulSet.Spec.Template.Labels[EvanNameLabel]) ||
+		isPodEvanLabelChanged(desired.Labels[EvanNameLabel], statefulSet.Labels[EvanNameLabel]) {
+		return true
+	}
+	if isPropagationChanged(desired.Spec.Template.ObjectMeta, statefulSet.Spec.Template.ObjectMeta) {
+		return true
+	}
+	if desired.Spec.UpdateStrategy.Type != statefulSet.Spec.UpdateStrategy.Type {
+		return true
+	}
+	if desired.Spec.UpdateStrategy.RollingUpdate != nil &&
+		!equality.Semantic.DeepEqual(desired.Spec.UpdateStrategy.RollingUpdate, statefulSet.Spec.UpdateStrategy.RollingUpdate) {
+		return true
+	}
+	// The retention policy is dropped by API servers that do not support it.
+	return statefulSet.Spec.PersistentVolumeClaimRetentionPolicy != nil &&
+		!equality.Semantic.DeepEqual(desired.Spec.PersistentVolumeClaimRetentionPolicy, statefulSet.Spec.PersistentVolumeClaimRetentionPolicy)
+}
+
+// isStatefulSetRolledOut reports whether every replica of the StatefulSet
+// runs the current revision and is available.
+func isStatefulSetRolledOut(statefulSet *appsv1.StatefulSet) bool {
+	replicas := int32(1)
+	if statefulSet.Spec.Replicas != nil {
+		replicas = *statefulSet.Spec.Replicas
+	}
+	return statefulSet.Status.ObservedGeneration >= statefulSet.Generation &&
+		statefulSet.Status.UpdatedReplicas == replicas &&
+		statefulSet.Status.AvailableReplicas == replicas &&
+		statefulSet.Status.CurrentRevision == statefulSet.Status.UpdateRevision
+}
+
+// syncStatefulSet reconciles the StatefulSet of an Evan and its governing
+// Service. The Deployments the Evan ran before switching to a StatefulSet,
+// under any strategy, are removed.
+func (c *Controller) syncStatefulSet(ctx context.Context, Evan *samplev1alpha1.Evan, name string, serviceName string) (*appsv1.StatefulSet, error) {
+	logger := klog.FromContext(ctx)
+
+	if Evan.Spec.Strategy != "" && Evan.Spec.Strategy != samplev1alpha1.RollingUpdateStrategyType {
+		c.recorder.Eventf(Evan, corev1.EventTypeWarning, StrategyNotSupported, MessageStrategyNotSupported, Evan.Spec.Strategy, Evan.Spec.WorkloadKind)
+	}
+
+	governingServiceName := generateGoverningServiceName(serviceName)
+	if err := c.syncGoverningService(ctx, Evan, governingServiceName); err != nil {
+		return nil, err
+	}
+
+	// The Deployments of every strategy share the app label with the
+	// StatefulSet pods, so they would keep receiving traffic.
+	for _, deploymentName := range []string{
+		name,
+		generateColorDeploymentName(name, colorBlue),
+		generateColorDeploymentName(name, colorGreen),
+		generateCanaryDeploymentName(name),
+	} {
+		if err := c.deleteChildDeployment(ctx, Evan, deploymentName); err != nil {
+			return nil, err
+		}
+	}
+	if err := c.deleteChildService(ctx, Evan, generatePreviewServiceName(serviceName)); err != nil {
+		return nil, err
+	}
+
+	desired := newStatefulSet(Evan, name, governingServiceName)
+	statefulSet, err := c.statefulSetsLister.StatefulSets(Evan.Namespace).Get(name)
+	if errors.IsNotFound(err) {
+		return c.createStatefulSet(ctx, Evan, desired)
+	}
+	if err != nil {
+		return nil, err
+	}
+	statefulSet, err = c.claimStatefulSet(ctx, Evan, statefulSet, evanLabels())
+	if err != nil {
+		return nil, err
+	}
+
+	if reason := statefulSetRecreateReason(desired, statefulSet); reason != "" {
+		// Orphan the pods and claims, the new StatefulSet adopts them.
+		logger.V(4).Info("Recreate StatefulSet resource", "statefulSet", klog.KObj(statefulSet), "field", reason)
+		orphan := metav1.DeletePropagationOrphan
+		err := c.removeStatefulSet(ctx, Evan, name, metav1.DeleteOptions{
+			Preconditions:     &metav1.Preconditions{UID: &statefulSet.UID},
+			PropagationPolicy: &orphan,
+		})
+		if err != nil && !errors.IsNotFound(err) {
+			return nil, err
+		}
+		statefulSet, err = c.createStatefulSet(ctx, Evan, desired)
+		if err != nil {
+			return nil, err
+		}
+		c.recorder.Eventf(Evan, corev1.EventTypeNormal, StatefulSetRecreated, MessageStatefulSetRecreated, name, reason)
+		return statefulSet, nil
+	}
+
+	if isStatefulSetChanged(desired, statefulSet) {
+		logger.V(4).Info("Update StatefulSet resource", "statefulSet", klog.KObj(statefulSet))
+		statefulSetCopy := statefulSet.DeepCopy()
+		if statefulSetCopy.Labels == nil {
+			statefulSetCopy.Labels = map[string]string{}
+		}
+		statefulSetCopy.Labels[EvanNameLabel] = desired.Labels[EvanNameLabel]
+		statefulSetCopy.Spec.Replicas = desired.Spec.Replicas
+		statefulSetCopy.Spec.Template = desired.Spec.Template
+		keepForeignMetadata(&statefulSetCopy.Spec.Template.ObjectMeta, statefulSet.Spec.Template.ObjectMeta)
+		statefulSetCopy.Spec.UpdateStrategy = desired.Spec.UpdateStrategy
+		statefulSetCopy.Spec.PersistentVolumeClaimRetentionPolicy = desired.Spec.PersistentVolumeClaimRetentionPolicy
+		return c.kubeclientset.AppsV1().StatefulSets(Evan.Namespace).Update(ctx, statefulSetCopy, metav1.UpdateOptions{})
+	}
+	return statefulSet, nil
+}
+
+// syncGoverningService creates or updates the headless governing Service of
+// the StatefulSet.
+func (c *Controller) syncGoverningService(ctx context.Context, Evan *samplev1alpha1.Evan, serviceName string) error {
+	desired := newGoverningService(Evan, serviceName)
+	service, err := c.serviceLister.Services(Evan.Namespace).Get(serviceName)
+	if errors.IsNotFound(err) {
+		_, err = c.createService(ctx, Evan, desired)
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	service, err = c.claimService(ctx, Evan, service, evanLabels())
+	if err != nil {
+		return err
+	}
+	if service.Spec.ClusterIP != corev1.ClusterIPNone {
+		_, err := c.recreateService(ctx, Evan, desired, service, "clusterIP")
+		return err
+	}
+	if !isServicePortsChanged(desired.Spec.Ports, service.Spec.Ports) && !isServiceSelectorChanged(desired.Spec.Selector, service.Spec.Selector) {
+		return nil
+	}
+	serviceCopy := service.DeepCopy()
+	serviceCopy.Spec.Ports = desired.Spec.Ports
+	serviceCopy.Spec.Selector = desired.Spec.Selector
+	_, err = c.kubeclientset.CoreV1().Services(Evan.Namespace).Update(ctx, serviceCopy, metav1.UpdateOptions{})
+	return err
+}
+
+// deleteStatefulSet removes the StatefulSet and governing Service an Evan ran
+// before switching back to Deployments.
+func (c *Controller) deleteStatefulSet(ctx context.Context, Evan *samplev1alpha1.Evan, name string, serviceName string) error {
+	statefulSet, err := c.statefulSetsLister.StatefulSets(Evan.Namespace).Get(name)
+	if err == nil && isEvanChild(Evan, statefulSet) {
+		err = c.removeStatefulSet(ctx, Evan, name, *metav1.NewPreconditionDeleteOptions(string(statefulSet.UID)))
+	}
+	if err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	return c.deleteChildService(ctx, Evan, generateGoverningServiceName(serviceName))
+}
@@ -0,0 +1,163 @@
+package controller
+
+import (
+	"context"
+	"testing"
+
+	samplev1alpha1 "github.com/evanraisul/k8s-sample-controller/pkg/apis/samplecontroller/v1alpha1"
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	appslisters "k8s.io/client-go/listers/apps/v1"
+	corev1lister "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestSyncStatefulSet(t *testing.T) {
+	newEvan := func(replicas int32, claims ...string) *samplev1alpha1.Evan {
+		config := &samplev1alpha1.StatefulSetConfig{}
+		for _, claim := range claims {
+			config.VolumeClaimTemplates = append(config.VolumeClaimTemplates, samplev1alpha1.VolumeClaim{
+				Name:      claim,
+				MountPath: "/var/lib/" + claim,
+				Size:      resource.MustParse("1Gi"),
+			})
+		}
+		return &samplev1alpha1.Evan{
+			ObjectMeta: metav1.ObjectMeta{Name: "my-book", Namespace: "default", UID: "uid-my-book"},
+			Spec: samplev1alpha1.EvanSpec{
+				DeletionPolicy:   "WipeOut",
+				WorkloadKind:     samplev1alpha1.StatefulSetWorkloadKind,
+				DeploymentConfig: samplev1alpha1.DeploymentConfig{Image: "book:v1", Replicas: &replicas},
+				ServiceConfig:    samplev1alpha1.ServiceConfig{Port: 80},
+				StatefulSet:      config,
+			},
+		}
+	}
+	foreign := &appsv1.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{Name: "my-book", Namespace: "default"},
+	}
+
+	tests := []struct {
+		name string
+		Evan *samplev1alpha1.Evan
+		// previous is the Evan the existing StatefulSet was generated for.
+		previous *samplev1alpha1.Evan
+		existing []runtime.Object
+		wantErr  bool
+		// wantReplicas and wantClaims describe the stored StatefulSet.
+		wantReplicas int32
+		wantClaims   int
+		wantEvent    string
+	}{
+		{
+			name:         "created with its claim templates",
+			Evan:         newEvan(2, "data"),
+			wantReplicas: 2,
+			wantClaims:   1,
+		},
+		{
+			name:         "drifted replicas are updated in place",
+			Evan:         newEvan(3, "data"),
+			previous:     newEvan(2, "data"),
+			wantReplicas: 3,
+			wantClaims:   1,
+		},
+		{
+			name:         "changed claim templates recreate the StatefulSet",
+			Evan:         newEvan(2, "data", "logs"),
+			previous:     newEvan(2, "data"),
+			wantReplicas: 2,
+			wantClaims:   2,
+			wantEvent:    "Normal StatefulSetRecreated StatefulSet my-book recreated because its volumeClaimTemplates changed, its pods and claims are kept",
+		},
+		{
+			name: "replaced Deployment is removed",
+			Evan: newEvan(1),
+			existing: []runtime.Object{
+				newDeployment(newEvan(1), "my-book", evanLabels()),
+			},
+			wantReplicas: 1,
+		},
+		{
+			name:     "foreign StatefulSet of a WipeOut Evan is refused",
+			Evan:     newEvan(1),
+			existing: []runtime.Object{foreign},
+			wantErr:  true,
+			// The foreign StatefulSet is left as it is.
+			wantReplicas: 0,
+			wantEvent:    "Warning ErrResourceExists Resource \"my-book\" already exists and is not managed by Evan",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			objects := tt.existing
+			if tt.previous != nil {
+				objects = append(objects, newStatefulSet(tt.previous, "my-book", "my-book-service-headless"))
+			}
+			var deployments, statefulSets []runtime.Object
+			for _, object := range objects {
+				switch object.(type) {
+				case *appsv1.Deployment:
+					deployments = append(deployments, object)
+				case *appsv1.StatefulSet:
+					statefulSets = append(statefulSets, object)
+				}
+			}
+			c := &Controller{
+				kubeclientset:      fake.NewSimpleClientset(objects...),
+				deploymentsLister:  appslisters.NewDeploymentLister(newTestIndexer(t, deployments...)),
+				statefulSetsLister: appslisters.NewStatefulSetLister(newTestIndexer(t, statefulSets...)),
+				serviceLister:      corev1lister.NewServiceLister(newTestIndexer(t)),
+				recorder:           record.NewFakeRecorder(10),
+				expectations:       newControllerExpectations(),
+			}
+
+			_, err := c.syncStatefulSet(context.Background(), tt.Evan, "my-book", "my-book-service")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("syncStatefulSet() error = %v, want error: %v", err, tt.wantErr)
+			}
+
+			statefulSet, err := c.kubeclientset.AppsV1().StatefulSets("default").Get(context.Background(), "my-book", metav1.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var replicas int32
+			if statefulSet.Spec.Replicas != nil {
+				replicas = *statefulSet.Spec.Replicas
+			}
+			if replicas != tt.wantReplicas {
+				t.Errorf("syncStatefulSet() replicas = %d, want %d", replicas, tt.wantReplicas)
+			}
+			if len(statefulSet.Spec.VolumeClaimTemplates) != tt.wantClaims {
+				t.Errorf("syncStatefulSet() claim templates = %d, want %d", len(statefulSet.Spec.VolumeClaimTemplates), tt.wantClaims)
+			}
+
+			if !tt.wantErr {
+				service, err := c.kubeclientset.CoreV1().Services("default").Get(context.Background(), "my-book-service-headless", metav1.GetOptions{})
+				if err != nil {
+					t.Fatalf("syncStatefulSet() left no governing Service: %v", err)
+				}
+				if service.Spec.ClusterIP != "None" {
+					t.Errorf("syncStatefulSet() governing Service clusterIP = %q, want None", service.Spec.ClusterIP)
+				}
+				_, err = c.kubeclientset.AppsV1().Deployments("default").Get(context.Background(), "my-book", metav1.GetOptions{})
+				if !errors.IsNotFound(err) {
+					t.Errorf("syncStatefulSet() left the Deployment, error = %v", err)
+				}
+			}
+
+			var event string
+			select {
+			case event = <-c.recorder.(*record.FakeRecorder).Events:
+			default:
+			}
+			if event != tt.wantEvent {
+				t.Errorf("syncStatefulSet() event = %q, want %q", event, tt.wantEvent)
+			}
+		})
+	}
+}
@@ -0,0 +1,224 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+
+	samplev1alpha1 "github.com/evanraisul/k8s-sample-controller/pkg/apis/samplecontroller/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+)
+
+// storageVolumeName is the name of the pod volume backed by spec.storage.
+const storageVolumeName = "data"
+
+// Reasons of the StorageBound condition of an Evan.
+const (
+	ReasonClaimBound     = "ClaimBound"
+	ReasonClaimPending   = "ClaimPending"
+	ReasonClaimLost      = "ClaimLost"
+	ReasonClaimResizing  = "ClaimResizing"
+	ReasonClaimExclusive = "ClaimExclusive"
+)
+
+const (
+	// StorageShrinkNotSupported is used as part of the Event 'reason' when
+	// the size of spec.storage is below the size of its claim
+	StorageShrinkNotSupported = "StorageShrinkNotSupported"
+
+	// MessageStorageShrinkNotSupported is the message used for an Event fired
+	// when the size of spec.storage is below the size of its claim
+	MessageStorageShrinkNotSupported = "Cannot shrink PersistentVolumeClaim %s from %s to %s"
+)
+
+// generateStorageClaimName returns the name of the PersistentVolumeClaim of
+// spec.storage.
+func generateStorageClaimName(evanName string) string {
+	return fmt.Sprintf("%s-%s", evanName, storageVolumeName)
+}
+
+// storageVolumeClaim describes spec.storage as a VolumeClaim.
+func storageVolumeClaim(storage *samplev1alpha1.StorageConfig) samplev1alpha1.VolumeClaim {
+	return samplev1alpha1.VolumeClaim{
+		Name:             storageVolumeName,
+		MountPath:        storage.MountPath,
+		Size:             storage.Size,
+		StorageClassName: storage.StorageClassName,
+		AccessModes:      storage.AccessModes,
+	}
+}
+
+// storageVolumes returns the pod volume and container mount of spec.storage,
+// if it is set.
+func storageVolumes(Evan *samplev1alpha1.Evan) ([]corev1.Volume, []corev1.VolumeMount) {
+	if Evan.Spec.Storage == nil {
+		return nil, nil
+	}
+	volumes := []corev1.Volume{
+		{
+			Name: storageVolumeName,
+			VolumeSource: corev1.VolumeSource{
+				PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{
+					ClaimName: generateStorageClaimName(Evan.Name),
+				},
+			},
+		},
+	}
+	return volumes, volumeMounts([]samplev1alpha1.VolumeClaim{storageVolumeClaim(Evan.Spec.Storage)})
+}
+
+// isStorageExclusive reports whether spec.storage is set and its claim can
+// only be mounted by the pods of a single node at a time.
+func isStorageExclusive(Evan *samplev1alpha1.Evan) bool {
+	if Evan.Spec.Storage == nil {
+		return false
+	}
+	for _, mode := range Evan.Spec.Storage.AccessModes {
+		if mode == corev1.ReadWriteMany {
+			return false
+		}
+	}
+	return true
+}
+
+// storageConflict returns why the pods of the Evan cannot share its exclusive
+// claim, or an empty string when they can. More than one replica, or the
+// second Deployment of a BlueGreen or Canary rollout, would be stuck with a
+// Multi-Attach error.
+func storageConflict(Evan *samplev1alpha1.Evan) string {
+	if !isStorageExclusive(Evan) {
+		return ""
+	}
+	if replicas := Evan.Spec.DeploymentConfig.Replicas; replicas != nil && *replicas > 1 {
+		return fmt.Sprintf("%d replicas cannot share it", *replicas)
+	}
+	if Evan.Spec.WorkloadKind == samplev1alpha1.StatefulSetWorkloadKind {
+		return ""
+	}
+	switch Evan.Spec.Strategy {
+	case samplev1alpha1.BlueGreenStrategyType, samplev1alpha1.CanaryStrategyType:
+		return fmt.Sprintf("the Deployments of the %s strategy cannot share it", Evan.Spec.Strategy)
+	}
+	return ""
+}
+
+// handleVolumeClaim enqueues the Evan a PersistentVolumeClaim belongs to, so
+// the StorageBound condition follows its phase.
+func (c *Controller) handleVolumeClaim(obj interface{}) {
+	claim, ok := obj.(*corev1.PersistentVolumeClaim)
+	if !ok {
+		tombstone, ok := obj.(cache.DeletedFinalStateUnknown)
+		if !ok {
+			utilruntime.HandleError(fmt.Errorf("error decoding object, invalid type"))
+			return
+		}
+		claim, ok = tombstone.Obj.(*corev1.PersistentVolumeClaim)
+		if !ok {
+			utilruntime.HandleError(fmt.Errorf("error decoding object tombstone, invalid type"))
+			return
+		}
+	}
+	name, ok := claim.Labels[EvanNameLabel]
+	if !ok {
+		return
+	}
+	Evan, err := c.evansLister.Evans(claim.Namespace).Get(name)
+	if err != nil {
+		klog.FromContext(context.Background()).V(4).Info("Ignore claim of unknown Evan", "persistentVolumeClaim", klog.KObj(claim), "Evan", name)
+		return
+	}
+	c.enqueueEvan(Evan)
+}
+
+// syncStorage creates the PersistentVolumeClaim of spec.storage, expands it
+// when the requested size grew and reports its phase in the StorageBound
+// condition, which is False when the pods of the Evan cannot share an
+// exclusive claim. The claim of a WipeOut Evan is owned by it and deleted when
+// spec.storage is removed; the one of a Delete Evan is kept.
+func (c *Controller) syncStorage(ctx context.Context, Evan *samplev1alpha1.Evan, status *samplev1alpha1.EvanStatus) error {
+	logger := klog.FromContext(ctx)
+	name := generateStorageClaimName(Evan.Name)
+
+	claim, err := c.volumeClaimsLister.PersistentVolumeClaims(Evan.Namespace).Get(name)
+	if err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+
+	if Evan.Spec.Storage == nil {
+		meta.RemoveStatusCondition(&status.Conditions, samplev1alpha1.EvanConditionStorageBound)
+		if claim == nil || !metav1.IsControlledBy(claim, Evan) {
+			return nil
+		}
+		logger.V(4).Info("Delete PersistentVolumeClaim resource", "persistentVolumeClaim", klog.KObj(claim))
+		err := c.deleteVolumeClaim(ctx, Evan, name)
+		if errors.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+
+	if claim == nil {
+		desired := newVolumeClaim(Evan, storageVolumeClaim(Evan.Spec.Storage), name)
+		desired.OwnerReferences = ownerReferences(Evan)
+		claim, err = c.createVolumeClaim(ctx, Evan, &desired)
+		if err != nil {
+			return err
+		}
+	} else if err := c.checkControlledBy(Evan, claim); err != nil {
+		return err
+	}
+
+	requested := Evan.Spec.Storage.Size
+	current := claim.Spec.Resources.Requests[corev1.ResourceStorage]
+	switch requested.Cmp(current) {
+	case 1:
+		logger.V(4).Info("Expand PersistentVolumeClaim resource", "persistentVolumeClaim", klog.KObj(claim), "currentSize", current.String(), "desiredSize", requested.String())
+		claimCopy := claim.DeepCopy()
+		claimCopy.Spec.Resources.Requests[corev1.ResourceStorage] = requested
+		claim, err = c.kubeclientset.CoreV1().PersistentVolumeClaims(Evan.Namespace).Update(ctx, claimCopy, metav1.UpdateOptions{})
+		if err != nil {
+			return err
+		}
+	case -1:
+		c.recorder.Eventf(Evan, corev1.EventTypeWarning, StorageShrinkNotSupported, MessageStorageShrinkNotSupported, name, current.String(), requested.String())
+	}
+
+	switch claim.Status.Phase {
+	case corev1.ClaimBound:
+		capacity := claim.Status.Capacity[corev1.ResourceStorage]
+		requested := claim.Spec.Resources.Requests[corev1.ResourceStorage]
+		if capacity.Cmp(requested) < 0 {
+			setCondition(Evan, status, samplev1alpha1.EvanConditionStorageBound, metav1.ConditionTrue, ReasonClaimResizing,
+				fmt.Sprintf("PersistentVolumeClaim %s is being expanded from %s to %s", name, capacity.String(), requested.String()))
+		} else {
+			setCondition(Evan, status, samplev1alpha1.EvanConditionStorageBound, metav1.ConditionTrue, ReasonClaimBound,
+				fmt.Sprintf("PersistentVolumeClaim %s is bound to volume %s", name, claim.Spec.VolumeName))
+		}
+	case corev1.ClaimLost:
+		setCondition(Evan, status, samplev1alpha1.EvanConditionStorageBound, metav1.ConditionFalse, ReasonClaimLost,
+			fmt.Sprintf("PersistentVolumeClaim %s lost its volume %s", name, claim.Spec.VolumeName))
+	default:
+		setCondition(Evan, status, samplev1alpha1.EvanConditionStorageBound, metav1.ConditionFalse, ReasonClaimPending,
+			fmt.Sprintf("PersistentVolumeClaim %s is not bound yet", name))
+	}
+	if conflict := storageConflict(Evan); conflict != "" {
+		setCondition(Evan, status, samplev1alpha1.EvanConditionStorageBound, metav1.ConditionFalse, ReasonClaimExclusive,
+			fmt.Sprintf("PersistentVolumeClaim %s is not ReadWriteMany, %s", name, conflict))
+	}
+	return nil
+}
+
+// isVolumesChanged reports whether the volumes of the pod, or the mounts of
+// its container, differ from the desired ones.
+func isVolumesChanged(evanPodSpec corev1.PodSpec, podSpec corev1.PodSpec) bool {
+	if !equality.Semantic.DeepEqual(evanPodSpec.Volumes, podSpec.Volumes) {
+		return true
+	}
+	return !equality.Semantic.DeepEqual(evanPodSpec.Containers[0].VolumeMounts, podSpec.Containers[0].VolumeMounts)
+}
@@ -0,0 +1,164 @@
+package controller
+
+import (
+	"context"
+	"time"
+
+	samplev1alpha1 "github.com/evanraisul/k8s-sample-controller/pkg/apis/samplecontroller/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/selection"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/klog/v2"
+)
+
+// OrphanPolicy describes what the sweeper does with an orphaned child.
+type OrphanPolicy string
+
+const (
+	// OrphanPolicyDelete deletes orphaned children.
+	OrphanPolicyDelete OrphanPolicy = "Delete"
+	// OrphanPolicyReport only records an event for orphaned children.
+	OrphanPolicyReport OrphanPolicy = "Report"
+)
+
+// OrphanSweepConfig configures the sweeper that collects the Deployments,
+// StatefulSets and Services labeled with EvanNameLabel that no current Evan
+// spec references, such as the ones left behind by renaming
+// deploymentConfig.name or by deleting a Delete Evan.
+type OrphanSweepConfig struct {
+	// Interval between two sweeps. The sweeper is disabled when it is zero.
+	Interval time.Duration
+	// Policy is Report unless deleting orphans is opted into.
+	Policy OrphanPolicy
+	// DryRun sends the deletions as server-side dry runs, so they are
+	// validated but not persisted.
+	DryRun bool
+}
+
+const (
+	// OrphanCollected is used as part of the Event 'reason' when the sweeper
+	// deletes an orphaned child
+	OrphanCollected = "OrphanCollected"
+	// OrphanDetected is used as part of the Event 'reason' when the sweeper
+	// finds an orphaned child it does not delete
+	OrphanDetected = "OrphanDetected"
+	// OrphanCollectionFailed is used as part of the Event 'reason' when the
+	// sweeper fails to delete an orphaned child
+	OrphanCollectionFailed = "OrphanCollectionFailed"
+
+	// MessageOrphanCollected is the message used for an Event fired when the
+	// sweeper deletes an orphaned child
+	MessageOrphanCollected = "Deleted %s %s, which no Evan references%s"
+	// MessageOrphanDetected is the message used for an Event fired when the
+	// sweeper finds an orphaned child it does not delete
+	MessageOrphanDetected = "%s %s is not referenced by any Evan"
+	// MessageOrphanCollectionFailed is the message used for an Event fired
+	// when the sweeper fails to delete an orphaned child
+	MessageOrphanCollectionFailed = "Failed to delete %s %s, which no Evan references: %v"
+)
+
+// orphanOf reports whether a labeled child is orphaned, and returns the Evan
+// named by its label, if that Evan still exists. A child controlled by
+// another controller is never an orphan.
+func (c *Controller) orphanOf(object metav1.Object) (*samplev1alpha1.Evan, bool) {
+	ownerRef := metav1.GetControllerOf(object)
+	if ownerRef != nil && ownerRef.Kind != "Evan" {
+		return nil, false
+	}
+	Evan, err := c.evansLister.Evans(object.GetNamespace()).Get(object.GetLabels()[EvanNameLabel])
+	if errors.IsNotFound(err) {
+		return nil, true
+	}
+	if err != nil {
+		utilruntime.HandleError(err)
+		return nil, false
+	}
+	if ownerRef != nil && ownerRef.UID != Evan.UID {
+		// The child belongs to an earlier Evan of the same name.
+		return nil, true
+	}
+	return Evan, !computesChild(Evan, object)
+}
+
+// collectOrphan deletes or reports an orphaned child as the sweep policy
+// says. The event is recorded on the Evan of the child, or on the child when
+// that Evan is gone.
+func (c *Controller) collectOrphan(ctx context.Context, config OrphanSweepConfig, Evan *samplev1alpha1.Evan, kind string, object metav1.Object, deleteFunc func(context.Context, string, metav1.DeleteOptions) error) {
+	logger := klog.FromContext(ctx)
+	var target runtime.Object = Evan
+	if Evan == nil {
+		target = object.(runtime.Object)
+	}
+
+	if config.Policy == OrphanPolicyReport {
+		logger.Info("Found orphaned child", "kind", kind, "object", klog.KObj(object))
+		c.recorder.Eventf(target, corev1.EventTypeWarning, OrphanDetected, MessageOrphanDetected, kind, object.GetName())
+		return
+	}
+
+	options := metav1.NewPreconditionDeleteOptions(string(object.GetUID()))
+	suffix := ""
+	if config.DryRun {
+		options.DryRun = []string{metav1.DryRunAll}
+		suffix = " (dry run)"
+	}
+	logger.Info("Delete orphaned child", "kind", kind, "object", klog.KObj(object), "dryRun", config.DryRun)
+	if err := deleteFunc(ctx, object.GetName(), *options); err != nil {
+		if !errors.IsNotFound(err) && !errors.IsConflict(err) {
+			utilruntime.HandleError(err)
+			c.recorder.Eventf(target, corev1.EventTypeWarning, OrphanCollectionFailed, MessageOrphanCollectionFailed, kind, object.GetName(), err)
+		}
+		return
+	}
+	c.recorder.Eventf(target, corev1.EventTypeNormal, OrphanCollected, MessageOrphanCollected, kind, object.GetName(), suffix)
+}
+
+// sweepOrphans collects the orphaned Deployments, StatefulSets and Services
+// labeled with EvanNameLabel. Unlabeled objects are left alone, so objects
+// the controller did not create are never touched. PersistentVolumeClaims
+// hold data and are never swept.
+func (c *Controller) sweepOrphans(ctx context.Context, config OrphanSweepConfig) {
+	requirement, err := labels.NewRequirement(EvanNameLabel, selection.Exists, nil)
+	if err != nil {
+		utilruntime.HandleError(err)
+		return
+	}
+	selector := labels.NewSelector().Add(*requirement)
+
+	deployments, err := c.deploymentsLister.List(selector)
+	if err != nil {
+		utilruntime.HandleError(err)
+		return
+	}
+	for _, deployment := range deployments {
+		if Evan, orphan := c.orphanOf(deployment); orphan {
+			c.collectOrphan(ctx, config, Evan, "Deployment", deployment, c.kubeclientset.AppsV1().Deployments(deployment.Namespace).Delete)
+		}
+	}
+
+	statefulSets, err := c.statefulSetsLister.List(selector)
+	if err != nil {
+		utilruntime.HandleError(err)
+		return
+	}
+	for _, statefulSet := range statefulSets {
+		if Evan, orphan := c.orphanOf(statefulSet); orphan {
+			c.collectOrphan(ctx, config, Evan, "StatefulSet", statefulSet, c.kubeclientset.AppsV1().StatefulSets(statefulSet.Namespace).Delete)
+		}
+	}
+
+	services, err := c.serviceLister.List(selector)
+	if err != nil {
+		utilruntime.HandleError(err)
+		return
+	}
+	for _, service := range services {
+		if Evan, orphan := c.orphanOf(service); orphan {
+			c.collectOrphan(ctx, config, Evan, "Service", service, c.kubeclientset.CoreV1().Services(service.Namespace).Delete)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package controller
+
+import (
+	"testing"
+	"time"
+
+	samplev1alpha1 "github.com/evanraisul/k8s-sample-controller/pkg/apis/samplecontroller/v1alpha1"
+	listers "github.com/evanraisul/k8s-sample-controller/pkg/generated/listers/samplecontroller/v1alpha1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/cache"
+)
+
+func TestOrphanOf(t *testing.T) {
+	Evan := &samplev1alpha1.Evan{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              "my-book",
+			Namespace:         "default",
+			UID:               "uid-current",
+			CreationTimestamp: metav1.NewTime(time.Unix(1700000000, 0)),
+		},
+	}
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	if err := indexer.Add(Evan); err != nil {
+		t.Fatal(err)
+	}
+	c := &Controller{evansLister: listers.NewEvanLister(indexer)}
+
+	objectMeta := func(name, evanName string, owner *metav1.OwnerReference) metav1.ObjectMeta {
+		objectMeta := metav1.ObjectMeta{
+			Name:      name,
+			Namespace: "default",
+			Labels:    map[string]string{EvanNameLabel: evanName},
+		}
+		if owner != nil {
+			objectMeta.OwnerReferences = []metav1.OwnerReference{*owner}
+		}
+		return objectMeta
+	}
+	controllerRef := func(kind, name string, uid string) *metav1.OwnerReference {
+		isController := true
+		return &metav1.OwnerReference{Kind: kind, Name: name, UID: types.UID(uid), Controller: &isController}
+	}
+
+	tests := []struct {
+		name       string
+		object     metav1.Object
+		wantEvan   bool
+		wantOrphan bool
+	}{
+		{
+			name:       "computed Deployment of a Delete Evan",
+			object:     &appsv1.Deployment{ObjectMeta: objectMeta("my-book-1700000000", "my-book", nil)},
+			wantEvan:   true,
+			wantOrphan: false,
+		},
+		{
+			name:       "computed Deployment of a WipeOut Evan",
+			object:     &appsv1.Deployment{ObjectMeta: objectMeta("my-book-1700000000", "my-book", controllerRef("Evan", "my-book", "uid-current"))},
+			wantEvan:   true,
+			wantOrphan: false,
+		},
+		{
+			name:       "color Deployment of another strategy",
+			object:     &appsv1.Deployment{ObjectMeta: objectMeta("my-book-1700000000-blue", "my-book", nil)},
+			wantEvan:   true,
+			wantOrphan: false,
+		},
+		{
+			name:       "computed Service",
+			object:     &corev1.Service{ObjectMeta: objectMeta("my-book-1700000000", "my-book", nil)},
+			wantEvan:   true,
+			wantOrphan: false,
+		},
+		{
+			name:       "Deployment left behind by a rename",
+			object:     &appsv1.Deployment{ObjectMeta: objectMeta("my-book-old-1700000000", "my-book", nil)},
+			wantEvan:   true,
+			wantOrphan: true,
+		},
+		{
+			name:       "child of a deleted Evan",
+			object:     &appsv1.Deployment{ObjectMeta: objectMeta("gone-1700000000", "gone", nil)},
+			wantEvan:   false,
+			wantOrphan: true,
+		},
+		{
+			name:       "child of an earlier Evan of the same name",
+			object:     &appsv1.Deployment{ObjectMeta: objectMeta("my-book-1600000000", "my-book", controllerRef("Evan", "my-book", "uid-earlier"))},
+			wantEvan:   false,
+			wantOrphan: true,
+		},
+		{
+			name:       "child of another controller",
+			object:     &appsv1.Deployment{ObjectMeta: objectMeta("my-book-old-1700000000", "my-book", controllerRef("ReplicaSet", "other", "uid-other"))},
+			wantEvan:   false,
+			wantOrphan: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotEvan, gotOrphan := c.orphanOf(tt.object)
+			if (gotEvan != nil) != tt.wantEvan {
+				t.Errorf("orphanOf() Evan = %v, want one: %v", gotEvan, tt.wantEvan)
+			}
+			if gotOrphan != tt.wantOrphan {
+				t.Errorf("orphanOf() orphan = %v, want %v", gotOrphan, tt.wantOrphan)
+			}
+		})
+	}
+}
@@ -6,22 +6,19 @@ import (
 	clientset "github.com/evanraisul/k8s-sample-controller/pkg/generated/clientset/versioned"
 	informers "github.com/evanraisul/k8s-sample-controller/pkg/generated/informers/externalversions"
 	"github.com/evanraisul/k8s-sample-controller/pkg/signals"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	kubeinformers "k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/tools/clientcmd"
 	"k8s.io/client-go/util/homedir"
 	"k8s.io/klog/v2"
 	"path/filepath"
+	"strings"
 	"time"
 )
 
 func main() {
 	klog.InitFlags(nil)
-	flag.Parse()
-
-	// set up signals so we handle the shutdown signal gracefully
-	ctx := signals.SetupSignalHandler()
-	logger := klog.FromContext(ctx)
 
 	var kubeconfig *string
 
@@ -30,6 +27,16 @@ func main() {
 	} else {
 		kubeconfig = flag.String("kubeconfig", "", "absolute path to the kubeconfig file")
 	}
+	prometheusAddress := flag.String("prometheus-address", "", "address of the Prometheus-compatible HTTP API used for rollout analysis")
+	prometheusAllowedAddresses := flag.String("prometheus-allowed-addresses", "", "comma-separated Prometheus addresses an Evan may set in spec.analysis.address besides --prometheus-address")
+	orphanSweepInterval := flag.Duration("orphan-sweep-interval", 10*time.Minute, "interval between sweeps for orphaned Deployments, StatefulSets and Services; 0 disables the sweeper")
+	orphanPolicy := flag.String("orphan-policy", string(controller.OrphanPolicyReport), "what the sweeper does with orphaned children: Report or Delete")
+	orphanSweepDryRun := flag.Bool("orphan-sweep-dry-run", false, "send the deletions of the sweeper as server-side dry runs")
+	flag.Parse()
+
+	// set up signals so we handle the shutdown signal gracefully
+	ctx := signals.SetupSignalHandler()
+	logger := klog.FromContext(ctx)
 
 	config, err := clientcmd.BuildConfigFromFlags("", *kubeconfig)
 
@@ -51,19 +58,54 @@ func main() {
 	// 30*time.Second is the re-sync period to update the in-memory cache of informer //
 	kubeInformerFactory := kubeinformers.NewSharedInformerFactory(kubeClient, time.Second*30)
 	exampleInformerFactory := informers.NewSharedInformerFactory(exampleClient, time.Second*30)
+	// Pods, PersistentVolumeClaims, Jobs, ServiceAccounts, Roles and RoleBindings are only watched when they belong to an Evan, so the controller does not cache every pod in the cluster
+	podInformerFactory := kubeinformers.NewSharedInformerFactoryWithOptions(kubeClient, time.Second*30,
+		kubeinformers.WithTweakListOptions(func(options *metav1.ListOptions) {
+			options.LabelSelector = controller.EvanNameLabel
+		}))
 
 	controller := controller.NewController(ctx, kubeClient, exampleClient,
 		kubeInformerFactory.Apps().V1().Deployments(),
+		kubeInformerFactory.Apps().V1().StatefulSets(),
 		kubeInformerFactory.Core().V1().Services(),
-		exampleInformerFactory.Samplecontroller().V1alpha1().Evans())
+		kubeInformerFactory.Apps().V1().ControllerRevisions(),
+		podInformerFactory.Core().V1().Pods(),
+		podInformerFactory.Core().V1().PersistentVolumeClaims(),
+		podInformerFactory.Batch().V1().Jobs(),
+		kubeInformerFactory.Discovery().V1().EndpointSlices(),
+		kubeInformerFactory.Networking().V1().NetworkPolicies(),
+		podInformerFactory.Core().V1().ServiceAccounts(),
+		podInformerFactory.Rbac().V1().Roles(),
+		podInformerFactory.Rbac().V1().RoleBindings(),
+		exampleInformerFactory.Samplecontroller().V1alpha1().Evans(),
+		*prometheusAddress,
+		splitAddresses(*prometheusAllowedAddresses),
+		controller.OrphanSweepConfig{
+			Interval: *orphanSweepInterval,
+			Policy:   controller.OrphanPolicy(*orphanPolicy),
+			DryRun:   *orphanSweepDryRun,
+		})
 
 	// notice that there is no need to run Start methods in a separate goroutine. (i.e. go kubeInformerFactory.Start(ctx.done())
 	// Start method is non-blocking and runs all registered informers in a dedicated goroutine.
 	kubeInformerFactory.Start(ctx.Done())
 	exampleInformerFactory.Start(ctx.Done())
+	podInformerFactory.Start(ctx.Done())
 
 	if err = controller.Run(ctx, 2); err != nil {
 		logger.Error(err, "Error running controller")
 		klog.FlushAndExit(klog.ExitFlushTimeout, 1)
 	}
 }
+
+// splitAddresses splits a comma-separated list of addresses, skipping empty
+// entries.
+func splitAddresses(list string) []string {
+	var addresses []string
+	for _, address := range strings.Split(list, ",") {
+		if address = strings.TrimSpace(address); address != "" {
+			addresses = append(addresses, address)
+		}
+	}
+	return addresses
+}
@@ -0,0 +1,112 @@
+package analysis
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Querier evaluates a query to a single value.
+type Querier interface {
+	Query(ctx context.Context, query string) (float64, error)
+}
+
+// PrometheusClient queries a Prometheus-compatible HTTP API. Anything that
+// serves /api/v1/query, including a local stand-in, can be used as address.
+type PrometheusClient struct {
+	address string
+	client  *http.Client
+}
+
+// NewPrometheusClient returns a PrometheusClient for the HTTP API at address,
+// for example http://prometheus.monitoring.svc:9090.
+func NewPrometheusClient(address string, client *http.Client) *PrometheusClient {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &PrometheusClient{
+		address: strings.TrimSuffix(address, "/"),
+		client:  client,
+	}
+}
+
+type queryResponse struct {
+	Status    string    `json:"status"`
+	ErrorType string    `json:"errorType"`
+	Error     string    `json:"error"`
+	Data      queryData `json:"data"`
+}
+
+type queryData struct {
+	ResultType string          `json:"resultType"`
+	Result     json.RawMessage `json:"result"`
+}
+
+type vectorSample struct {
+	Metric map[string]string `json:"metric"`
+	Value  []interface{}     `json:"value"`
+}
+
+// Query runs an instant query. The query must evaluate to a scalar or to a
+// vector with exactly one sample.
+func (p *PrometheusClient) Query(ctx context.Context, query string) (float64, error) {
+	u, err := url.Parse(p.address + "/api/v1/query")
+	if err != nil {
+		return 0, fmt.Errorf("invalid prometheus address %q: %w", p.address, err)
+	}
+	u.RawQuery = url.Values{"query": []string{query}}.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	var body queryResponse
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return 0, fmt.Errorf("decoding response with status %d: %w", resp.StatusCode, err)
+	}
+	if body.Status != "success" {
+		return 0, fmt.Errorf("query failed: %s: %s", body.ErrorType, body.Error)
+	}
+
+	switch body.Data.ResultType {
+	case "scalar":
+		var sample []interface{}
+		if err := json.Unmarshal(body.Data.Result, &sample); err != nil {
+			return 0, err
+		}
+		return sampleValue(sample)
+	case "vector":
+		var samples []vectorSample
+		if err := json.Unmarshal(body.Data.Result, &samples); err != nil {
+			return 0, err
+		}
+		if len(samples) != 1 {
+			return 0, fmt.Errorf("query returned %d samples, expected 1", len(samples))
+		}
+		return sampleValue(samples[0].Value)
+	default:
+		return 0, fmt.Errorf("unsupported result type %q", body.Data.ResultType)
+	}
+}
+
+// sampleValue returns the value of a [timestamp, "value"] pair.
+func sampleValue(sample []interface{}) (float64, error) {
+	if len(sample) != 2 {
+		return 0, fmt.Errorf("malformed sample %v", sample)
+	}
+	value, ok := sample[1].(string)
+	if !ok {
+		return 0, fmt.Errorf("malformed sample value %v", sample[1])
+	}
+	return strconv.ParseFloat(value, 64)
+}
@@ -0,0 +1,92 @@
+package analysis
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestPrometheusClientQuery(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		want    float64
+		wantErr string
+	}{
+		{
+			name: "scalar",
+			body: `{"status":"success","data":{"resultType":"scalar","result":[1700000000.0,"0.25"]}}`,
+			want: 0.25,
+		},
+		{
+			name: "single sample vector",
+			body: `{"status":"success","data":{"resultType":"vector","result":[{"metric":{"job":"my-book"},"value":[1700000000.0,"42"]}]}}`,
+			want: 42,
+		},
+		{
+			name:    "error payload",
+			body:    `{"status":"error","errorType":"bad_data","error":"parse error at char 4"}`,
+			wantErr: "bad_data: parse error at char 4",
+		},
+		{
+			name:    "empty vector",
+			body:    `{"status":"success","data":{"resultType":"vector","result":[]}}`,
+			wantErr: "returned 0 samples",
+		},
+		{
+			name:    "matrix",
+			body:    `{"status":"success","data":{"resultType":"matrix","result":[]}}`,
+			wantErr: `unsupported result type "matrix"`,
+		},
+		{
+			name:    "malformed value",
+			body:    `{"status":"success","data":{"resultType":"scalar","result":[1700000000.0,"NaN?"]}}`,
+			wantErr: "invalid syntax",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var query string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/api/v1/query" {
+					http.NotFound(w, r)
+					return
+				}
+				query = r.URL.Query().Get("query")
+				w.Header().Set("Content-Type", "application/json")
+				w.Write([]byte(tt.body))
+			}))
+			defer server.Close()
+
+			client := NewPrometheusClient(server.URL+"/", server.Client())
+			got, err := client.Query(context.Background(), `sum(rate(http_requests_total{code=~"5.."}[1m]))`)
+			if query != `sum(rate(http_requests_total{code=~"5.."}[1m]))` {
+				t.Errorf("server received query %q", query)
+			}
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Query() error = %v, want it to contain %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Query() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("Query() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPrometheusClientQueryUnreachable(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	address := server.URL
+	server.Close()
+
+	if _, err := NewPrometheusClient(address, nil).Query(context.Background(), "up"); err == nil {
+		t.Fatal("Query() of a closed server succeeded")
+	}
+}
@@ -17,12 +17,18 @@ limitations under the License.
 package v1alpha1
 
 import (
+	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
 )
 
 // +genclient
 // +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+// +kubebuilder:subresource:status
 // +kubebuilder:printcolumn:name="AvailableReplicas",type="integer",JSONPath=".status.availableReplicas"
 
 // Evan is a specification for a Evan resource
@@ -38,16 +44,106 @@ type DeploymentConfig struct {
 	Name     string `json:"name,omitempty"`
 	Replicas *int32 `json:"replicas,omitempty"`
 	Image    string `json:"image"`
+
+	// UpdateStrategy replaces the pods of the Deployment. Defaults to a
+	// RollingUpdate with 25% maxSurge and maxUnavailable.
+	UpdateStrategy appsv1.DeploymentStrategy `json:"updateStrategy,omitempty"`
+	// +kubebuilder:validation:Minimum=0
+	MinReadySeconds int32 `json:"minReadySeconds,omitempty"`
+	// +kubebuilder:validation:Minimum=1
+	ProgressDeadlineSeconds *int32 `json:"progressDeadlineSeconds,omitempty"`
+	// RevisionHistoryLimit is the number of old ReplicaSets the Deployment
+	// keeps.
+	// +kubebuilder:validation:Minimum=0
+	RevisionHistoryLimit *int32 `json:"revisionHistoryLimit,omitempty"`
+	// +kubebuilder:validation:Minimum=0
+	TerminationGracePeriodSeconds *int64 `json:"terminationGracePeriodSeconds,omitempty"`
+
+	// Size picks the resource requests and limits of the container. Defaults
+	// to small.
+	// +kubebuilder:validation:Enum=small;medium;large
+	Size SizePreset `json:"size,omitempty"`
+	// Resources replace the requests and limits of the Size preset.
+	Resources *corev1.ResourceRequirements `json:"resources,omitempty"`
+	// ProbePath makes the readiness and liveness probes HTTP GETs of the path
+	// on the first TCP port. Without it, they open a TCP connection to the
+	// port.
+	ProbePath string `json:"probePath,omitempty"`
+	// ReadinessProbe and LivenessProbe replace the probes derived from the
+	// first TCP port.
+	ReadinessProbe *corev1.Probe `json:"readinessProbe,omitempty"`
+	LivenessProbe  *corev1.Probe `json:"livenessProbe,omitempty"`
+	// PodSecurityContext and SecurityContext replace the defaults, which
+	// satisfy the restricted Pod Security Standard.
+	PodSecurityContext *corev1.PodSecurityContext `json:"podSecurityContext,omitempty"`
+	SecurityContext    *corev1.SecurityContext    `json:"securityContext,omitempty"`
 }
 
-type ServiceConfig struct {
-	Name       string             `json:"name,omitempty"`
-	Type       corev1.ServiceType `json:"type,omitempty"`
-	Port       int32              `json:"port,omitempty"`
-	TargetPort int32              `json:"targetPort,omitempty"`
+// SizePreset maps to the resource requests and limits of the container.
+type SizePreset string
+
+const (
+	SmallSize  SizePreset = "small"
+	MediumSize SizePreset = "medium"
+	LargeSize  SizePreset = "large"
+)
+
+// ServicePort is a port exposed by the Service and by the container.
+type ServicePort struct {
+	// Name is required when more than one port is given.
+	Name string `json:"name,omitempty"`
+	// Protocol defaults to TCP.
+	// +kubebuilder:validation:Enum=TCP;UDP;SCTP
+	// +default="TCP"
+	Protocol    corev1.Protocol `json:"protocol,omitempty"`
+	AppProtocol *string         `json:"appProtocol,omitempty"`
+	Port        int32           `json:"port"`
+	// TargetPort is the container port number, or the name of the container
+	// port, which then listens on Port. Defaults to Port.
+	TargetPort intstr.IntOrString `json:"targetPort,omitempty"`
 	NodePort   int32              `json:"nodePort,omitempty"`
 }
 
+type ServiceConfig struct {
+	Name string             `json:"name,omitempty"`
+	Type corev1.ServiceType `json:"type,omitempty"`
+	// Port, TargetPort and NodePort configure a single port. They are
+	// ignored when Ports is set.
+	Port       int32 `json:"port,omitempty"`
+	TargetPort int32 `json:"targetPort,omitempty"`
+	NodePort   int32 `json:"nodePort,omitempty"`
+	// +listType=map
+	// +listMapKey=port
+	// +listMapKey=protocol
+	Ports []ServicePort `json:"ports,omitempty"`
+
+	// Annotations are set on the Service, e.g. to configure the cloud load
+	// balancer.
+	Annotations map[string]string `json:"annotations,omitempty"`
+	// Headless creates the Service without a cluster IP. Changing it
+	// recreates the Service.
+	Headless bool `json:"headless,omitempty"`
+	// +listType=atomic
+	LoadBalancerSourceRanges []string `json:"loadBalancerSourceRanges,omitempty"`
+	// ExternalTrafficPolicy only applies to NodePort and LoadBalancer
+	// Services.
+	// +kubebuilder:validation:Enum=Cluster;Local
+	ExternalTrafficPolicy corev1.ServiceExternalTrafficPolicy `json:"externalTrafficPolicy,omitempty"`
+	// +kubebuilder:validation:Enum=Cluster;Local
+	InternalTrafficPolicy *corev1.ServiceInternalTrafficPolicy `json:"internalTrafficPolicy,omitempty"`
+	// +kubebuilder:validation:Enum=None;ClientIP
+	SessionAffinity       corev1.ServiceAffinity        `json:"sessionAffinity,omitempty"`
+	SessionAffinityConfig *corev1.SessionAffinityConfig `json:"sessionAffinityConfig,omitempty"`
+	// IPFamilies lists the IP families of the Service, primary first.
+	// Changing the primary family recreates the Service.
+	// +listType=atomic
+	IPFamilies     []corev1.IPFamily      `json:"ipFamilies,omitempty"`
+	IPFamilyPolicy *corev1.IPFamilyPolicy `json:"ipFamilyPolicy,omitempty"`
+	// PublishNotReadyAddresses publishes the endpoints of pods that are not
+	// ready yet.
+	PublishNotReadyAddresses bool `json:"publishNotReadyAddresses,omitempty"`
+}
+
 type DeletionPolicy string
 
 const (
@@ -55,16 +151,572 @@ const (
 	DeletionPolicyWipeOut DeletionPolicy = "WipeOut"
 )
 
+// AdoptionPolicy describes what an Evan does with an existing Deployment,
+// StatefulSet or Service of the name it computed that it did not create. The
+// Evan created an object it controls or, for a Delete Evan, an object that
+// has no controller and is labeled with the name of the Evan. An adopted
+// object is labeled with the name of the Evan, and a WipeOut Evan also
+// becomes its controller.
+type AdoptionPolicy string
+
+const (
+	// AdoptionPolicyNever leaves the object alone and fails the sync.
+	AdoptionPolicyNever AdoptionPolicy = "Never"
+	// AdoptionPolicyIfUnowned adopts the object when it has no controller
+	// and its labels match.
+	AdoptionPolicyIfUnowned AdoptionPolicy = "IfUnowned"
+	// AdoptionPolicyForce also takes the object over from another
+	// controller.
+	AdoptionPolicyForce AdoptionPolicy = "Force"
+)
+
+// StrategyType describes how a change to the DeploymentConfig is rolled out.
+type StrategyType string
+
+const (
+	// RollingUpdateStrategyType updates the single Deployment in place.
+	RollingUpdateStrategyType StrategyType = "RollingUpdate"
+	// BlueGreenStrategyType rolls the new version out to an idle color and
+	// switches the Service selector once it is fully available.
+	BlueGreenStrategyType StrategyType = "BlueGreen"
+	// CanaryStrategyType runs the new version in a canary Deployment next to
+	// the stable one and shifts replicas over to it step by step.
+	CanaryStrategyType StrategyType = "Canary"
+)
+
+// WorkloadKind is the kind of workload that runs the pods of an Evan.
+type WorkloadKind string
+
+const (
+	// DeploymentWorkloadKind runs the pods in one or more Deployments,
+	// depending on the strategy.
+	DeploymentWorkloadKind WorkloadKind = "Deployment"
+	// StatefulSetWorkloadKind runs the pods in a StatefulSet with a headless
+	// governing Service. Only the RollingUpdate strategy applies to it.
+	StatefulSetWorkloadKind WorkloadKind = "StatefulSet"
+)
+
+// StatefulSetConfig configures the StatefulSet of an Evan.
+type StatefulSetConfig struct {
+	// VolumeClaimTemplates are turned into a PersistentVolumeClaim per pod.
+	// Changing them recreates the StatefulSet, keeping its pods and claims.
+	// +listType=map
+	// +listMapKey=name
+	VolumeClaimTemplates []VolumeClaim `json:"volumeClaimTemplates,omitempty"`
+	// +kubebuilder:validation:Enum=OrderedReady;Parallel
+	PodManagementPolicy appsv1.PodManagementPolicyType   `json:"podManagementPolicy,omitempty"`
+	UpdateStrategy      appsv1.StatefulSetUpdateStrategy `json:"updateStrategy,omitempty"`
+}
+
+// VolumeClaim describes a PersistentVolumeClaim mounted into the container.
+type VolumeClaim struct {
+	Name      string            `json:"name"`
+	MountPath string            `json:"mountPath"`
+	Size      resource.Quantity `json:"size"`
+	// StorageClassName defaults to the default StorageClass of the cluster.
+	StorageClassName *string `json:"storageClassName,omitempty"`
+	// AccessModes default to ReadWriteOnce.
+	// +listType=atomic
+	AccessModes []corev1.PersistentVolumeAccessMode `json:"accessModes,omitempty"`
+}
+
+// StorageConfig configures a PersistentVolumeClaim mounted into the pods of
+// an Evan. All replicas share the claim, so it is meant for single-replica
+// apps. Unless the claim is ReadWriteMany, its Deployment is replaced with
+// the Recreate strategy, and more than one replica or a BlueGreen or Canary
+// rollout sets the StorageBound condition to False.
+type StorageConfig struct {
+	// Size of the claim. Growing it expands the claim, shrinking it is not
+	// supported.
+	Size      resource.Quantity `json:"size"`
+	MountPath string            `json:"mountPath"`
+	// StorageClassName defaults to the default StorageClass of the cluster.
+	StorageClassName *string `json:"storageClassName,omitempty"`
+	// AccessModes default to ReadWriteOnce.
+	// +listType=atomic
+	AccessModes []corev1.PersistentVolumeAccessMode `json:"accessModes,omitempty"`
+}
+
+// Hooks are Jobs run around the rollout of a new image.
+type Hooks struct {
+	// PreRollout runs before the workload is updated to the new image, e.g.
+	// to migrate a database. The rollout waits for it and does not start
+	// when it fails.
+	PreRollout *HookJob `json:"preRollout,omitempty"`
+	// PostRollout runs once the new image is rolled out. The rollout only
+	// completes when it succeeds.
+	PostRollout *HookJob `json:"postRollout,omitempty"`
+}
+
+// HookJob is the template of a hook Job.
+type HookJob struct {
+	// Image defaults to the image being rolled out.
+	Image string `json:"image,omitempty"`
+	// +listType=atomic
+	Command []string `json:"command,omitempty"`
+	// +listType=atomic
+	Args []string `json:"args,omitempty"`
+	// +listType=map
+	// +listMapKey=name
+	Env []corev1.EnvVar `json:"env,omitempty"`
+	// BackoffLimit is the number of retries before the hook fails.
+	// Defaults to 0.
+	BackoffLimit *int32 `json:"backoffLimit,omitempty"`
+	// ActiveDeadlineSeconds fails the hook when it runs longer.
+	ActiveDeadlineSeconds *int64 `json:"activeDeadlineSeconds,omitempty"`
+	// Attempts is the number of times the hook Job is run before the hook
+	// fails, waiting twice as long before each retry. Defaults to 3.
+	// +kubebuilder:validation:Minimum=1
+	Attempts *int32 `json:"attempts,omitempty"`
+}
+
+// EvanReference refers to another Evan.
+type EvanReference struct {
+	Name string `json:"name"`
+	// Namespace defaults to the namespace of the referring Evan.
+	Namespace string `json:"namespace,omitempty"`
+}
+
+// SmokeTest is the template of the smoke test Job. The URL of the Service is
+// passed to it in the SERVICE_URL environment variable.
+type SmokeTest struct {
+	Image string `json:"image"`
+	// +listType=atomic
+	Command []string `json:"command,omitempty"`
+	// +listType=atomic
+	Args []string `json:"args,omitempty"`
+	// +listType=map
+	// +listMapKey=name
+	Env []corev1.EnvVar `json:"env,omitempty"`
+	// Attempts is the number of times the smoke test is run before it
+	// fails, waiting twice as long before each retry. Defaults to 3.
+	// +kubebuilder:validation:Minimum=1
+	Attempts *int32 `json:"attempts,omitempty"`
+	// ActiveDeadlineSeconds fails an attempt that runs longer.
+	ActiveDeadlineSeconds *int64 `json:"activeDeadlineSeconds,omitempty"`
+}
+
+// BlueGreenStrategy configures the BlueGreen rollout strategy.
+type BlueGreenStrategy struct {
+	// PreviewService creates an additional "<service>-preview" Service that
+	// routes to the color being rolled out.
+	PreviewService bool `json:"previewService,omitempty"`
+	// ScaleDownDelaySeconds is how long the previous color is kept running
+	// after the cutover so a rollback is instant. Defaults to 30.
+	ScaleDownDelaySeconds *int32 `json:"scaleDownDelaySeconds,omitempty"`
+}
+
+// CanaryPause halts a Canary rollout.
+type CanaryPause struct {
+	// DurationSeconds is how long the rollout stays paused. Without it the
+	// rollout stays paused until it is promoted or aborted.
+	DurationSeconds *int32 `json:"durationSeconds,omitempty"`
+}
+
+// CanaryStep is a single step of a Canary rollout. Exactly one of its fields
+// should be set.
+type CanaryStep struct {
+	// SetWeight is the percentage of the replicas that run the new version.
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=100
+	SetWeight *int32       `json:"setWeight,omitempty"`
+	Pause     *CanaryPause `json:"pause,omitempty"`
+}
+
+// CanaryStrategy configures the Canary rollout strategy. A rollout can be
+// promoted or aborted at any step through the
+// samplecontroller.evan.com/canary-action annotation.
+type CanaryStrategy struct {
+	Steps []CanaryStep `json:"steps,omitempty"`
+}
+
+// AnalysisOperator compares the result of a query with a threshold.
+type AnalysisOperator string
+
+const (
+	AnalysisOperatorLessThan           AnalysisOperator = "LessThan"
+	AnalysisOperatorLessThanOrEqual    AnalysisOperator = "LessThanOrEqual"
+	AnalysisOperatorGreaterThan        AnalysisOperator = "GreaterThan"
+	AnalysisOperatorGreaterThanOrEqual AnalysisOperator = "GreaterThanOrEqual"
+)
+
+// AnalysisMetric is a PromQL success criterion, for example an error rate
+// that must stay below a threshold.
+type AnalysisMetric struct {
+	Name string `json:"name"`
+	// Query must evaluate to a scalar or to a vector with a single sample.
+	Query string `json:"query"`
+	// +kubebuilder:validation:Enum=LessThan;LessThanOrEqual;GreaterThan;GreaterThanOrEqual
+	Operator AnalysisOperator `json:"operator"`
+	// Threshold is a decimal number such as "0.05".
+	Threshold string `json:"threshold"`
+}
+
+// Analysis evaluates metrics against a Prometheus-compatible query API before
+// a Canary step or a BlueGreen cutover is promoted. A failed analysis aborts
+// the rollout.
+type Analysis struct {
+	// Address of the Prometheus HTTP API. Defaults to the --prometheus-address
+	// of the controller. Any other address must be one of the
+	// --prometheus-allowed-addresses of the controller.
+	Address string `json:"address,omitempty"`
+	// IntervalSeconds is the time between two measurements. Defaults to 30.
+	IntervalSeconds *int32 `json:"intervalSeconds,omitempty"`
+	// Count is the number of successful measurements needed to pass.
+	// Defaults to 1.
+	Count *int32 `json:"count,omitempty"`
+	// FailureLimit is the number of failed measurements tolerated before the
+	// analysis fails. Defaults to 0.
+	FailureLimit *int32 `json:"failureLimit,omitempty"`
+	// InconclusiveLimit is the number of inconclusive measurements tolerated
+	// before the analysis fails. Defaults to 3.
+	InconclusiveLimit *int32           `json:"inconclusiveLimit,omitempty"`
+	Metrics           []AnalysisMetric `json:"metrics"`
+}
+
+// RollbackConfig selects the revision an Evan is rolled back to.
+type RollbackConfig struct {
+	// Revision to roll back to. 0 means the revision before the current one.
+	Revision int64 `json:"revision,omitempty"`
+}
+
 // EvanSpec is the spec for an Evan resource
 type EvanSpec struct {
 	DeploymentConfig DeploymentConfig `json:"deploymentConfig,omitempty"`
 	ServiceConfig    ServiceConfig    `json:"serviceConfig,omitempty"`
 	DeletionPolicy   DeletionPolicy   `json:"deletionPolicy,omitempty"`
+	// AdoptionPolicy defaults to Never.
+	// +kubebuilder:validation:Enum=Never;IfUnowned;Force
+	AdoptionPolicy AdoptionPolicy `json:"adoptionPolicy,omitempty"`
+
+	// +kubebuilder:validation:Enum=Deployment;StatefulSet
+	WorkloadKind WorkloadKind       `json:"workloadKind,omitempty"`
+	StatefulSet  *StatefulSetConfig `json:"statefulSet,omitempty"`
+	// Storage mounts a PersistentVolumeClaim into the pods. It is deleted
+	// with a WipeOut Evan and kept for a Delete one.
+	Storage *StorageConfig `json:"storage,omitempty"`
+
+	// +kubebuilder:validation:Enum=RollingUpdate;BlueGreen;Canary
+	Strategy  StrategyType       `json:"strategy,omitempty"`
+	BlueGreen *BlueGreenStrategy `json:"blueGreen,omitempty"`
+	Canary    *CanaryStrategy    `json:"canary,omitempty"`
+	Analysis  *Analysis          `json:"analysis,omitempty"`
+	Hooks     *Hooks             `json:"hooks,omitempty"`
+	// SmokeTest runs after every rollout against the Service. The Evan is
+	// only Ready once it succeeds.
+	SmokeTest *SmokeTest `json:"smokeTest,omitempty"`
+	// DependsOn lists the Evans that have to be Ready before the children
+	// of this Evan are created or updated. An Evan in another namespace can
+	// only be referenced when the ServiceAccount of the pods of this Evan is
+	// allowed to get it.
+	// +listType=atomic
+	DependsOn []EvanReference `json:"dependsOn,omitempty"`
+
+	// RevisionHistoryLimit is the number of ControllerRevisions kept for
+	// rollback. Defaults to 10.
+	RevisionHistoryLimit *int32 `json:"revisionHistoryLimit,omitempty"`
+	// RollbackTo restores the deploymentConfig and serviceConfig of a previous
+	// revision. It is cleared once the rollback is done.
+	RollbackTo *RollbackConfig `json:"rollbackTo,omitempty"`
+	// RollbackOnFailure rolls back to the last revision that rolled out
+	// successfully when a rollout fails.
+	RollbackOnFailure bool `json:"rollbackOnFailure,omitempty"`
+
+	// NetworkPolicy restricts the traffic of the pods of the Evan. No
+	// NetworkPolicy is created when it is not set.
+	NetworkPolicy *NetworkPolicyConfig `json:"networkPolicy,omitempty"`
+	// ServiceAccount runs the pods of the Evan as a dedicated ServiceAccount
+	// instead of the default one of the namespace.
+	ServiceAccount *ServiceAccountConfig `json:"serviceAccount,omitempty"`
+	// Propagation copies labels and annotations of the Evan to its
+	// Deployment, Service and pod template.
+	Propagation *PropagationConfig `json:"propagation,omitempty"`
+}
+
+// PropagationConfig selects the labels and annotations of the Evan that are
+// copied to its children. An entry matches a key exactly or, when it ends
+// with "*", every key with that prefix.
+type PropagationConfig struct {
+	// +listType=set
+	Labels []string `json:"labels,omitempty"`
+	// +listType=set
+	Annotations []string `json:"annotations,omitempty"`
+}
+
+// ServiceAccountConfig configures the ServiceAccount created for an Evan.
+type ServiceAccountConfig struct {
+	// Name of the ServiceAccount. Defaults to the name of the Evan.
+	Name string `json:"name,omitempty"`
+	// AutomountServiceAccountToken controls whether the token of the
+	// ServiceAccount is mounted into the pods.
+	AutomountServiceAccountToken *bool `json:"automountServiceAccountToken,omitempty"`
+	// Rules are granted to the ServiceAccount in the namespace of the Evan
+	// through a Role and RoleBinding. The controller only grants permissions
+	// it holds itself.
+	// +listType=atomic
+	Rules []rbacv1.PolicyRule `json:"rules,omitempty"`
+}
+
+// NetworkPolicyConfig configures the NetworkPolicy generated for the pods of
+// an Evan. Ingress is only allowed on the ports of the Service.
+type NetworkPolicyConfig struct {
+	// From lists the namespaces and pods allowed to reach the Service ports.
+	// Traffic from anywhere is allowed on those ports when it is empty.
+	// +listType=atomic
+	From []networkingv1.NetworkPolicyPeer `json:"from,omitempty"`
+	// Egress lists the traffic the pods are allowed to send. Egress is not
+	// restricted when it is empty.
+	// +listType=atomic
+	Egress []networkingv1.NetworkPolicyEgressRule `json:"egress,omitempty"`
+}
+
+// BlueGreenStatus records which color is serving traffic.
+type BlueGreenStatus struct {
+	// ActiveColor is the color the main Service selects.
+	ActiveColor string `json:"activeColor,omitempty"`
+	// PreviewColor is the color being rolled out, if any.
+	PreviewColor string `json:"previewColor,omitempty"`
+	// PreviousColor is the color that was active before the last cutover. It
+	// is kept running until ScaleDownAt.
+	PreviousColor string       `json:"previousColor,omitempty"`
+	ScaleDownAt   *metav1.Time `json:"scaleDownAt,omitempty"`
+	// AbortedImage is the image whose rollout was aborted by a failed
+	// analysis. It is not rolled out again until the image changes.
+	AbortedImage string `json:"abortedImage,omitempty"`
+}
+
+// CanaryPhase is the phase of a Canary rollout.
+type CanaryPhase string
+
+const (
+	CanaryPhaseProgressing CanaryPhase = "Progressing"
+	CanaryPhasePaused      CanaryPhase = "Paused"
+	CanaryPhasePromoting   CanaryPhase = "Promoting"
+	CanaryPhaseAborted     CanaryPhase = "Aborted"
+)
+
+// CanaryStatus records the progress of a Canary rollout.
+type CanaryStatus struct {
+	Phase CanaryPhase `json:"phase,omitempty"`
+	// CanaryImage is the image being rolled out.
+	CanaryImage string `json:"canaryImage,omitempty"`
+	// CurrentStepIndex is the index of the step in spec.canary.steps the
+	// rollout is at.
+	CurrentStepIndex int32        `json:"currentStepIndex"`
+	StepStartedAt    *metav1.Time `json:"stepStartedAt,omitempty"`
+	// CurrentWeight is the percentage of the replicas that run CanaryImage.
+	CurrentWeight  int32 `json:"currentWeight"`
+	StableReplicas int32 `json:"stableReplicas"`
+	CanaryReplicas int32 `json:"canaryReplicas"`
+}
+
+// AnalysisPhase is the outcome of an analysis run or of a measurement.
+type AnalysisPhase string
+
+const (
+	AnalysisPhaseRunning    AnalysisPhase = "Running"
+	AnalysisPhaseSuccessful AnalysisPhase = "Successful"
+	AnalysisPhaseFailed     AnalysisPhase = "Failed"
+	AnalysisPhaseError      AnalysisPhase = "Error"
+)
+
+// Measurement is a single evaluation of an AnalysisMetric.
+type Measurement struct {
+	Metric     string        `json:"metric"`
+	Phase      AnalysisPhase `json:"phase"`
+	Value      string        `json:"value,omitempty"`
+	Message    string        `json:"message,omitempty"`
+	MeasuredAt metav1.Time   `json:"measuredAt"`
+}
+
+// AnalysisRun records the analysis of one Canary step or BlueGreen cutover.
+type AnalysisRun struct {
+	Name  string `json:"name"`
+	Image string `json:"image"`
+	// Revision is the revision being rolled out, and SpecHash a hash of
+	// spec.analysis. Rolling a revision out again, or changing the analysis,
+	// starts a new run.
+	Revision   int64         `json:"revision,omitempty"`
+	SpecHash   string        `json:"specHash,omitempty"`
+	Phase      AnalysisPhase `json:"phase"`
+	StartedAt  metav1.Time   `json:"startedAt"`
+	FinishedAt *metav1.Time  `json:"finishedAt,omitempty"`
+	// Successful and Failed count the measurement rounds by outcome. A round
+	// fails when any of its metrics fails. A round in which a metric cannot
+	// be measured, for example because Prometheus is unreachable, is
+	// counted as Inconclusive and retried until InconclusiveLimit is
+	// exceeded.
+	Successful   int32         `json:"successful"`
+	Failed       int32         `json:"failed"`
+	Inconclusive int32         `json:"inconclusive,omitempty"`
+	Measurements []Measurement `json:"measurements,omitempty"`
+}
+
+// HookType is the point of a rollout a hook runs at.
+type HookType string
+
+const (
+	PreRolloutHook  HookType = "PreRollout"
+	PostRolloutHook HookType = "PostRollout"
+)
+
+// HookPhase is the outcome of a hook run.
+type HookPhase string
+
+const (
+	HookPhaseRunning   HookPhase = "Running"
+	HookPhaseSucceeded HookPhase = "Succeeded"
+	HookPhaseFailed    HookPhase = "Failed"
+)
+
+// HookRun records the Jobs run for a hook of the rollout of a revision.
+type HookRun struct {
+	Type  HookType `json:"type"`
+	Image string   `json:"image"`
+	// Revision is the revision being rolled out, and SpecHash a hash of the
+	// hook and image. Rolling a revision out again, or changing the hook,
+	// starts a new run.
+	Revision int64  `json:"revision,omitempty"`
+	SpecHash string `json:"specHash,omitempty"`
+	// Job is the Job of the last attempt.
+	Job      string    `json:"job"`
+	Phase    HookPhase `json:"phase"`
+	Attempts int32     `json:"attempts,omitempty"`
+	// NextAttemptAt is when the failed attempt is retried.
+	NextAttemptAt *metav1.Time `json:"nextAttemptAt,omitempty"`
+	// Message explains why the Job failed.
+	Message    string       `json:"message,omitempty"`
+	StartedAt  metav1.Time  `json:"startedAt"`
+	FinishedAt *metav1.Time `json:"finishedAt,omitempty"`
+	// Logs is the command that prints the logs of the Job.
+	Logs string `json:"logs,omitempty"`
+}
+
+// SmokeTestStatus reports the smoke test of a revision.
+type SmokeTestStatus struct {
+	Revision int64 `json:"revision"`
+	// Phase is Running while attempts are left, then Succeeded or Failed.
+	Phase    HookPhase `json:"phase"`
+	Attempts int32     `json:"attempts"`
+	// Job is the Job of the last attempt.
+	Job string `json:"job,omitempty"`
+	// NextAttemptAt is when the failed attempt is retried.
+	NextAttemptAt *metav1.Time `json:"nextAttemptAt,omitempty"`
+	// Output is the output of the last failed attempt.
+	Output string `json:"output,omitempty"`
+}
+
+// ContainerTermination describes the most recent termination of a container
+// in a pod of an Evan.
+type ContainerTermination struct {
+	Pod        string      `json:"pod"`
+	Container  string      `json:"container"`
+	Reason     string      `json:"reason,omitempty"`
+	Message    string      `json:"message,omitempty"`
+	ExitCode   int32       `json:"exitCode"`
+	FinishedAt metav1.Time `json:"finishedAt,omitempty"`
+}
+
+// ImageCount is the number of pods running an image.
+type ImageCount struct {
+	Image string `json:"image"`
+	Pods  int32  `json:"pods"`
+}
+
+// PodHealth aggregates the state of the pods of an Evan.
+type PodHealth struct {
+	Pods      int32 `json:"pods"`
+	ReadyPods int32 `json:"readyPods"`
+	// Reasons counts the pods by the reason they are unhealthy, such as
+	// CrashLoopBackOff, ImagePullBackOff or OOMKilled.
+	Reasons map[string]int32 `json:"reasons,omitempty"`
+	// LastTermination is the most recent container termination.
+	LastTermination *ContainerTermination `json:"lastTermination,omitempty"`
+	// Images is the distribution of the images the pods run.
+	Images []ImageCount `json:"images,omitempty"`
+}
+
+// ServiceEndpoints counts the endpoints a Service routes to.
+type ServiceEndpoints struct {
+	Service  string `json:"service"`
+	Ready    int32  `json:"ready"`
+	NotReady int32  `json:"notReady"`
+}
+
+// AddressType is the way an EvanAddress is reached.
+type AddressType string
+
+const (
+	// AddressTypeClusterIP is reachable from inside the cluster.
+	AddressTypeClusterIP AddressType = "ClusterIP"
+	// AddressTypeNodePort is reachable on the port of every node.
+	AddressTypeNodePort AddressType = "NodePort"
+	// AddressTypeLoadBalancerIP and AddressTypeLoadBalancerHostname are
+	// assigned by the load balancer of a LoadBalancer Service.
+	AddressTypeLoadBalancerIP       AddressType = "LoadBalancerIP"
+	AddressTypeLoadBalancerHostname AddressType = "LoadBalancerHostname"
+)
+
+// EvanAddress is an address the Service of an Evan can be reached at.
+type EvanAddress struct {
+	Type AddressType `json:"type"`
+	// Host is an IP or a hostname. It is empty for NodePort addresses.
+	Host string `json:"host,omitempty"`
+	Port int32  `json:"port"`
+	// URL is set when the port speaks HTTP or HTTPS, for example
+	// http://10.96.0.12:4444.
+	URL string `json:"url,omitempty"`
 }
 
+// Condition types of an Evan.
+const (
+	// EvanConditionProgressing is True while a rollout is in progress or has
+	// completed, and False when it failed.
+	EvanConditionProgressing = "Progressing"
+	// EvanConditionAvailable mirrors the Available condition of the
+	// Deployment serving traffic.
+	EvanConditionAvailable = "Available"
+	// EvanConditionServiceReady is True when the Service has at least one
+	// ready endpoint to route to.
+	EvanConditionServiceReady = "ServiceReady"
+	// EvanConditionStorageBound is True when the PersistentVolumeClaim of
+	// spec.storage is bound to a volume every pod of the Evan can mount.
+	EvanConditionStorageBound = "StorageBound"
+	// EvanConditionReady is True when the current revision is rolled out and
+	// its smoke test, if any, succeeded.
+	EvanConditionReady = "Ready"
+	// EvanConditionDependenciesReady is True when every Evan of
+	// spec.dependsOn is Ready.
+	EvanConditionDependenciesReady = "DependenciesReady"
+)
+
 // EvanStatus is the status for an Evan resource
 type EvanStatus struct {
-	AvailableReplicas int32 `json:"availableReplicas"`
+	AvailableReplicas int32            `json:"availableReplicas"`
+	BlueGreen         *BlueGreenStatus `json:"blueGreen,omitempty"`
+	Canary            *CanaryStatus    `json:"canary,omitempty"`
+	// AnalysisRuns holds the most recent analysis runs, oldest first.
+	AnalysisRuns []AnalysisRun `json:"analysisRuns,omitempty"`
+	// HookRuns holds the most recent hook runs, oldest first.
+	HookRuns []HookRun `json:"hookRuns,omitempty"`
+	// SmokeTest reports the smoke test of the current revision.
+	SmokeTest *SmokeTestStatus `json:"smokeTest,omitempty"`
+	// CurrentRevision is the revision of the ControllerRevision that records
+	// the current deploymentConfig and serviceConfig.
+	CurrentRevision int64 `json:"currentRevision,omitempty"`
+	// LastGoodRevision is the last revision that was rolled out successfully.
+	LastGoodRevision int64 `json:"lastGoodRevision,omitempty"`
+
+	PodHealth *PodHealth `json:"podHealth,omitempty"`
+	// Endpoints reports the endpoints of each Service of the Evan, as seen in
+	// their EndpointSlices.
+	Endpoints []ServiceEndpoints `json:"endpoints,omitempty"`
+	// Addresses are the addresses the Service of the Evan can be reached at.
+	Addresses []EvanAddress `json:"addresses,omitempty"`
+
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
 }
 
 // +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object